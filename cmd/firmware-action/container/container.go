@@ -8,11 +8,12 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strings"
 
 	"dagger.io/dagger"
@@ -27,18 +28,24 @@ var (
 	errDirectoryInvalid      = errors.New("host directory cannot be mounted into '/' or '.' in the container")
 	errExportFailed          = errors.New("failed to export artifacts from container")
 	errContainerDiscontinued = errors.New("the used container is discontinued")
+	errNotInteractive        = errors.New("not running in an interactive terminal")
+	errExportImageFailed     = errors.New("failed to export container image")
+	errArtifactMissing       = errors.New("artifact not found in container")
+	errArtifactCollision     = errors.New("multiple artifacts would be exported to the same path")
 )
 
 // SetupOpts congregates options for Setup function
 // None of the values can be empty string, and mountContainerDir cannot be '.' or '/'
 type SetupOpts struct {
-	ContainerURL      string   // URL or name of docker container
-	MountHostDir      string   // Directory from host to mount into container
-	MountContainerDir string   // Where to mount ^^^ host directory inside container
-	WorkdirContainer  string   // Workdir of the container
-	ContainerInputDir string   // Directory for input files
-	InputDirs         []string // List of directories to copy into container
-	InputFiles        []string // List of files to copy into container
+	ContainerURL      string            // URL or name of docker container
+	MountHostDir      string            // Directory from host to mount into container
+	MountContainerDir string            // Where to mount ^^^ host directory inside container
+	WorkdirContainer  string            // Workdir of the container
+	ContainerInputDir string            // Directory for input files
+	InputDirs         []string          // List of directories to copy into container
+	InputFiles        []string          // List of files to copy into container
+	EnvVars           map[string]string // Environment variables to define in container
+	Secrets           map[string]string // Environment variables to define in container as secrets
 }
 
 // Validate the data in struct
@@ -239,6 +246,19 @@ func Setup(ctx context.Context, client *dagger.Client, opts *SetupOpts) (*dagger
 		).
 		WithWorkdir(opts.WorkdirContainer)
 
+	// Setup environment variables
+	//   iterate in sorted order to keep the container definition stable (and cacheable)
+	for _, key := range slices.Sorted(maps.Keys(opts.EnvVars)) {
+		container = container.WithEnvVariable(key, opts.EnvVars[key])
+	}
+
+	// Setup secrets
+	//   Dagger scrubs values of secrets from all logs and outputs
+	for _, key := range slices.Sorted(maps.Keys(opts.Secrets)) {
+		slog.Debug(fmt.Sprintf("Passing secret '%s' into container", key))
+		container = container.WithSecretVariable(key, client.SetSecret(key, opts.Secrets[key]))
+	}
+
 	// Get current working directory
 	pwd, err := os.Getwd()
 	if err != nil {
@@ -324,13 +344,197 @@ func Setup(ctx context.Context, client *dagger.Client, opts *SetupOpts) (*dagger
 	return container, err
 }
 
+// transientErrorPatterns are substrings of errors caused by dagger engine or network hiccups,
+// these usually go away when the same operation is attempted again
+var transientErrorPatterns = []string{
+	"i/o timeout",
+	"timed out waiting for session params",
+	"connection reset by peer",
+	"connection refused",
+	"TLS handshake timeout",
+	"unexpected EOF",
+	"429 Too Many Requests",
+	"502 Bad Gateway",
+	"503 Service Unavailable",
+	"504 Gateway Timeout",
+}
+
+// IsTransientError returns true if error looks like a temporary failure of dagger engine or network,
+// for example session timeout or failed image pull
+// Failures of commands executed inside the container are never considered transient
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var execErr *dagger.ExecError
+	if errors.As(err, &execErr) {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	for _, pattern := range transientErrorPatterns {
+		if strings.Contains(err.Error(), pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ImageReference returns fully resolved reference of the container image (including digest)
+// Images built from Dockerfile or imported from tarball have no such reference, their URL is returned as is
+func ImageReference(ctx context.Context, client *dagger.Client, url string) string {
+	address, mode, err := detectMode(url)
+	if err != nil || mode != ModeURL {
+		return url
+	}
+
+	ref, err := client.Container().From(address).ImageRef(ctx)
+	if err != nil {
+		slog.Warn(
+			fmt.Sprintf("Failed to resolve reference of container image '%s'", url),
+			slog.Any("error", err),
+		)
+
+		return url
+	}
+
+	return ref
+}
+
+// OpenTerminal opens an interactive shell in the container and waits until user exits it
+func OpenTerminal(ctx context.Context, container *dagger.Container, noNetwork bool) error {
+	// Without interactive terminal on our side there is nothing to attach the shell to
+	stdinInfo, err := os.Stdin.Stat()
+	if err != nil || stdinInfo.Mode()&os.ModeCharDevice == 0 {
+		slog.Warn(
+			"Not running in an interactive terminal, can't open a shell in the container",
+			slog.String("suggestion", "run firmware-action from an interactive terminal"),
+		)
+
+		return errNotInteractive
+	}
+
+	terminalOpts := dagger.ContainerTerminalOpts{Cmd: []string{"bash"}}
+	if noNetwork {
+		// The same isolation as for build steps, see WithExecNoNetwork
+		terminalOpts = dagger.ContainerTerminalOpts{
+			Cmd:                      noNetworkArgs([]string{"bash"}),
+			InsecureRootCapabilities: true,
+		}
+	}
+
+	_, err = container.Terminal(terminalOpts).Sync(ctx)
+
+	return err
+}
+
+// ExportFailedOpts specifies what to export from a container after failed build
+type ExportFailedOpts struct {
+	TarballPath    string     // Path on host where to write the container image (OCI tarball)
+	ScriptPath     string     // Path on host where to write the script with remaining build steps
+	MountDir       string     // Directory mounted from host, its content must be copied into the image
+	RemainingSteps [][]string // Build steps starting with the failed one
+	NoNetwork      bool       // The build steps were executed without network access
+}
+
+// ExportFailed exports the container as OCI tarball together with a script containing
+// the remaining build steps, so that the failure can be reproduced locally
+func ExportFailed(ctx context.Context, container *dagger.Container, opts ExportFailedOpts) error {
+	// Mounted directories are not part of exported image, so the mount has to be replaced
+	// with a copy of its current content (including changes made by the build steps)
+	mountContent := container.Directory(opts.MountDir)
+	container = container.
+		WithoutMount(opts.MountDir).
+		WithDirectory(opts.MountDir, mountContent)
+
+	workdir, err := container.Workdir(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errExportImageFailed, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.TarballPath), 0o755); err != nil {
+		return err
+	}
+
+	if _, err := container.Export(ctx, opts.TarballPath); err != nil {
+		return fmt.Errorf("%w: %w: %s", errExportImageFailed, err, opts.TarballPath)
+	}
+
+	script := remainingStepsScript(workdir, opts)
+	if err := os.WriteFile(opts.ScriptPath, []byte(script), 0o755); err != nil {
+		return err
+	}
+
+	slog.Info(
+		"Exported container in state just before the failed build step",
+		slog.String("image", opts.TarballPath),
+		slog.String("script", opts.ScriptPath),
+		slog.String("suggestion", fmt.Sprintf("load the image with 'docker load --input %s' and see %s for instructions", opts.TarballPath, opts.ScriptPath)),
+	)
+
+	return nil
+}
+
+// remainingStepsScript returns content of shell script which executes the remaining build steps
+func remainingStepsScript(workdir string, opts ExportFailedOpts) string {
+	runOpts := ""
+	if opts.NoNetwork {
+		runOpts = " --network none"
+	}
+
+	scriptName := filepath.Base(opts.ScriptPath)
+
+	var script strings.Builder
+
+	script.WriteString("#!/usr/bin/env bash\n")
+	script.WriteString("# Build steps remaining after firmware-action build failed, starting with the failed one\n")
+	script.WriteString("#\n")
+	script.WriteString("# To reproduce the failure, load the image and start the container:\n")
+	fmt.Fprintf(&script, "#   docker load --input %s\n", filepath.Base(opts.TarballPath))
+	fmt.Fprintf(&script, "#   docker run --rm -it%s --volume \"$(pwd)/%s:/tmp/%s\" <IMAGE> bash\n", runOpts, scriptName, scriptName)
+	script.WriteString("# and then inside the container:\n")
+	fmt.Fprintf(&script, "#   bash /tmp/%s\n", scriptName)
+	script.WriteString("#\n")
+	script.WriteString("# Secrets are not part of the image, export them manually if needed.\n")
+	script.WriteString("set -ex\n\n")
+	fmt.Fprintf(&script, "cd %s\n", shellQuote(workdir))
+
+	for _, step := range opts.RemainingSteps {
+		quoted := make([]string, len(step))
+		for i, arg := range step {
+			quoted[i] = shellQuote(arg)
+		}
+
+		script.WriteString(strings.Join(quoted, " ") + "\n")
+	}
+
+	return script.String()
+}
+
+// shellQuote quotes string for use in POSIX shell, if needed
+func shellQuote(arg string) string {
+	if arg != "" && strings.IndexFunc(arg, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:,+@%", r))
+	}) == -1 {
+		return arg
+	}
+
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
 // Artifacts is passes to GetArtifacts as argument, and specifies extraction of files
 // form container at containerDir to host at hostDir
 type Artifacts struct {
-	ContainerPath string // Path inside container
+	ContainerPath string // Path inside container, for files it can be a glob pattern (for example 'Build/**/*.fd')
 	ContainerDir  bool   // Is ^^^ path directory?
 	HostPath      string // Path inside host
-	HostDir       bool   // Is ^^^ path directory?
+	HostDir       bool   // Is ^^^ path directory? Must be true for glob patterns
+	Optional      bool   // Skip the artifact if it does not exist (or pattern does not match anything)
 }
 
 // GetArtifacts extracts files from container to host
@@ -347,9 +551,36 @@ func GetArtifacts(ctx context.Context, container *dagger.Container, artifacts *[
 		var err error
 
 		if artifact.HostDir {
-			if err := os.MkdirAll(artifact.HostPath, 0o755); err != nil {
+			err = os.MkdirAll(artifact.HostPath, 0o755)
+		} else {
+			err = os.MkdirAll(filepath.Dir(artifact.HostPath), 0o755)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// Files matching glob pattern
+		if !artifact.ContainerDir && IsGlobPattern(artifact.ContainerPath) {
+			if err := getGlobArtifacts(ctx, container, artifact); err != nil {
 				return err
 			}
+
+			continue
+		}
+
+		// Missing optional artifacts are skipped
+		if artifact.Optional {
+			exists, err := container.Exists(ctx, artifact.ContainerPath)
+			if err != nil {
+				return fmt.Errorf("%w: %w: %s", errExportFailed, err, artifact.ContainerPath)
+			}
+
+			if !exists {
+				slog.Warn(fmt.Sprintf("Optional artifact '%s' not found in container, skipping", artifact.ContainerPath))
+
+				continue
+			}
 		}
 
 		// Export
@@ -383,6 +614,96 @@ func GetArtifacts(ctx context.Context, container *dagger.Container, artifacts *[
 	return nil
 }
 
+// IsGlobPattern returns true if path contains glob pattern
+func IsGlobPattern(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// splitGlobPattern splits path into directory without any glob pattern and the remaining pattern
+// For example '/workdir/Build/**/*.fd' is split into '/workdir/Build' and '**/*.fd'
+func splitGlobPattern(path string) (string, string) {
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	for i, part := range parts {
+		if IsGlobPattern(part) {
+			return filepath.FromSlash(strings.Join(parts[:i], "/")), strings.Join(parts[i:], "/")
+		}
+	}
+
+	return filepath.Dir(path), filepath.Base(path)
+}
+
+// getGlobArtifacts exports all files matching glob pattern into host directory
+func getGlobArtifacts(ctx context.Context, container *dagger.Container, artifact Artifacts) error {
+	baseDir, pattern := splitGlobPattern(artifact.ContainerPath)
+	if baseDir == "" {
+		baseDir = "/"
+	}
+
+	directory := container.Directory(baseDir)
+
+	matches, err := directory.Glob(ctx, pattern)
+	if err != nil {
+		return fmt.Errorf("%w: %w: %s", errExportFailed, err, artifact.ContainerPath)
+	}
+
+	exported := map[string]string{} // Map of host path -> container path
+
+	for _, match := range matches {
+		// Glob matches also directories, only files are exported
+		isFile, err := directory.Exists(ctx, match, dagger.DirectoryExistsOpts{ExpectedType: dagger.ExistsTypeRegularType})
+		if err != nil {
+			return fmt.Errorf("%w: %w: %s", errExportFailed, err, match)
+		}
+
+		if !isFile {
+			continue
+		}
+
+		containerPath := filepath.Join(baseDir, match)
+		hostPath := filepath.Join(artifact.HostPath, filepath.Base(match))
+
+		if previous, ok := exported[hostPath]; ok {
+			err := fmt.Errorf("%w: '%s' and '%s' -> '%s'", errArtifactCollision, previous, containerPath, hostPath)
+			slog.Error(
+				"Glob pattern matched multiple files with the same name",
+				slog.String("pattern", artifact.ContainerPath),
+				slog.String("suggestion", "make the pattern more specific, or export the files one by one with 'to' to rename them"),
+				slog.Any("error", err),
+			)
+
+			return err
+		}
+
+		exported[hostPath] = containerPath
+
+		_, err = directory.File(match).Export(ctx, hostPath)
+		if err != nil {
+			return fmt.Errorf("%w: %w: %s -> %s", errExportFailed, err, containerPath, hostPath)
+		}
+
+		slog.Debug(fmt.Sprintf("Artifact export: %s -> %s", containerPath, hostPath))
+	}
+
+	if len(exported) == 0 {
+		if artifact.Optional {
+			slog.Warn(fmt.Sprintf("Optional artifact pattern '%s' did not match any file in container, skipping", artifact.ContainerPath))
+
+			return nil
+		}
+
+		err := fmt.Errorf("%w: pattern '%s' did not match any file", errArtifactMissing, artifact.ContainerPath)
+		slog.Error(
+			"No artifact found in container",
+			slog.String("suggestion", "double check the pattern in 'container_output_files', or mark it as optional"),
+			slog.Any("error", err),
+		)
+
+		return err
+	}
+
+	return nil
+}
+
 // CleanupAfterContainer performs cleanup operations after container use
 func CleanupAfterContainer(ctx context.Context) error {
 	// Unfortunately it is not possible to only remove the container used for building the module.
@@ -395,121 +716,54 @@ func CleanupAfterContainer(ctx context.Context) error {
 	// If user wants to build complex firmware stacks in single job recursively, they will easily run
 	//   out of disk space.
 	//
+	// Only containers and volumes owned by Dagger are removed, other resources of the user are left alone.
+	//
 	// WARNING: This will completely stop the Dagger engine. Any subsequent Dagger
 	//   operations will need to reinitialize the Dagger client.
-	slog.Info("Cleaning up Dagger container resources")
-
-	// Step 1: Find the Dagger engine container
-	findCmd := exec.CommandContext(ctx, "docker", "container", "ls", "--filter", "name=dagger-engine", "--format", "{{.ID}}")
-
-	containerID, err := findCmd.Output()
+	containerRuntime, engineName, err := DetectRuntime()
 	if err != nil {
 		slog.Error(
-			"Failed to find Dagger engine container",
+			"Failed to find Dagger engine container to clean up",
+			slog.String("suggestion", fmt.Sprintf("cleanup is supported only for Docker and Podman, with engine either provisioned by Dagger or selected with '%s' set to 'docker-container://' or 'podman-container://'", daggerRunnerHostEnv)),
 			slog.Any("error", err),
 		)
 
 		return err
 	}
 
-	containerIDStr := strings.TrimSpace(string(containerID))
-	if containerIDStr == "" {
-		slog.Info("No Dagger engine container found to clean up")
-		return nil
-	}
-
-	// Step 2: Stop the Dagger engine container
-	slog.Debug(
-		"Stopping Dagger engine container",
-		slog.String("containerID", containerIDStr),
+	slog.Info(
+		"Cleaning up Dagger container resources",
+		slog.String("runtime", containerRuntime.Name()),
 	)
-	stopCmd := exec.CommandContext(ctx, "docker", "container", "stop", containerIDStr)
 
-	stopOutput, err := stopCmd.CombinedOutput()
-	if err != nil {
-		slog.Error(
-			"Failed to stop Dagger engine container",
-			slog.String("output", strings.TrimSpace(string(stopOutput))),
-			slog.Any("error", err),
-		)
-
-		return err
-	}
-
-	// Step 3: Remove the Dagger engine container
-	slog.Debug(
-		"Removing Dagger engine container",
-		slog.String("containerID", containerIDStr),
-	)
-	rmCmd := exec.CommandContext(ctx, "docker", "container", "rm", containerIDStr)
+	// Disk usage is only informative, failing to get it is not a reason to stop
+	usageBefore, errBefore := containerRuntime.DiskUsage(ctx)
 
-	rmOutput, err := rmCmd.CombinedOutput()
+	err = cleanupDaggerEngine(ctx, containerRuntime, engineName)
 	if err != nil {
-		slog.Error(
-			"Failed to remove Dagger engine container",
-			slog.String("output", strings.TrimSpace(string(rmOutput))),
-			slog.Any("error", err),
-		)
-
 		return err
 	}
 
-	// Step 4: Find and remove Dagger volumes
-	volCmd := exec.CommandContext(ctx, "docker", "volume", "ls", "--filter", "dangling=true", "--format", "{{.Name}}")
-
-	volumes, err := volCmd.Output()
-	if err != nil {
+	usageAfter, errAfter := containerRuntime.DiskUsage(ctx)
+	if err := errors.Join(errBefore, errAfter); err != nil {
 		slog.Warn(
-			"Failed to list Docker volumes",
+			"Failed to get disk usage, can't tell how much space was reclaimed",
 			slog.Any("error", err),
 		)
-		// Continue even if this fails
-	} else {
-		volumeList := strings.SplitSeq(strings.TrimSpace(string(volumes)), "\n")
-		for vol := range volumeList {
-			if vol == "" {
-				continue
-			}
+		slog.Info("Dagger container resources cleaned up successfully")
 
-			slog.Debug(
-				"Removing Docker volume",
-				slog.String("volume", vol),
-			)
-			rmVolCmd := exec.CommandContext(ctx, "docker", "volume", "rm", vol)
-
-			rmVolOutput, err := rmVolCmd.CombinedOutput()
-			if err != nil {
-				slog.Warn(
-					"Failed to remove Docker volume",
-					slog.String("volume", vol),
-					slog.String("output", strings.TrimSpace(string(rmVolOutput))),
-					slog.Any("error", err),
-				)
-				// Continue with other volumes even if one fails
-			}
-		}
+		return nil
 	}
 
-	// Step 5: Run system prune to clean up any remaining resources
-	pruneCmd := exec.CommandContext(ctx, "docker", "system", "prune", "-f")
-	pruneOutput, err := pruneCmd.CombinedOutput()
-	slog.Debug(
-		"Docker system prune output",
-		slog.String("command", "docker system prune -f"),
-		slog.String("output", strings.TrimSpace(string(pruneOutput))),
-	)
-
-	if err != nil {
-		slog.Error(
-			"Failed to prune Docker system",
-			slog.String("output", strings.TrimSpace(string(pruneOutput))),
-			slog.Any("error", err),
-		)
-
-		return err
+	var reclaimed uint64
+	if usageBefore > usageAfter {
+		reclaimed = usageBefore - usageAfter
 	}
 
-	slog.Info("Dagger container resources cleaned up successfully")
+	slog.Info(
+		"Dagger container resources cleaned up successfully",
+		slog.String("reclaimed", formatHumanSize(reclaimed)),
+	)
 
 	return nil
 }
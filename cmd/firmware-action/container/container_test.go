@@ -6,9 +6,12 @@
 package container
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"dagger.io/dagger"
@@ -628,3 +631,102 @@ func TestCheckIfDiscontinued(t *testing.T) {
 		})
 	}
 }
+
+func TestOpenTerminalNotInteractive(t *testing.T) {
+	// Replace stdin with regular file to simulate non-interactive environment (like CI)
+	stdin, err := os.CreateTemp(t.TempDir(), "stdin")
+	assert.NoError(t, err)
+
+	defer stdin.Close()
+
+	originalStdin := os.Stdin
+	os.Stdin = stdin
+
+	defer func() { os.Stdin = originalStdin }()
+
+	// Container is never touched in non-interactive environment
+	err = OpenTerminal(t.Context(), nil, false)
+	assert.ErrorIs(t, err, errNotInteractive)
+}
+
+func TestShellQuote(t *testing.T) {
+	testCases := []struct {
+		name string
+		arg  string
+		want string
+	}{
+		{name: "plain", arg: "make", want: "make"},
+		{name: "path with variable assignment", arg: "CONFIG_FILE=/tmp/defconfig", want: "CONFIG_FILE=/tmp/defconfig"},
+		{name: "empty", arg: "", want: "''"},
+		{name: "spaces", arg: "echo hello", want: "'echo hello'"},
+		{name: "single quote", arg: "it's", want: `'it'\''s'`},
+		{name: "shell expansion", arg: "$HOME", want: "'$HOME'"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, shellQuote(tc.arg))
+		})
+	}
+}
+
+func TestRemainingStepsScript(t *testing.T) {
+	script := remainingStepsScript("/workdir", ExportFailedOpts{
+		TarballPath: "out/failed.tar",
+		ScriptPath:  "out/failed.sh",
+		RemainingSteps: [][]string{
+			{"make", "-j", "4"},
+			{"bash", "-c", "echo 'done'"},
+		},
+		NoNetwork: true,
+	})
+
+	assert.True(t, strings.HasPrefix(script, "#!/usr/bin/env bash\n"))
+	assert.Contains(t, script, "docker load --input failed.tar")
+	assert.Contains(t, script, "--network none")
+	assert.True(t, strings.HasSuffix(script, "cd /workdir\nmake -j 4\nbash -c 'echo '\\''done'\\'''\n"))
+}
+
+func TestSplitGlobPattern(t *testing.T) {
+	testCases := []struct {
+		path        string
+		wantDir     string
+		wantPattern string
+	}{
+		{path: "/workdir/build/*.rom", wantDir: "/workdir/build", wantPattern: "*.rom"},
+		{path: "/workdir/Build/**/*.fd", wantDir: "/workdir/Build", wantPattern: "**/*.fd"},
+		{path: "/workdir/Build/*/FV/OVMF.fd", wantDir: "/workdir/Build", wantPattern: "*/FV/OVMF.fd"},
+		{path: "/workdir/coreboot.rom", wantDir: "/workdir", wantPattern: "coreboot.rom"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			dir, pattern := splitGlobPattern(tc.path)
+			assert.Equal(t, tc.wantDir, dir)
+			assert.Equal(t, tc.wantPattern, pattern)
+		})
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "no error", err: nil, want: false},
+		{name: "session timeout", err: errors.New("timed out waiting for session params"), want: true},
+		{name: "image pull", err: errors.New("failed to do request: dial tcp: i/o timeout"), want: true},
+		{name: "registry overloaded", err: errors.New("unexpected status: 503 Service Unavailable"), want: true},
+		{name: "deadline", err: fmt.Errorf("sync: %w", context.DeadlineExceeded), want: true},
+		{name: "missing file", err: errors.New("no such file or directory"), want: false},
+		{
+			name: "build step",
+			err:  errors.Join(errors.New("i/o timeout"), &dagger.ExecError{ExitCode: 2}),
+			want: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, IsTransientError(tc.err))
+		})
+	}
+}
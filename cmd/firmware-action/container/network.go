@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: MIT
+
+// Package container / network
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// ErrNoNetworkToolsMissing is returned when the container lacks tools needed to disable network
+var ErrNoNetworkToolsMissing = errors.New("container is missing tools needed to disable network")
+
+// noNetworkTools are executed by noNetworkScript and noNetworkIsolationScript
+var noNetworkTools = []string{"unshare", "setpriv", "mount", "find", "awk"}
+
+// noNetworkToolsScript prints all tools ('$@') which are not present in the container
+const noNetworkToolsScript = `for tool in "$@"; do
+	command -v "$tool" >/dev/null 2>&1 || echo "$tool"
+done`
+
+// noNetworkScript executes the command ('$2'...) in new network and mount namespace,
+// where it is confined by isolation script ('$1')
+const noNetworkScript = `isolation=$1
+shift
+exec unshare --net --mount -- sh -c "$isolation" firmware-action-isolation "$@"`
+
+// noNetworkIsolationScript runs inside the new namespaces, still with all capabilities. It brings
+// the environment back to what an unprivileged container looks like and executes the command with
+// the default capabilities of unprivileged container, minus 'mknod' and 'net_raw':
+//   - read-only and masked paths in '/proc' and '/sys' (defaults of runc)
+//   - host devices in '/dev' are replaced with '/dev/null'
+//   - capabilities needed to leave the namespace (sys_admin) or to change network (net_admin)
+//     are dropped from the bounding set, and no_new_privs prevents getting them back
+//
+// Mounts are private to the mount namespace, nothing of this is visible to other build steps
+const noNetworkIsolationScript = `set -e
+for path in /proc/bus /proc/fs /proc/irq /proc/sys /proc/sysrq-trigger; do
+	if [ -e "$path" ]; then
+		mount --bind "$path" "$path"
+		mount -o remount,bind,ro "$path"
+	fi
+done
+for path in $(awk '$2 ~ "^/sys(/|$)" { print $2 }' /proc/self/mounts); do
+	mount -o remount,bind,ro "$path"
+done
+for path in /proc/kcore /proc/keys /proc/latency_stats /proc/sched_debug /proc/timer_list /proc/timer_stats; do
+	if [ -e "$path" ]; then
+		mount --bind /dev/null "$path"
+	fi
+done
+for path in /proc/acpi /proc/scsi /sys/firmware; do
+	if [ -d "$path" ]; then
+		mount -t tmpfs -o ro tmpfs "$path"
+	fi
+done
+find /dev -xdev \( -type b -o -type c \) | while read -r device; do
+	case "$device" in
+	/dev/null | /dev/zero | /dev/full | /dev/random | /dev/urandom | /dev/tty | /dev/ptmx) ;;
+	*) mount --bind /dev/null "$device" ;;
+	esac
+done
+exec setpriv --no-new-privs --inh-caps=-all --ambient-caps=-all \
+	--bounding-set=-all,+audit_write,+chown,+dac_override,+fowner,+fsetid,+kill,+net_bind_service,+setfcap,+setgid,+setpcap,+setuid,+sys_chroot \
+	-- "$@"`
+
+// noNetworkArgs wraps command so that it is executed without access to network
+func noNetworkArgs(args []string) []string {
+	return append([]string{"sh", "-c", noNetworkScript, "firmware-action-no-network", noNetworkIsolationScript}, args...)
+}
+
+// CheckNoNetworkTools checks that the container contains all tools needed by WithExecNoNetwork.
+// Meant to be called once when setting up the container, not before every command.
+func CheckNoNetworkTools(ctx context.Context, container *dagger.Container) error {
+	args := append([]string{"sh", "-c", noNetworkToolsScript, "firmware-action-tools"}, noNetworkTools...)
+	stdout, err := container.WithExec(args).Stdout(ctx)
+	if err != nil {
+		slog.Error(
+			"Failed to check tools needed to disable network",
+			slog.String("suggestion", "The container must contain 'sh' to disable network"),
+			slog.Any("error", err),
+		)
+		return err
+	}
+
+	missing := strings.Fields(stdout)
+	if len(missing) > 0 {
+		slog.Error(
+			"Container is missing tools needed to disable network",
+			slog.String("suggestion", "Install 'unshare', 'setpriv' and 'mount' (util-linux), 'find' and 'awk' into the container, or enable network for this module"),
+			slog.Any("missing", missing),
+		)
+		return fmt.Errorf("%w: %s", ErrNoNetworkToolsMissing, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// WithExecNoNetwork executes command in the container without access to network
+//
+// Dagger does not offer a way to disable networking for a container, so the command is
+// executed in a new network namespace (with only loopback interface) created by 'unshare'.
+// Creating the namespace requires CAP_SYS_ADMIN, which Dagger grants only together with
+// its insecure mode: the command runs without seccomp and AppArmor profiles and with host
+// devices. noNetworkIsolationScript restricts this again (read-only '/proc' and '/sys', no host
+// devices, reduced capabilities), but the lack of seccomp and AppArmor can't be undone.
+// This is a trade-off, no network for the price of weaker confinement than a normal build step.
+// The container must contain the tools checked by CheckNoNetworkTools.
+func WithExecNoNetwork(container *dagger.Container, args []string) *dagger.Container {
+	return container.WithExec(
+		noNetworkArgs(args),
+		dagger.ContainerWithExecOpts{InsecureRootCapabilities: true},
+	)
+}
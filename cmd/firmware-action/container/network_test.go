@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: MIT
+
+//go:build go1.24
+
+// Package container
+package container
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"dagger.io/dagger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithExecNoNetwork(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	ctx := t.Context()
+	client, err := dagger.Connect(ctx, dagger.WithLogOutput(os.Stdout))
+	assert.NoError(t, err)
+
+	defer client.Close()
+
+	// Debian contains util-linux, findutils and awk out of the box
+	myContainer := client.Container().From("debian:stable-slim")
+
+	testCases := []struct {
+		name    string
+		cmd     []string
+		wantErr bool
+	}{
+		{
+			name: "only loopback interface is present",
+			cmd:  []string{"sh", "-c", "! grep -v -e '^Inter-' -e '^ face' -e '^ *lo:' /proc/net/dev"},
+		},
+		{
+			name:    "connection to internet fails",
+			cmd:     []string{"bash", "-c", "exec 3<>/dev/tcp/1.1.1.1/53"},
+			wantErr: true,
+		},
+		{
+			name:    "can't return into network namespace of the container",
+			cmd:     []string{"nsenter", "--net=/proc/1/ns/net", "true"},
+			wantErr: true,
+		},
+		{
+			name:    "can't mount",
+			cmd:     []string{"mount", "-t", "tmpfs", "tmpfs", "/mnt"},
+			wantErr: true,
+		},
+		{
+			name:    "kernel parameters are read-only",
+			cmd:     []string{"sh", "-c", "echo firmware-action > /proc/sys/kernel/domainname"},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := WithExecNoNetwork(myContainer, tc.cmd).Sync(ctx)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	// Changes made by the command must be kept
+	contents, err := WithExecNoNetwork(myContainer, []string{"sh", "-c", "echo hello > /hello.txt"}).
+		File("/hello.txt").
+		Contents(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello\n", contents)
+
+	// All tools are present in Debian, busybox lacks 'unshare' and 'setpriv'
+	assert.NoError(t, CheckNoNetworkTools(ctx, myContainer))
+	err = CheckNoNetworkTools(ctx, client.Container().From("busybox:latest"))
+	assert.ErrorIs(t, err, ErrNoNetworkToolsMissing)
+	assert.ErrorContains(t, err, "setpriv")
+}
+
+func TestNoNetworkArgs(t *testing.T) {
+	// Runs the scripts on host with stubs instead of the tools which need privileges:
+	// 'unshare' and 'setpriv' only execute the command after '--', 'mount' does nothing
+	stubDir := t.TempDir()
+	stubs := map[string]string{
+		"unshare": "while [ \"$1\" != -- ]; do shift; done\nshift\nexec \"$@\"\n",
+		"setpriv": "while [ \"$1\" != -- ]; do shift; done\nshift\nexec \"$@\"\n",
+		"mount":   "exit 0\n",
+	}
+	for name, script := range stubs {
+		err := os.WriteFile(filepath.Join(stubDir, name), []byte("#!/bin/sh\n"+script), 0o755)
+		assert.NoError(t, err)
+	}
+	t.Setenv("PATH", stubDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	testCases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "simple arguments",
+			args: []string{"make", "-j", "4"},
+			want: "<make>\n<-j>\n<4>\n",
+		},
+		{
+			name: "spaces and empty argument",
+			args: []string{"two words", "", "  padded  "},
+			want: "<two words>\n<>\n<  padded  >\n",
+		},
+		{
+			name: "shell syntax is not interpreted",
+			args: []string{"$HOME", "`id`", "$(id)", "a;b", "*", "'single'", "\"double\"", "back\\slash"},
+			want: "<$HOME>\n<`id`>\n<$(id)>\n<a;b>\n<*>\n<'single'>\n<\"double\">\n<back\\slash>\n",
+		},
+		{
+			name: "newline in argument",
+			args: []string{"line1\nline2"},
+			want: "<line1\nline2>\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := noNetworkArgs(append([]string{"printf", "<%s>\\n"}, tc.args...))
+			out, err := exec.Command(args[0], args[1:]...).Output()
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, string(out))
+		})
+	}
+
+	// Failure of the command is propagated
+	args := noNetworkArgs([]string{"sh", "-c", "exit 3"})
+	err := exec.Command(args[0], args[1:]...).Run()
+	var exitErr *exec.ExitError
+	if assert.ErrorAs(t, err, &exitErr) {
+		assert.Equal(t, 3, exitErr.ExitCode())
+	}
+}
@@ -0,0 +1,364 @@
+// SPDX-License-Identifier: MIT
+
+// Package container / runtime
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	errNoRuntime          = errors.New("no supported container runtime found")
+	errUnsupportedRuntime = errors.New("unsupported container runtime")
+	errParseSize          = errors.New("failed to parse size")
+)
+
+// daggerRunnerHostEnv is environment variable which Dagger uses to select custom engine
+const daggerRunnerHostEnv = "_EXPERIMENTAL_DAGGER_RUNNER_HOST"
+
+// daggerEngineNamePrefix is prefix of names of containers which Dagger provisions for its engine
+const daggerEngineNamePrefix = "dagger-engine"
+
+// ContainerInfo describes a container managed by container runtime
+type ContainerInfo struct {
+	ID   string
+	Name string
+}
+
+// Runtime is a container runtime hosting the Dagger engine
+type Runtime interface {
+	// Name returns human-readable name of the runtime
+	Name() string
+	// ListContainers returns all containers, including stopped ones
+	ListContainers(ctx context.Context) ([]ContainerInfo, error)
+	// ListContainerVolumes returns names of volumes mounted into the container
+	ListContainerVolumes(ctx context.Context, containerID string) ([]string, error)
+	// StopContainer stops the container
+	StopContainer(ctx context.Context, containerID string) error
+	// RemoveContainer removes the container
+	RemoveContainer(ctx context.Context, containerID string) error
+	// RemoveVolume removes the volume
+	RemoveVolume(ctx context.Context, volume string) error
+	// DiskUsage returns disk space in bytes taken by images, containers, volumes and build cache
+	DiskUsage(ctx context.Context) (uint64, error)
+}
+
+// cliRuntime implements Runtime on top of docker-compatible command line interface
+type cliRuntime struct {
+	binary string
+}
+
+// run executes command of the runtime and returns its standard output
+func (r cliRuntime) run(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, r.binary, args...)
+
+	var stderr strings.Builder
+
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %w: %s", r.binary, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// ListContainers returns all containers, including stopped ones
+func (r cliRuntime) ListContainers(ctx context.Context) ([]ContainerInfo, error) {
+	output, err := r.run(ctx, "container", "ls", "--all", "--format", "{{.ID}} {{.Names}}")
+	if err != nil {
+		return nil, err
+	}
+
+	containers := []ContainerInfo{}
+
+	for line := range strings.SplitSeq(output, "\n") {
+		id, name, found := strings.Cut(strings.TrimSpace(line), " ")
+		if !found {
+			continue
+		}
+
+		containers = append(containers, ContainerInfo{ID: id, Name: name})
+	}
+
+	return containers, nil
+}
+
+// ListContainerVolumes returns names of volumes mounted into the container
+func (r cliRuntime) ListContainerVolumes(ctx context.Context, containerID string) ([]string, error) {
+	output, err := r.run(ctx, "container", "inspect", "--format", `{{range .Mounts}}{{if eq .Type "volume"}}{{.Name}}{{"\n"}}{{end}}{{end}}`, containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := []string{}
+
+	for volume := range strings.SplitSeq(output, "\n") {
+		if volume = strings.TrimSpace(volume); volume != "" {
+			volumes = append(volumes, volume)
+		}
+	}
+
+	return volumes, nil
+}
+
+// StopContainer stops the container
+func (r cliRuntime) StopContainer(ctx context.Context, containerID string) error {
+	_, err := r.run(ctx, "container", "stop", containerID)
+	return err
+}
+
+// RemoveContainer removes the container
+func (r cliRuntime) RemoveContainer(ctx context.Context, containerID string) error {
+	_, err := r.run(ctx, "container", "rm", containerID)
+	return err
+}
+
+// RemoveVolume removes the volume
+func (r cliRuntime) RemoveVolume(ctx context.Context, volume string) error {
+	_, err := r.run(ctx, "volume", "rm", volume)
+	return err
+}
+
+// DiskUsage returns disk space in bytes taken by images, containers, volumes and build cache
+func (r cliRuntime) DiskUsage(ctx context.Context) (uint64, error) {
+	output, err := r.run(ctx, "system", "df", "--format", "{{.Size}}")
+	if err != nil {
+		return 0, err
+	}
+
+	var total uint64
+
+	for line := range strings.SplitSeq(output, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+
+		size, err := parseHumanSize(line)
+		if err != nil {
+			return 0, err
+		}
+
+		total += size
+	}
+
+	return total, nil
+}
+
+// DockerRuntime is the Docker container runtime
+type DockerRuntime struct {
+	cliRuntime
+}
+
+// NewDockerRuntime returns Docker container runtime
+func NewDockerRuntime() DockerRuntime {
+	return DockerRuntime{cliRuntime{binary: "docker"}}
+}
+
+// Name returns human-readable name of the runtime
+func (DockerRuntime) Name() string {
+	return "Docker"
+}
+
+// PodmanRuntime is the Podman container runtime, works also in rootless mode
+type PodmanRuntime struct {
+	cliRuntime
+}
+
+// NewPodmanRuntime returns Podman container runtime
+func NewPodmanRuntime() PodmanRuntime {
+	return PodmanRuntime{cliRuntime{binary: "podman"}}
+}
+
+// Name returns human-readable name of the runtime
+func (PodmanRuntime) Name() string {
+	return "Podman"
+}
+
+// DetectRuntime returns container runtime which hosts the Dagger engine, together with
+// name of the engine container if Dagger was told to use a specific one
+func DetectRuntime() (Runtime, string, error) {
+	// Custom Dagger engine
+	if runnerHost := os.Getenv(daggerRunnerHostEnv); runnerHost != "" {
+		scheme, engineName, _ := strings.Cut(runnerHost, "://")
+		switch scheme {
+		case "docker-container":
+			return NewDockerRuntime(), engineName, nil
+		case "podman-container":
+			return NewPodmanRuntime(), engineName, nil
+		default:
+			return nil, "", fmt.Errorf("%w: %s=%s", errUnsupportedRuntime, daggerRunnerHostEnv, runnerHost)
+		}
+	}
+
+	// Dagger prefers Docker, and falls back to Podman
+	if _, err := exec.LookPath("docker"); err == nil {
+		return NewDockerRuntime(), "", nil
+	}
+
+	if _, err := exec.LookPath("podman"); err == nil {
+		return NewPodmanRuntime(), "", nil
+	}
+
+	return nil, "", errNoRuntime
+}
+
+// isDaggerEngine returns true if the container is owned by Dagger
+func isDaggerEngine(info ContainerInfo, engineName string) bool {
+	if engineName != "" {
+		return info.Name == engineName
+	}
+
+	return strings.HasPrefix(info.Name, daggerEngineNamePrefix)
+}
+
+// humanSizePattern matches human-readable size, number followed by optional unit
+var humanSizePattern = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)\s*([a-zA-Z]*)$`)
+
+// parseHumanSize parses human-readable size, as printed by docker and podman (for example '1.5GB')
+func parseHumanSize(size string) (uint64, error) {
+	match := humanSizePattern.FindStringSubmatch(strings.TrimSpace(size))
+	if match == nil {
+		return 0, fmt.Errorf("%w: '%s'", errParseSize, size)
+	}
+
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: '%s': %w", errParseSize, size, err)
+	}
+
+	multipliers := map[string]float64{
+		"":    1,
+		"b":   1,
+		"kb":  1e3,
+		"mb":  1e6,
+		"gb":  1e9,
+		"tb":  1e12,
+		"kib": 1 << 10,
+		"mib": 1 << 20,
+		"gib": 1 << 30,
+		"tib": 1 << 40,
+	}
+
+	multiplier, ok := multipliers[strings.ToLower(match[2])]
+	if !ok {
+		return 0, fmt.Errorf("%w: '%s': unknown unit", errParseSize, size)
+	}
+
+	return uint64(value * multiplier), nil
+}
+
+// formatHumanSize formats size in bytes into human-readable form
+func formatHumanSize(size uint64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	value := float64(size)
+
+	unit := 0
+	for value >= 1000 && unit < len(units)-1 {
+		value /= 1000
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%d%s", size, units[unit])
+	}
+
+	return fmt.Sprintf("%.2f%s", value, units[unit])
+}
+
+// cleanupDaggerEngine stops and removes Dagger engine containers and their volumes
+func cleanupDaggerEngine(ctx context.Context, containerRuntime Runtime, engineName string) error {
+	containers, err := containerRuntime.ListContainers(ctx)
+	if err != nil {
+		slog.Error(
+			fmt.Sprintf("Failed to list %s containers", containerRuntime.Name()),
+			slog.Any("error", err),
+		)
+
+		return err
+	}
+
+	found := false
+
+	for _, info := range containers {
+		if !isDaggerEngine(info, engineName) {
+			continue
+		}
+
+		found = true
+
+		// Volumes have to be found before the container is removed
+		volumes, err := containerRuntime.ListContainerVolumes(ctx, info.ID)
+		if err != nil {
+			slog.Warn(
+				"Failed to list volumes of Dagger engine container",
+				slog.String("container", info.Name),
+				slog.Any("error", err),
+			)
+			// Continue, the container itself can still be removed
+		}
+
+		slog.Debug(
+			"Stopping Dagger engine container",
+			slog.String("containerID", info.ID),
+			slog.String("container", info.Name),
+		)
+
+		if err := containerRuntime.StopContainer(ctx, info.ID); err != nil {
+			slog.Error(
+				"Failed to stop Dagger engine container",
+				slog.String("container", info.Name),
+				slog.Any("error", err),
+			)
+
+			return err
+		}
+
+		slog.Debug(
+			"Removing Dagger engine container",
+			slog.String("containerID", info.ID),
+			slog.String("container", info.Name),
+		)
+
+		if err := containerRuntime.RemoveContainer(ctx, info.ID); err != nil {
+			slog.Error(
+				"Failed to remove Dagger engine container",
+				slog.String("container", info.Name),
+				slog.Any("error", err),
+			)
+
+			return err
+		}
+
+		for _, volume := range volumes {
+			slog.Debug(
+				"Removing Dagger engine volume",
+				slog.String("volume", volume),
+			)
+
+			if err := containerRuntime.RemoveVolume(ctx, volume); err != nil {
+				slog.Warn(
+					"Failed to remove Dagger engine volume",
+					slog.String("volume", volume),
+					slog.Any("error", err),
+				)
+				// Continue with other volumes even if one fails
+			}
+		}
+	}
+
+	if !found {
+		slog.Info("No Dagger engine container found to clean up")
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: MIT
+
+// Package container / runtime
+package container
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHumanSize(t *testing.T) {
+	testCases := []struct {
+		name    string
+		size    string
+		want    uint64
+		wantErr error
+	}{
+		{name: "zero", size: "0B", want: 0},
+		{name: "bytes", size: "512B", want: 512},
+		{name: "kilobytes", size: "12.5kB", want: 12500},
+		{name: "gigabytes", size: "1.5GB", want: 1500000000},
+		{name: "binary units", size: "2MiB", want: 2 << 20},
+		{name: "space between value and unit", size: "3 MB", want: 3000000},
+		{name: "unknown unit", size: "3XB", wantErr: errParseSize},
+		{name: "garbage", size: "N/A", wantErr: errParseSize},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			size, err := parseHumanSize(tc.size)
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.want, size)
+		})
+	}
+}
+
+func TestFormatHumanSize(t *testing.T) {
+	assert.Equal(t, "0B", formatHumanSize(0))
+	assert.Equal(t, "999B", formatHumanSize(999))
+	assert.Equal(t, "1.50GB", formatHumanSize(1500000000))
+}
+
+func TestDetectRuntime(t *testing.T) {
+	testCases := []struct {
+		name           string
+		runnerHost     string
+		wantRuntime    string
+		wantEngineName string
+		wantErr        error
+	}{
+		{
+			name:           "docker container",
+			runnerHost:     "docker-container://my-engine",
+			wantRuntime:    "Docker",
+			wantEngineName: "my-engine",
+		},
+		{
+			name:           "podman container",
+			runnerHost:     "podman-container://my-engine",
+			wantRuntime:    "Podman",
+			wantEngineName: "my-engine",
+		},
+		{
+			name:       "remote engine",
+			runnerHost: "tcp://localhost:1234",
+			wantErr:    errUnsupportedRuntime,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(daggerRunnerHostEnv, tc.runnerHost)
+
+			runtime, engineName, err := DetectRuntime()
+			assert.ErrorIs(t, err, tc.wantErr)
+
+			if tc.wantErr != nil {
+				return
+			}
+
+			assert.Equal(t, tc.wantRuntime, runtime.Name())
+			assert.Equal(t, tc.wantEngineName, engineName)
+		})
+	}
+}
+
+// fakeRuntime records actions instead of executing them
+type fakeRuntime struct {
+	containers []ContainerInfo
+	volumes    map[string][]string
+	removed    []string
+}
+
+func (r *fakeRuntime) Name() string { return "fake" }
+
+func (r *fakeRuntime) ListContainers(_ context.Context) ([]ContainerInfo, error) {
+	return r.containers, nil
+}
+
+func (r *fakeRuntime) ListContainerVolumes(_ context.Context, containerID string) ([]string, error) {
+	return r.volumes[containerID], nil
+}
+
+func (r *fakeRuntime) StopContainer(_ context.Context, _ string) error { return nil }
+
+func (r *fakeRuntime) RemoveContainer(_ context.Context, containerID string) error {
+	r.removed = append(r.removed, "container:"+containerID)
+	return nil
+}
+
+func (r *fakeRuntime) RemoveVolume(_ context.Context, volume string) error {
+	r.removed = append(r.removed, "volume:"+volume)
+	return nil
+}
+
+func (r *fakeRuntime) DiskUsage(_ context.Context) (uint64, error) { return 0, nil }
+
+func TestCleanupDaggerEngine(t *testing.T) {
+	newRuntime := func() *fakeRuntime {
+		return &fakeRuntime{
+			containers: []ContainerInfo{
+				{ID: "1", Name: "dagger-engine-v0.21.8"},
+				{ID: "2", Name: "my-database"},
+				{ID: "3", Name: "custom-engine"},
+			},
+			volumes: map[string][]string{
+				"1": {"a1b2c3"},
+				"2": {"database-data"},
+				"3": {"custom-engine-state"},
+			},
+		}
+	}
+
+	testCases := []struct {
+		name        string
+		engineName  string
+		wantRemoved []string
+	}{
+		{
+			name:        "engine provisioned by dagger",
+			engineName:  "",
+			wantRemoved: []string{"container:1", "volume:a1b2c3"},
+		},
+		{
+			name:        "custom engine",
+			engineName:  "custom-engine",
+			wantRemoved: []string{"container:3", "volume:custom-engine-state"},
+		},
+		{
+			name:        "no engine found",
+			engineName:  "missing-engine",
+			wantRemoved: nil,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			runtime := newRuntime()
+
+			err := cleanupDaggerEngine(t.Context(), runtime, tc.engineName)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantRemoved, runtime.removed)
+		})
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -141,3 +142,23 @@ func gitDescribe(repoPath string, cfg *describe) (string, error) {
 
 	return result, err
 }
+
+// GitCommitTimestamp returns committer date of the HEAD commit as Unix timestamp
+func GitCommitTimestamp(repoPath string) (int64, error) {
+	output, err := gitRun(repoPath, []string{"git", "log", "-1", "--format=%ct"})
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(strings.TrimSpace(output), 10, 64)
+}
+
+// GitCommitHash returns full hash of the HEAD commit
+func GitCommitHash(repoPath string) (string, error) {
+	output, err := gitRun(repoPath, []string{"git", "rev-parse", "HEAD"})
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(output), nil
+}
@@ -75,3 +75,25 @@ func TestGitDescribeCoreboot(t *testing.T) {
 	// Since the content, author and time of the commit are hard-coded,
 	//   the commit hash is always the same
 }
+
+func TestGitCommitTimestamp(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Chdir(tmpDir)
+
+	gitRepoPrepare(t, tmpDir)
+
+	timestamp, err := GitCommitTimestamp("./")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 1, 1, 12, 30, 0, 0, time.UTC).Unix(), timestamp)
+}
+
+func TestGitCommitHash(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Chdir(tmpDir)
+
+	gitRepoPrepare(t, tmpDir)
+
+	hash, err := GitCommitHash("./")
+	assert.NoError(t, err)
+	assert.Equal(t, "4eeb1eaf0c81", hash[:12])
+}
@@ -11,9 +11,12 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
 	"regexp"
 	"runtime/debug"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/9elements/firmware-action/cmd/firmware-action/environment"
 	"github.com/9elements/firmware-action/cmd/firmware-action/filesystem"
@@ -93,7 +96,30 @@ func getVersionInfo() (string, string, string) {
 func main() {
 	logging.InitLogger(slog.LevelInfo)
 
-	if err := run(context.Background()); err != nil {
+	// Cancel the build on Ctrl-C or when terminated (for example CI job is cancelled)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	finished := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Restore default behavior, so that second Ctrl-C kills firmware-action right away
+			stop()
+			slog.Warn(
+				"Received interrupt, cancelling the build",
+				slog.String("suggestion", "Press Ctrl-C again to exit immediately without cleanup"),
+			)
+		case <-finished:
+		}
+	}()
+
+	err := run(ctx)
+
+	close(finished)
+	stop()
+
+	if err != nil {
 		slog.Error(
 			"firmware-action failed",
 			slog.Any("error", err),
@@ -115,7 +141,25 @@ var CLI struct {
 		Target                string `required:"" help:"Select which target to build, use ID from configuration file"`
 		Recursive             bool   `help:"Build recursively with all dependencies and payloads"`
 		PruneDockerContainers bool   `help:"Remove Dagger container and its volumes after each module (only in recursive mode)"`
-	} `cmd:"build" help:"Build a target defined in configuration file. For interactive debugging preface the command with 'dagger run --interactive', for example 'dagger run --interactive $(which firmware-action) build --config=...'. To install dagger follow instructions at https://dagger.io/"`
+		Hermetic              bool   `help:"Disable network access in build steps of all modules, same as setting 'network' to 'none' in each module. Build steps then run in Dagger's insecure mode (no seccomp and AppArmor profiles), and the container must contain unshare, setpriv, mount, find and awk"`
+		ShellOnFailure        bool   `help:"Open interactive shell in the container when a build step fails"`
+		ExportFailedContainer string `type:"path" help:"When a build step fails, export the container as OCI tarball to given path, together with a script containing the remaining build steps"`
+		VerifyReproducible    bool   `help:"After the build, build the target twice more in fresh containers and check that the artifacts are identical"`
+		ProvenanceKey         string `type:"existingfile" help:"Sign provenance of each module with given private key (Ed25519 or ECDSA in PKCS #8 PEM format)"`
+		ReportJSON            string `name:"report-json" type:"path" help:"Write machine-readable build report in JSON format to given path"`
+		ReportJUnit           string `name:"report-junit" type:"path" help:"Write build report in JUnit XML format to given path"`
+		Trace                 string `type:"path" help:"Write timings of the build as Chrome trace-event file to given path, it can be opened in Perfetto"`
+	} `cmd:"build" help:"Build a target defined in configuration file. For interactive debugging use '--shell-on-failure' or the 'shell' command."`
+
+	Shell struct {
+		Target string `required:"" help:"Select which target to open shell for, use ID from configuration file"`
+	} `cmd:"shell" help:"Open interactive shell in the container of a target, prepared for building but without building anything"`
+
+	Verify struct {
+		Artifact   string `type:"existingfile" required:"" help:"Path to artifact to verify"`
+		Provenance string `type:"existingfile" help:"Path to provenance, defaults to '${provenance_file}' next to the artifact"`
+		Key        string `type:"existingfile" help:"Public key (PEM) to verify signature of the provenance"`
+	} `cmd:"verify" help:"Verify that artifact matches its provenance"`
 
 	GenerateConfig struct{} `cmd:"generate-config" help:"Generate empty configuration file"`
 	ValidateConfig struct{} `cmd:"validate-config" help:"Validate configuration file"`
@@ -123,7 +167,7 @@ var CLI struct {
 
 func run(ctx context.Context) error {
 	// Get arguments
-	mode, err := getInputsFromEnvironment()
+	mode, command, err := getInputsFromEnvironment()
 	if err != nil {
 		return err
 	}
@@ -150,6 +194,14 @@ func run(ctx context.Context) error {
 		slog.String("input/target", CLI.Build.Target),
 		slog.Bool("input/recursive", CLI.Build.Recursive),
 		slog.Bool("input/prune", CLI.Build.PruneDockerContainers),
+		slog.Bool("input/hermetic", CLI.Build.Hermetic),
+		slog.Bool("input/shell-on-failure", CLI.Build.ShellOnFailure),
+		slog.String("input/export-failed-container", CLI.Build.ExportFailedContainer),
+		slog.Bool("input/verify-reproducible", CLI.Build.VerifyReproducible),
+		slog.String("input/provenance-key", CLI.Build.ProvenanceKey),
+		slog.String("input/report-json", CLI.Build.ReportJSON),
+		slog.String("input/report-junit", CLI.Build.ReportJUnit),
+		slog.String("input/trace", CLI.Build.Trace),
 	)
 
 	// Check if submodules were initialized
@@ -201,6 +253,27 @@ submodule_out:
 		return err
 	}
 
+	versionInfo, _, _ := getVersionInfo()
+	recipes.FirmwareActionVersion = versionInfo
+
+	ctx = recipes.WithBuildOptions(ctx, recipes.BuildOptions{
+		Hermetic:              CLI.Build.Hermetic,
+		ShellOnFailure:        CLI.Build.ShellOnFailure,
+		ExportFailedContainer: CLI.Build.ExportFailedContainer,
+		ProvenanceKey:         CLI.Build.ProvenanceKey,
+	})
+
+	if command == "shell" {
+		return recipes.Shell(ctx, CLI.Shell.Target, myConfig)
+	}
+
+	// Fail early on unusable signing key, rather than after everything is built
+	if CLI.Build.ProvenanceKey != "" {
+		if err := recipes.CheckProvenanceKey(CLI.Build.ProvenanceKey); err != nil {
+			return err
+		}
+	}
+
 	// Lets build stuff
 	results, err := recipes.Build(
 		ctx,
@@ -213,24 +286,64 @@ submodule_out:
 
 	// Pretty table
 	summaryTable := table.NewWriter()
-	summaryTable.AppendHeader(table.Row{"Module", "Status"})
+	header := table.Row{"Module", "Status", "Total"}
+	for _, phase := range recipes.Phases {
+		header = append(header, phase)
+	}
+
+	summaryTable.AppendHeader(header)
 
 	// Create overview table
 	for _, item := range results {
-		var result string
-		if item.BuildResult == nil {
-			result = "Success"
-		} else if errors.Is(item.BuildResult, recipes.ErrBuildUpToDate) {
-			result = "Up-to-date"
-		} else {
-			result = "Fail"
+		row := table.Row{item.Name, item.Status(), item.Duration.Round(time.Millisecond)}
+		for _, phase := range recipes.Phases {
+			row = append(row, item.PhaseDuration(phase).Round(time.Millisecond))
 		}
 
-		summaryTable.AppendRow([]any{item.Name, result})
+		summaryTable.AppendRow(row)
 	}
 
 	slog.Info(fmt.Sprintf("Build summary:\n%s", summaryTable.Render()))
 
+	// Timings, to find out where the time is spent
+	if len(results) > 0 {
+		// Timings are informative only, failure to write them does not fail the build
+		if timingsErr := recipes.WriteTimings(recipes.TimingsFile, results); timingsErr != nil {
+			slog.Warn(
+				fmt.Sprintf("Failed to write timings into '%s'", recipes.TimingsFile),
+				slog.String("suggestion", fmt.Sprintf("Check that '%s' directory is writable", recipes.StatusDir)),
+				slog.Any("error", timingsErr),
+			)
+		}
+	}
+
+	if CLI.Build.Trace != "" {
+		if traceErr := recipes.WriteChromeTrace(CLI.Build.Trace, results); traceErr != nil {
+			err = errors.Join(err, traceErr)
+		}
+	}
+
+	// Reproducibility, verified before writing reports so that they contain the result
+	var reproducibility *recipes.ReportReproducibility
+
+	if CLI.Build.VerifyReproducible {
+		reproducibility = &recipes.ReportReproducibility{Status: "Skipped", Errors: []string{}}
+
+		if err == nil {
+			verifyStart := time.Now()
+			err = recipes.VerifyReproducible(ctx, CLI.Build.Target, myConfig)
+			reproducibility = recipes.NewReportReproducibility(time.Since(verifyStart), err)
+		}
+	}
+
+	// Machine-readable reports, written also when the build failed
+	report := recipes.NewReport(CLI.Build.Target, results, err)
+	report.Reproducibility = reproducibility
+
+	if reportErr := recipes.WriteReports(report, CLI.Build.ReportJSON, CLI.Build.ReportJUnit); reportErr != nil {
+		err = errors.Join(err, reportErr)
+	}
+
 	if err == nil {
 		slog.Info("Build finished successfully")
 	}
@@ -238,7 +351,7 @@ submodule_out:
 	return err
 }
 
-func getInputsFromEnvironment() (string, error) {
+func getInputsFromEnvironment() (string, string, error) {
 	// Check for GitHub
 	if environment.DetectGithub() {
 		return parseGithub()
@@ -251,7 +364,7 @@ func getInputsFromEnvironment() (string, error) {
 	return parseCli()
 }
 
-func parseCli() (string, error) {
+func parseCli() (string, string, error) {
 	// Get version info dynamically
 	versionInfo, commitInfo, dateInfo := getVersionInfo()
 
@@ -261,8 +374,9 @@ func parseCli() (string, error) {
 		kong.Description("Utility to create firmware images for several open source firmware solutions. Source code at 'https://github.com/9elements/firmware-action'"),
 		kong.UsageOnError(),
 		kong.Vars{
-			"config_file": "firmware-action.json",
-			"version":     fmt.Sprintf("version: %s\ncommit:  %s\ndate:    %s", versionInfo, commitInfo, dateInfo),
+			"config_file":     "firmware-action.json",
+			"provenance_file": recipes.ProvenanceFileName,
+			"version":         fmt.Sprintf("version: %s\ncommit:  %s\ndate:    %s", versionInfo, commitInfo, dateInfo),
 		},
 		kong.ConfigureHelp(kong.HelpOptions{
 			Compact: true,
@@ -272,9 +386,17 @@ func parseCli() (string, error) {
 	mode := "CLI"
 
 	switch ctx.Command() {
-	case "build":
+	case "build", "shell":
 		// This is handled elsewhere
-		return mode, nil
+		return mode, ctx.Command(), nil
+
+	case "verify":
+		err := recipes.VerifyProvenance(CLI.Verify.Artifact, CLI.Verify.Provenance, CLI.Verify.Key)
+		if err != nil {
+			return "", "", err
+		}
+
+		return "", "", nil
 
 	case "validate-config":
 		// Check if at least one configuration file was supplied
@@ -284,18 +406,18 @@ func parseCli() (string, error) {
 				slog.Any("error", os.ErrNotExist),
 			)
 
-			return "", os.ErrNotExist
+			return "", "", os.ErrNotExist
 		}
 
 		// Parse and validate configuration files
 		_, err := recipes.ReadConfigs(CLI.Config)
 		if err != nil {
-			return "", err
+			return "", "", err
 		}
 
 		slog.Info("Configuration file(s) validated successfully")
 
-		return "", nil
+		return "", "", nil
 
 	case "generate-config":
 		// Check if at least one configuration file was supplied
@@ -305,7 +427,7 @@ func parseCli() (string, error) {
 				slog.Any("error", os.ErrNotExist),
 			)
 
-			return "", os.ErrNotExist
+			return "", "", os.ErrNotExist
 		}
 		// Check if config file exists
 		err := filesystem.CheckFileExists(CLI.Config[0])
@@ -316,7 +438,7 @@ func parseCli() (string, error) {
 				slog.Any("error", err),
 			)
 
-			return "", err
+			return "", "", err
 		}
 
 		// Create empty config
@@ -339,7 +461,7 @@ func parseCli() (string, error) {
 				slog.Any("error", err),
 			)
 
-			return "", err
+			return "", "", err
 		}
 
 		// Write to file
@@ -351,10 +473,10 @@ func parseCli() (string, error) {
 				slog.Any("error", err),
 			)
 
-			return "", err
+			return "", "", err
 		}
 
-		return "", nil
+		return "", "", nil
 
 	default:
 		// This should not happen
@@ -365,11 +487,11 @@ func parseCli() (string, error) {
 			slog.Any("error", err),
 		)
 
-		return mode, err
+		return mode, "", err
 	}
 }
 
-func parseGithub() (string, error) {
+func parseGithub() (string, string, error) {
 	// Get inputs from GitHub environment
 	action := githubactions.New()
 	regexTrue := regexp.MustCompile(`(?i)true`)
@@ -378,8 +500,15 @@ func parseGithub() (string, error) {
 	CLI.Build.Target = action.GetInput("target")
 	CLI.Build.Recursive = regexTrue.MatchString(action.GetInput("recursive"))
 	CLI.Build.PruneDockerContainers = regexTrue.MatchString(action.GetInput("prune"))
+	CLI.Build.Hermetic = regexTrue.MatchString(action.GetInput("hermetic"))
+	CLI.Build.ExportFailedContainer = action.GetInput("export-failed-container")
+	CLI.Build.VerifyReproducible = regexTrue.MatchString(action.GetInput("verify-reproducible"))
+	CLI.Build.ProvenanceKey = action.GetInput("provenance-key")
+	CLI.Build.ReportJSON = action.GetInput("report-json")
+	CLI.Build.ReportJUnit = action.GetInput("report-junit")
+	CLI.Build.Trace = action.GetInput("trace")
 	CLI.JSON = regexTrue.MatchString(action.GetInput("json"))
 	CLI.Debug = regexTrue.MatchString(action.GetInput("debug"))
 
-	return "GitHub", nil
+	return "GitHub", "build", nil
 }
@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / build steps
+package recipes
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"dagger.io/dagger"
+	"github.com/9elements/firmware-action/cmd/firmware-action/container"
+)
+
+// withExec executes command in the container, respecting network settings of the module
+func (opts CommonOpts) withExec(ctx context.Context, myContainer *dagger.Container, args []string) *dagger.Container {
+	if opts.networkDisabled(ctx) {
+		return container.WithExecNoNetwork(myContainer, args)
+	}
+
+	return myContainer.WithExec(args)
+}
+
+// runBuildSteps executes build steps in the container one after another
+// Every command executed in a recipe should go through here (a single command is a list of one step),
+// so that '--shell-on-failure' and '--export-failed-container' work for all of them
+func (opts CommonOpts) runBuildSteps(ctx context.Context, myContainer *dagger.Container, buildSteps [][]string) (*dagger.Container, error) {
+	for step := range buildSteps {
+		result, err := opts.runStep(ctx, myContainer, PhaseBuildStep, strings.Join(buildSteps[step], " "), buildSteps[step:])
+		if err != nil {
+			return myContainer, err
+		}
+
+		myContainer = result
+	}
+
+	return myContainer, nil
+}
+
+// runStep executes the first of 'remainingSteps' in the container and records it in timings as 'name'
+// in 'phase'. On failure, the container in state just before the step is exported together with
+// all remaining steps, and/or interactive shell is opened in it
+func (opts CommonOpts) runStep(ctx context.Context, myContainer *dagger.Container, phase string, name string, remainingSteps [][]string) (*dagger.Container, error) {
+	stopTiming := recordPhase(ctx, phase, name)
+	result, err := opts.withExec(ctx, myContainer, remainingSteps[0]).Sync(ctx)
+	stopTiming()
+
+	if err == nil {
+		return result, nil
+	}
+
+	if opts.networkDisabled(ctx) {
+		slog.Error(
+			"Build step failed while network access was disabled",
+			slog.String("build_step", strings.Join(remainingSteps[0], " ")),
+			slog.String("suggestion", "The build step might have tried to download something. Make sure all inputs are part of the repository, input_files or input_dirs."),
+			slog.Any("error", err),
+		)
+	}
+
+	if GetBuildOptions(ctx).ExportFailedContainer != "" {
+		opts.exportFailedContainer(ctx, myContainer, remainingSteps)
+	}
+
+	if GetBuildOptions(ctx).ShellOnFailure {
+		opts.openShellOnFailure(ctx, myContainer, remainingSteps[0])
+	}
+
+	return myContainer, err
+}
+
+// openShellOnFailure opens an interactive shell in the container in state just before the failed build step
+func (opts CommonOpts) openShellOnFailure(ctx context.Context, myContainer *dagger.Container, failedStep []string) {
+	slog.Warn(
+		"Opening interactive shell in the container, in state just before the failed build step",
+		slog.String("failed_build_step", strings.Join(failedStep, " ")),
+		slog.String("suggestion", "to exit the shell run command 'exit' or press CTRL+D"),
+	)
+
+	err := container.OpenTerminal(ctx, myContainer, opts.networkDisabled(ctx))
+	if err != nil {
+		slog.Warn(
+			"Failed to open interactive shell in the container",
+			slog.Any("error", err),
+		)
+	}
+}
+
+// exportFailedContainer exports the container in state just before the failed build step,
+// together with a script containing the remaining build steps
+func (opts CommonOpts) exportFailedContainer(ctx context.Context, myContainer *dagger.Container, remainingSteps [][]string) {
+	tarballPath := GetBuildOptions(ctx).ExportFailedContainer
+
+	err := container.ExportFailed(ctx, myContainer, container.ExportFailedOpts{
+		TarballPath:    tarballPath,
+		ScriptPath:     failedContainerScriptPath(tarballPath),
+		MountDir:       ContainerWorkDir,
+		RemainingSteps: remainingSteps,
+		NoNetwork:      opts.networkDisabled(ctx),
+	})
+	if err != nil {
+		slog.Warn(
+			"Failed to export the container of failed build",
+			slog.Any("error", err),
+		)
+	}
+}
+
+// failedContainerScriptPath returns path of the script with remaining build steps, which is placed
+// next to the exported container image
+func failedContainerScriptPath(tarballPath string) string {
+	return strings.TrimSuffix(tarballPath, filepath.Ext(tarballPath)) + ".sh"
+}
@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / cbfstool
+package recipes
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"dagger.io/dagger"
+)
+
+// cbfstoolPath is cbfstool from PATH of the container, used in firmware stitching
+const cbfstoolPath = "cbfstool"
+
+// corebootCbfstoolPath is cbfstool built together with coreboot, relative to coreboot repository
+// Unlike the one prebuilt in the SDK, it always matches the coreboot version of the image
+const corebootCbfstoolPath = "build/cbfstool"
+
+// cbfstoolContainerDir is directory in container into which files for cbfstool are copied
+const cbfstoolContainerDir = "/tmp/firmware-action-cbfstool"
+
+// ANCHOR: CbfstoolEntry
+
+// CbfstoolEntry is a single operation on CBFS (coreboot filesystem) in firmware image, done with cbfstool
+type CbfstoolEntry struct {
+	// Operation to perform, one of:
+	//   - add:             add a file, 'type' is required
+	//   - add-payload:     add a payload (ELF file or similar)
+	//   - add-flat-binary: add a flat binary as payload, load address and entry point
+	//                      must be given in 'optional_arguments' with '-l' and '-e'
+	//   - remove:          remove a file, only 'name' is needed
+	//   - write:           write a file into FMAP region, 'region' is required
+	Operation string `json:"operation" validate:"required,oneof=add add-payload add-flat-binary remove write"`
+
+	// Gives the (relative) path to the file to add or write
+	// Not used by 'remove'
+	// Can also reference artifact of a module listed in 'depends' as 'dep:<module-id>/<file>'
+	Path string `json:"path" validate:"required_unless=Operation remove"`
+
+	// Name of the file in CBFS
+	// Not used by 'write'
+	// Examples:
+	//   - bootsplash.jpg
+	//   - vbt.bin
+	//   - img/memtest
+	Name string `json:"name" validate:"required_unless=Operation write"`
+
+	// Type of the file in CBFS, used only by 'add'
+	// For supported types see `cbfstool --help`
+	// Examples:
+	//   - raw
+	//   - bootsplash
+	Type string `json:"type" validate:"required_if=Operation add"`
+
+	// Compression algorithm, used by 'add', 'add-payload' and 'add-flat-binary'
+	// Empty means the default of cbfstool
+	Compression string `json:"compression" validate:"omitempty,oneof=none lzma lz4"`
+
+	// FMAP region, for example 'COREBOOT' or 'FW_MAIN_A'
+	// Empty means the default of cbfstool, which is 'COREBOOT'
+	// Required by 'write'
+	Region string `json:"region" validate:"required_if=Operation write"`
+
+	// Additional (optional) arguments and flags
+	// For example:
+	//   `-l 0x800000 -e 0x800000`
+	// For supported options see `cbfstool --help`
+	OptionalArguments []string `json:"optional_arguments"`
+
+	// Ignore entry if the file is missing
+	IgnoreIfMissing bool `json:"ignore_if_missing" type:"boolean"`
+}
+
+// ANCHOR_END: CbfstoolEntry
+
+// cbfstoolContainerPath returns path in the container into which file of the entry is copied
+// The index prevents collisions of files with the same name
+func cbfstoolContainerPath(index int, path string) string {
+	return filepath.Join(cbfstoolContainerDir, fmt.Sprintf("%02d_%s", index, filepath.Base(path)))
+}
+
+// cbfstoolCmd assembles command for the entry, 'tool' is the cbfstool to use and 'path' is path
+// to the file in the container
+func cbfstoolCmd(tool string, image string, entry CbfstoolEntry, path string) []string {
+	cmd := []string{tool, image, entry.Operation}
+
+	if entry.Operation != "remove" {
+		cmd = append(cmd, "-f", path)
+	}
+
+	if entry.Name != "" && entry.Operation != "write" {
+		cmd = append(cmd, "-n", entry.Name)
+	}
+
+	if entry.Type != "" && entry.Operation == "add" {
+		cmd = append(cmd, "-t", entry.Type)
+	}
+
+	if entry.Compression != "" && entry.Operation != "remove" && entry.Operation != "write" {
+		cmd = append(cmd, "-c", entry.Compression)
+	}
+
+	if entry.Region != "" {
+		cmd = append(cmd, "-r", entry.Region)
+	}
+
+	return append(cmd, entry.OptionalArguments...)
+}
+
+// cbfstoolSources returns paths to files used by cbfstool entries, artifacts of dependencies excluded
+func cbfstoolSources(entries []CbfstoolEntry) []string {
+	sources := []string{}
+
+	for _, entry := range entries {
+		if entry.Path != "" && entry.Operation != "remove" && !isDependencyPath(entry.Path) {
+			sources = append(sources, entry.Path)
+		}
+	}
+
+	return sources
+}
+
+// cbfstoolSbomBlobs returns files added into the firmware image by cbfstool entries
+func cbfstoolSbomBlobs(entries []CbfstoolEntry) []sbomBlob {
+	blobs := []sbomBlob{}
+
+	for _, entry := range cbfstoolSources(entries) {
+		blobs = append(blobs, sbomBlob{
+			Path:    entry,
+			Comment: "added into CBFS with cbfstool",
+		})
+	}
+
+	return blobs
+}
+
+// withCbfstoolEntries copies files of all entries into the container and returns build steps which
+// apply the entries to the firmware image with 'tool'
+func withCbfstoolEntries(client *dagger.Client, myContainer *dagger.Container, pwd string, tool string, image string, entries []CbfstoolEntry, depends []string) (*dagger.Container, [][]string, error) {
+	buildSteps := [][]string{}
+
+	for index, entry := range entries {
+		containerPath := ""
+
+		switch {
+		case entry.Operation == "remove":
+			// Nothing to copy
+		case isDependencyPath(entry.Path):
+			// Artifact of a dependency is already mounted into container
+			path, err := dependencyContainerPath(entry.Path, depends)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			containerPath = path
+		default:
+			hostPath := filepath.Join(pwd, entry.Path)
+
+			_, err := os.Stat(hostPath)
+			if err != nil && entry.IgnoreIfMissing {
+				slog.Warn(
+					fmt.Sprintf("Can't copy file '%s' - does not exists, ignoring because 'ignore_if_missing' is set", entry.Path),
+				)
+
+				continue
+			}
+
+			if err != nil {
+				slog.Error(
+					fmt.Sprintf("Can't copy file '%s' - does not exists", entry.Path),
+					slog.String("suggestion", "Double check provided path to file in 'cbfstool_entries'"),
+					slog.Any("error", err),
+				)
+
+				return nil, nil, err
+			}
+
+			containerPath = cbfstoolContainerPath(index, entry.Path)
+			myContainer = myContainer.WithFile(containerPath, client.Host().File(hostPath))
+		}
+
+		buildSteps = append(buildSteps, cbfstoolCmd(tool, image, entry, containerPath))
+	}
+
+	return myContainer, buildSteps, nil
+}
@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / cbfstool
+package recipes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCbfstoolCmd(t *testing.T) {
+	testCases := []struct {
+		name  string
+		entry CbfstoolEntry
+		path  string
+		want  []string
+	}{
+		{
+			name:  "add",
+			entry: CbfstoolEntry{Operation: "add", Path: "logo.jpg", Name: "bootsplash.jpg", Type: "bootsplash", Compression: "lzma"},
+			path:  "/tmp/logo.jpg",
+			want:  []string{"cbfstool", "coreboot.rom", "add", "-f", "/tmp/logo.jpg", "-n", "bootsplash.jpg", "-t", "bootsplash", "-c", "lzma"},
+		},
+		{
+			name:  "add-flat-binary into region",
+			entry: CbfstoolEntry{Operation: "add-flat-binary", Path: "memtest.bin", Name: "img/memtest", Region: "COREBOOT", OptionalArguments: []string{"-l", "0x10000", "-e", "0x10000"}},
+			path:  "/tmp/memtest.bin",
+			want:  []string{"cbfstool", "coreboot.rom", "add-flat-binary", "-f", "/tmp/memtest.bin", "-n", "img/memtest", "-r", "COREBOOT", "-l", "0x10000", "-e", "0x10000"},
+		},
+		{
+			name:  "remove ignores path, type and compression",
+			entry: CbfstoolEntry{Operation: "remove", Name: "img/nvramcui", Type: "raw", Compression: "lz4"},
+			want:  []string{"cbfstool", "coreboot.rom", "remove", "-n", "img/nvramcui"},
+		},
+		{
+			name:  "write ignores name and compression",
+			entry: CbfstoolEntry{Operation: "write", Path: "vboot.bin", Name: "vboot", Compression: "lzma", Region: "FW_MAIN_A"},
+			path:  "/tmp/vboot.bin",
+			want:  []string{"cbfstool", "coreboot.rom", "write", "-f", "/tmp/vboot.bin", "-r", "FW_MAIN_A"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, cbfstoolCmd(cbfstoolPath, "coreboot.rom", tc.entry, tc.path))
+		})
+	}
+
+	// coreboot uses cbfstool built from the same tree
+	entry := CbfstoolEntry{Operation: "remove", Name: "img/nvramcui"}
+	assert.Equal(t, []string{"build/cbfstool", "build/coreboot.rom", "remove", "-n", "img/nvramcui"}, cbfstoolCmd(corebootCbfstoolPath, "build/coreboot.rom", entry, ""))
+}
+
+func TestCbfstoolSources(t *testing.T) {
+	entries := []CbfstoolEntry{
+		{Operation: "add", Path: "logo.jpg", Name: "bootsplash.jpg", Type: "bootsplash"},
+		{Operation: "remove", Name: "img/nvramcui"},
+		{Operation: "add-payload", Path: "dep:linux-example/vmlinux", Name: "img/linux"},
+		{Operation: "write", Path: "vboot.bin", Region: "FW_MAIN_A"},
+	}
+
+	assert.Equal(t, []string{"logo.jpg", "vboot.bin"}, cbfstoolSources(entries))
+	assert.Equal(t, "/tmp/firmware-action-cbfstool/03_vboot.bin", cbfstoolContainerPath(3, "blobs/vboot.bin"))
+}
@@ -9,14 +9,17 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
 	"reflect"
 	"regexp"
 	"strings"
+	"time"
 
 	"dagger.io/dagger"
 	"github.com/9elements/firmware-action/cmd/firmware-action/container"
+	"github.com/9elements/firmware-action/cmd/firmware-action/environment"
 	"github.com/9elements/firmware-action/cmd/firmware-action/logging"
 	"github.com/go-playground/validator/v10"
 )
@@ -30,8 +33,16 @@ var (
 	ErrNestedOutputDirs = errors.New("nested output directories detected")
 	// ErrDuplicateOutputDirs is raised when multiple modules use the same output directory
 	ErrDuplicateOutputDirs = errors.New("duplicate output directories detected")
+	// ErrPreviousOutputCollision is raised when output directory of a module lies in '<output_dir>.prev'
+	// of another module with 'keep_previous_output'
+	ErrPreviousOutputCollision = errors.New("output directory collides with previous output of another module")
+	// ErrSecretUndefined is raised when environment variable listed in secrets is not present in the environment
+	ErrSecretUndefined = errors.New("environment variable listed in secrets is not present in the environment")
 )
 
+// NetworkNone is value of 'network' option which disables network access in build steps
+const NetworkNone = "none"
+
 // =================
 //  Data structures
 // =================
@@ -75,7 +86,17 @@ type CommonOpts struct {
 	ContainerOutputDirs []string `json:"container_output_dirs" validate:"dive,filepath|dirpath"`
 
 	// Specifies the (relative) paths to produced files (inside Container).
-	ContainerOutputFiles []string `json:"container_output_files" validate:"dive,filepath|dirpath"`
+	// Each entry is either a string with path, or an object (see ContainerOutputFile).
+	// Paths can contain glob patterns, for example 'build/*.rom' or 'Build/**/*.fd'.
+	// Example:
+	//   "container_output_files": [
+	//     "defconfig",
+	//     "build/*.rom",
+	//     { "from": "build/coreboot.rom", "to": "images/my-board.rom" },
+	//     { "from": "Build/**/*.fd", "to": "fd/" },
+	//     { "from": "build/coreboot.map", "optional": true }
+	//   ]
+	ContainerOutputFiles []ContainerOutputFile `json:"container_output_files" validate:"dive"`
 
 	// Specifies the (relative) path to directory into which place the produced files.
 	//   Directories listed in ContainerOutputDirs and files listed in ContainerOutputFiles
@@ -92,12 +113,27 @@ type CommonOpts struct {
 	//     └── defconfig
 	OutputDir string `json:"output_dir" validate:"required,filepath|dirpath"`
 
+	// Specifies whether to keep output of the previous build in '<output_dir>.prev'
+	//   when the module is rebuilt. Output directory is replaced only after successful build,
+	//   failed build always leaves the previous output untouched.
+	KeepPreviousOutput bool `json:"keep_previous_output"`
+
 	// Specifies the (relative) paths to directories which should be copied into the container.
 	InputDirs []string `json:"input_dirs" validate:"dive,filepath|dirpath"`
 
 	// Specifies the (relative) paths to file which should be copied into the container.
 	InputFiles []string `json:"input_files" validate:"dive,filepath|dirpath"`
 
+	// Specifies the (relative) paths to patches which are applied to the repository inside the container
+	//   before any build step is executed. Each item is either a patch file, or a directory with patch series.
+	//   Directory with quilt 'series' file is applied in the order given by 'series', otherwise all '*.patch'
+	//   and '*.diff' files in the directory are applied sorted by name (output of 'git format-patch').
+	//   Patches are applied with 'git apply', or with 'patch' if git can't be used. The repository on host
+	//   is left untouched.
+	// Example:
+	//   "patches": [ "patches/coreboot/", "fix-build.patch" ]
+	Patches []string `json:"patches" validate:"dive,filepath|dirpath"`
+
 	// Specifies the path to directory where to place input files and directories inside container.
 	//   Directories listed in ContainerInputDirs and files listed in ContainerInputFiles
 	//   will be copied there.
@@ -113,6 +149,55 @@ type CommonOpts struct {
 	//     └── Taskfile.yml
 	ContainerInputDir string `json:"container_input_dir" validate:"filepath|dirpath"`
 
+	// Specifies environment variables which should be defined inside the container.
+	// Example:
+	//   "env": { "BUILD_ID": "42", "VENDOR_NAME": "9elements" }
+	// NOTE: The values are stored in plain text together with the rest of the configuration
+	//   in '.firmware-action/configs/', do not use this for tokens and passwords (see 'secrets').
+	Env map[string]string `json:"env"`
+
+	// Specifies names of environment variables which should be passed from the host
+	//   into the container. Variables which are not defined on the host are skipped.
+	//   In case of conflict, variables defined in 'env' take precedence.
+	//   Change of their values triggers re-build, only hashes of the values are stored.
+	// Example:
+	//   "env_passthrough": [ "BUILD_ID", "CI_PIPELINE_ID" ]
+	EnvPassthrough []string `json:"env_passthrough"`
+
+	// Specifies names of environment variables which should be passed from the host
+	//   into the container as secrets. Values of secrets are not stored anywhere
+	//   and are masked in logs. All listed variables must be defined on the host.
+	// Example:
+	//   "secrets": [ "SIGNING_TOKEN" ]
+	Secrets []string `json:"secrets"`
+
+	// Specifies network access of build steps inside the container.
+	// Supported options:
+	//   - 'default' (or empty) - build steps have access to network
+	//   - 'none' - build steps have no access to network, any attempt to download something
+	//     will fail (pulling the container image and copying files is not affected)
+	//     Build steps then run in Dagger's insecure mode, without seccomp and AppArmor profiles,
+	//     and the container must contain 'unshare', 'setpriv', 'mount', 'find' and 'awk'
+	// Network can be disabled for all modules at once with '--hermetic' command line flag.
+	Network string `json:"network" validate:"omitempty,oneof=default none"`
+
+	// Specifies maximum time a single build attempt of the module may take, as Go duration.
+	//   When exceeded, the build is cancelled and fails. Empty means no limit.
+	// Example:
+	//   "timeout": "2h30m"
+	Timeout string `json:"timeout" validate:"omitempty,duration"`
+
+	// Specifies how many times the build is attempted again after a transient failure,
+	//   such as dagger session timeout or failed container image pull.
+	//   Failures of build steps themselves, and timeouts, are never retried.
+	Retries int `json:"retries" validate:"gte=0"`
+
+	// Specifies how long to wait before the first retry, as Go duration.
+	//   The wait is doubled with each further retry. Defaults to 30 seconds.
+	// Example:
+	//   "retry_backoff": "1m"
+	RetryBackoff string `json:"retry_backoff" validate:"omitempty,duration"`
+
 	// Overview:
 	//   NOTE: $PWD in the container is /workdir
 	//   defined in recipes.go with "ContainerWorkDir"
@@ -123,15 +208,92 @@ type CommonOpts struct {
 	// |                        |                        |                      |                                  |
 	// | OutputDir              | $(pwd)/$OutputDir      | Host <--  Container  | N/A                              |
 	// | ContainerOutputDirs    | $(pwd)/$OutputDir/...  | Host <--  Container  | $ContainerOutputDirs             |
-	// | ContainerOutputFiles   | $(pwd)/$OutputDir/...  | Host <--  Container  | $ContainerOutputFiles            |
+	// | ContainerOutputFiles   | $(pwd)/$OutputDir/...  | Host <--  Container  | $ContainerOutputFiles (globs)    |
 	// |                        |                        |                      |                                  |
 	// | ContainerInputDir      | N/A                    | Host  --> Container  | /workdir/$ContainerInputDir      |
 	// | InputDirs              | $InputDirs             | Host  --> Container  | /workdir/$ContainerInputDir/...  |
 	// | InputFiles             | $InputFiles            | Host  --> Container  | /workdir/$ContainerInputDir/...  |
+	// |                        |                        |                      |                                  |
+	// | Env                    | N/A                    | Host  --> Container  | $Env                             |
+	// | EnvPassthrough         | $EnvPassthrough        | Host  --> Container  | $EnvPassthrough                  |
+	// | Secrets                | $Secrets               | Host  --> Container  | $Secrets (masked)                |
 }
 
 // ANCHOR_END: CommonOpts
 
+// ContainerOutputFile specifies file (or files) to copy out of the container
+// In JSON configuration it can be either a string with path, or an object
+// ANCHOR: ContainerOutputFile
+type ContainerOutputFile struct {
+	// Specifies the (relative) path to produced file (inside Container), can contain glob pattern.
+	From string `json:"from" validate:"required,filepath|dirpath"`
+
+	// Specifies the (relative) path inside 'output_dir' where to place the file.
+	//   If empty, the file is placed directly into 'output_dir' under its original name.
+	//   If it ends with '/', or if 'from' is a glob pattern, it is a directory.
+	To string `json:"to" validate:"omitempty,localpath"`
+
+	// Do not fail if the file does not exist, or glob pattern does not match any file.
+	Optional bool `json:"optional"`
+}
+
+// ANCHOR_END: ContainerOutputFile
+
+// UnmarshalJSON allows to specify ContainerOutputFile as a plain string
+func (f *ContainerOutputFile) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*f = ContainerOutputFile{From: path}
+
+		return nil
+	}
+
+	// Alias type to avoid infinite recursion
+	type containerOutputFile ContainerOutputFile
+
+	var file containerOutputFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+
+	*f = ContainerOutputFile(file)
+
+	return nil
+}
+
+// MarshalJSON writes ContainerOutputFile as a plain string when possible
+func (f ContainerOutputFile) MarshalJSON() ([]byte, error) {
+	if f.To == "" && !f.Optional {
+		return json.Marshal(f.From)
+	}
+
+	// Alias type to avoid infinite recursion
+	type containerOutputFile ContainerOutputFile
+
+	return json.Marshal(containerOutputFile(f))
+}
+
+// IsGlobPattern returns true if the path in container contains glob pattern
+func (f ContainerOutputFile) IsGlobPattern() bool {
+	return container.IsGlobPattern(f.From)
+}
+
+// HostPath returns path on host where the file is placed
+// For glob patterns the path is a directory, into which all matching files are placed
+func (f ContainerOutputFile) HostPath(outputDir string) string {
+	hostPath := filepath.Join(outputDir, f.To)
+	if f.hostIsDir() && !f.IsGlobPattern() {
+		return filepath.Join(hostPath, filepath.Base(f.From))
+	}
+
+	return hostPath
+}
+
+// hostIsDir returns true if 'to' specifies a directory
+func (f ContainerOutputFile) hostIsDir() bool {
+	return f.To == "" || f.IsGlobPattern() || strings.HasSuffix(f.To, "/")
+}
+
 // GetArtifacts returns list of wanted artifacts from container
 func (opts CommonOpts) GetArtifacts() *[]container.Artifacts {
 	var artifacts []container.Artifacts
@@ -147,12 +309,13 @@ func (opts CommonOpts) GetArtifacts() *[]container.Artifacts {
 	}
 
 	// Files
-	for _, pathFile := range opts.ContainerOutputFiles {
+	for _, file := range opts.ContainerOutputFiles {
 		artifacts = append(artifacts, container.Artifacts{
-			ContainerPath: filepath.Join(ContainerWorkDir, pathFile),
+			ContainerPath: filepath.Join(ContainerWorkDir, file.From),
 			ContainerDir:  false,
-			HostPath:      opts.OutputDir,
-			HostDir:       true,
+			HostPath:      filepath.Join(opts.OutputDir, file.To),
+			HostDir:       file.hostIsDir(),
+			Optional:      file.Optional,
 		})
 	}
 
@@ -164,8 +327,8 @@ func (opts CommonOpts) GetContainerOutputDirs() []string {
 	return opts.ContainerOutputDirs
 }
 
-// GetContainerOutputFiles returns list of output directories
-func (opts CommonOpts) GetContainerOutputFiles() []string {
+// GetContainerOutputFiles returns list of output files
+func (opts CommonOpts) GetContainerOutputFiles() []ContainerOutputFile {
 	return opts.ContainerOutputFiles
 }
 
@@ -187,16 +350,115 @@ func (opts CommonOpts) GetSources() []string {
 	sources = append(sources, opts.InputDirs[:]...)
 	sources = append(sources, opts.InputFiles[:]...)
 
+	// Patches
+	sources = append(sources, opts.Patches[:]...)
+
 	return sources
 }
 
 // ANCHOR_END: CommonOptsGetSources
 
+// sbomBlobs returns list of binary blobs built into the firmware, by default there are none
+func (opts CommonOpts) sbomBlobs() []sbomBlob {
+	return nil
+}
+
+// GetSdkURL returns URL of the container image used for build
+func (opts CommonOpts) GetSdkURL() string {
+	return opts.SdkURL
+}
+
 // GetRepoPath returns Repository path
 func (opts CommonOpts) GetRepoPath() string {
 	return opts.RepoPath
 }
 
+// envPassthrough returns environment variables listed in 'env_passthrough' which are defined on the host
+func (opts CommonOpts) envPassthrough() map[string]string {
+	return environment.FetchEnvVars(opts.EnvPassthrough)
+}
+
+// GetEnvVars returns environment variables which should be defined inside the container
+func (opts CommonOpts) GetEnvVars() map[string]string {
+	envVars := opts.envPassthrough()
+
+	for _, name := range opts.EnvPassthrough {
+		if _, ok := envVars[name]; !ok {
+			slog.Warn(
+				fmt.Sprintf("Environment variable '%s' listed in 'env_passthrough' is not defined, skipping", name),
+			)
+		}
+	}
+
+	maps.Copy(envVars, opts.Env)
+
+	return envVars
+}
+
+// GetSecrets returns secrets which should be defined inside the container
+func (opts CommonOpts) GetSecrets() (map[string]string, error) {
+	secrets := environment.FetchEnvVars(opts.Secrets)
+
+	for _, name := range opts.Secrets {
+		if _, ok := secrets[name]; !ok {
+			err := fmt.Errorf("%w: %s", ErrSecretUndefined, name)
+			slog.Error(
+				fmt.Sprintf("Environment variable '%s' listed in 'secrets' is undefined", name),
+				slog.String("suggestion", "define the environment variable in the environment"),
+				slog.Any("error", err),
+			)
+
+			return nil, err
+		}
+	}
+
+	return secrets, nil
+}
+
+// networkDisabled returns whether build steps should run without access to network
+func (opts CommonOpts) networkDisabled(ctx context.Context) bool {
+	return opts.Network == NetworkNone || GetBuildOptions(ctx).Hermetic
+}
+
+// outputDirs returns directories on host into which artifacts are exported, by default only the output directory
+func (opts CommonOpts) outputDirs() ([]string, error) {
+	return []string{opts.OutputDir}, nil
+}
+
+// keepPreviousOutput returns whether output of the previous build should be kept on rebuild
+func (opts CommonOpts) keepPreviousOutput() bool {
+	return opts.KeepPreviousOutput
+}
+
+// retryPolicy returns timeout of a single build attempt, number of retries and initial backoff
+// Durations are checked by ValidateConfig, invalid or empty values fall back to defaults
+func (opts CommonOpts) retryPolicy() (time.Duration, int, time.Duration) {
+	timeout, err := time.ParseDuration(opts.Timeout)
+	if err != nil {
+		timeout = 0
+	}
+
+	backoff, err := time.ParseDuration(opts.RetryBackoff)
+	if err != nil {
+		backoff = defaultRetryBackoff
+	}
+
+	return timeout, opts.Retries, backoff
+}
+
+// containerEnvVars merges recipe-specific environment variables with user-defined ones
+// In case of conflict the user-defined variables take precedence
+func (opts CommonOpts) containerEnvVars(recipeEnvVars map[string]string) map[string]string {
+	envVars := maps.Clone(recipeEnvVars)
+	if envVars == nil {
+		envVars = map[string]string{}
+	}
+
+	maps.Copy(envVars, opts.GetEnvVars())
+
+	return envVars
+}
+
 // Config is for storing parsed configuration file
 type Config struct {
 	// defined in coreboot.go
@@ -252,6 +514,25 @@ func (c Config) AllModules() map[string]FirmwareModule {
 	return modules
 }
 
+// ModuleType returns type of the module (as used in JSON configuration file), for example 'coreboot'
+// Returns empty string if there is no such module
+func (c Config) ModuleType(target string) string {
+	configValue := reflect.ValueOf(c)
+
+	for i := range configValue.Type().NumField() {
+		fieldValue := configValue.Field(i)
+		if fieldValue.Kind() != reflect.Map {
+			continue
+		}
+
+		if fieldValue.MapIndex(reflect.ValueOf(target)).IsValid() {
+			return strings.Split(configValue.Type().Field(i).Tag.Get("json"), ",")[0]
+		}
+	}
+
+	return ""
+}
+
 // Merge method will take other Config instance and adopt all of its modules
 func (c Config) Merge(other Config) (Config, error) {
 	merged := Config{}
@@ -306,11 +587,22 @@ type FirmwareModule interface {
 	GetDepends() []string
 	GetArtifacts() *[]container.Artifacts
 	GetContainerOutputDirs() []string
-	GetContainerOutputFiles() []string
+	GetContainerOutputFiles() []ContainerOutputFile
 	GetOutputDir() string
 	GetSources() []string
 	buildFirmware(ctx context.Context, client *dagger.Client) error
+	prepareContainer(ctx context.Context, client *dagger.Client) (*dagger.Container, [][]string, error)
+	networkDisabled(ctx context.Context) bool
+	retryPolicy() (time.Duration, int, time.Duration)
+	keepPreviousOutput() bool
+	outputDirs() ([]string, error)
+	envPassthrough() map[string]string
 	GetRepoPath() string
+	GetSdkURL() string
+	sbomBlobs() []sbomBlob
+	// withCommonOpts returns a copy of the module with modified common options, the original
+	// module is not changed, but be careful with maps and slices, they are shared
+	withCommonOpts(modify func(opts *CommonOpts)) FirmwareModule
 }
 
 // ======================
@@ -322,7 +614,33 @@ func ValidateConfig(conf Config) error {
 	// https://github.com/go-playground/validator/blob/master/_examples/struct-level/main.go
 	validate := validator.New(validator.WithRequiredStructEnabled())
 
-	err := validate.Struct(conf)
+	// Path must stay within the directory it is relative to
+	err := validate.RegisterValidation("localpath", func(fl validator.FieldLevel) bool {
+		return filepath.IsLocal(fl.Field().String())
+	})
+	if err != nil {
+		return err
+	}
+
+	// Value of Kconfig option as written in defconfig
+	err = validate.RegisterValidation("kconfig_value", func(fl validator.FieldLevel) bool {
+		return kconfigValuePattern.MatchString(fl.Field().String())
+	})
+	if err != nil {
+		return err
+	}
+
+	// Go duration such as '90s' or '2h30m', negative durations make no sense
+	err = validate.RegisterValidation("duration", func(fl validator.FieldLevel) bool {
+		duration, err := time.ParseDuration(fl.Field().String())
+
+		return err == nil && duration >= 0
+	})
+	if err != nil {
+		return err
+	}
+
+	err = validate.Struct(conf)
 	if err != nil {
 		err = errors.Join(ErrFailedValidation, err)
 		slog.Error(
@@ -415,6 +733,31 @@ func validateOutputDirectories(modules map[string]FirmwareModule) error {
 		}
 	}
 
+	// Check that no output directory is inside '<output_dir>.prev' of another module,
+	//   it would be removed when the other module is rebuilt
+	for moduleName, module := range modules {
+		if !module.keepPreviousOutput() {
+			continue
+		}
+
+		previous := filepath.Clean(module.GetOutputDir()) + PreviousOutputSuffix
+
+		for dir, otherModule := range outputDirs {
+			dirSep := string(filepath.Separator)
+			if strings.HasPrefix(dir+dirSep, previous+dirSep) {
+				errMsg := fmt.Sprintf("output directory '%s' of module '%s' is inside '%s', the previous output of module '%s'",
+					dir, otherModule, previous, moduleName)
+				err := fmt.Errorf("%w: %s", ErrPreviousOutputCollision, errMsg)
+				slog.Error(
+					"Detected output directory colliding with previous output",
+					slog.String("suggestion", "Rename the output directory, or disable 'keep_previous_output' of the other module. Previous output is replaced on each re-build."),
+					slog.Any("error", err),
+				)
+				issues = append(issues, err)
+			}
+		}
+	}
+
 	// If we found any issues, return a combined error
 	if len(issues) > 0 {
 		var combinedErr error
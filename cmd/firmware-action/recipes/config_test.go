@@ -4,6 +4,7 @@
 package recipes
 
 import (
+	"encoding/json"
 	"path/filepath"
 	"reflect"
 	"testing"
@@ -206,6 +207,357 @@ func TestValidateConfig(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:    "network disabled",
+			wantErr: nil,
+			opts: Config{
+				Coreboot: map[string]CorebootOpts{
+					"coreboot-A": {
+						CommonOpts: CommonOpts{
+							SdkURL:            commonDummy.SdkURL,
+							RepoPath:          commonDummy.RepoPath,
+							OutputDir:         commonDummy.OutputDir,
+							ContainerInputDir: commonDummy.ContainerInputDir,
+							Network:           NetworkNone,
+						},
+						DefconfigPath: "dummy",
+					},
+				},
+			},
+		},
+		{
+			name:    "unsupported network option",
+			wantErr: ErrFailedValidation,
+			opts: Config{
+				Coreboot: map[string]CorebootOpts{
+					"coreboot-A": {
+						CommonOpts: CommonOpts{
+							SdkURL:            commonDummy.SdkURL,
+							RepoPath:          commonDummy.RepoPath,
+							OutputDir:         commonDummy.OutputDir,
+							ContainerInputDir: commonDummy.ContainerInputDir,
+							Network:           "host",
+						},
+						DefconfigPath: "dummy",
+					},
+				},
+			},
+		},
+		{
+			name:    "timeout and retry backoff",
+			wantErr: nil,
+			opts: Config{
+				Coreboot: map[string]CorebootOpts{
+					"coreboot-A": {
+						CommonOpts: CommonOpts{
+							SdkURL:            commonDummy.SdkURL,
+							RepoPath:          commonDummy.RepoPath,
+							OutputDir:         commonDummy.OutputDir,
+							ContainerInputDir: commonDummy.ContainerInputDir,
+							Timeout:           "2h30m",
+							RetryBackoff:      "1m",
+						},
+						DefconfigPath: "dummy",
+					},
+				},
+			},
+		},
+		{
+			name:    "negative timeout",
+			wantErr: ErrFailedValidation,
+			opts: Config{
+				Coreboot: map[string]CorebootOpts{
+					"coreboot-A": {
+						CommonOpts: CommonOpts{
+							SdkURL:            commonDummy.SdkURL,
+							RepoPath:          commonDummy.RepoPath,
+							OutputDir:         commonDummy.OutputDir,
+							ContainerInputDir: commonDummy.ContainerInputDir,
+							Timeout:           "-1h",
+							RetryBackoff:      "1m",
+						},
+						DefconfigPath: "dummy",
+					},
+				},
+			},
+		},
+		{
+			name:    "invalid timeout",
+			wantErr: ErrFailedValidation,
+			opts: Config{
+				Coreboot: map[string]CorebootOpts{
+					"coreboot-A": {
+						CommonOpts: CommonOpts{
+							SdkURL:            commonDummy.SdkURL,
+							RepoPath:          commonDummy.RepoPath,
+							OutputDir:         commonDummy.OutputDir,
+							ContainerInputDir: commonDummy.ContainerInputDir,
+							Timeout:           "2 hours",
+							RetryBackoff:      "1m",
+						},
+						DefconfigPath: "dummy",
+					},
+				},
+			},
+		},
+		{
+			name:    "output files with glob and rename",
+			wantErr: nil,
+			opts: Config{
+				Coreboot: map[string]CorebootOpts{
+					"coreboot-A": {
+						CommonOpts: CommonOpts{
+							SdkURL:               commonDummy.SdkURL,
+							RepoPath:             commonDummy.RepoPath,
+							OutputDir:            commonDummy.OutputDir,
+							ContainerInputDir:    commonDummy.ContainerInputDir,
+							ContainerOutputFiles: []ContainerOutputFile{{From: "build/*.rom", To: "roms/"}, {From: "defconfig", To: "configs/my_defconfig", Optional: true}},
+						},
+						DefconfigPath: "dummy",
+					},
+				},
+			},
+		},
+		{
+			name:    "output file renamed outside of output directory",
+			wantErr: ErrFailedValidation,
+			opts: Config{
+				Coreboot: map[string]CorebootOpts{
+					"coreboot-A": {
+						CommonOpts: CommonOpts{
+							SdkURL:               commonDummy.SdkURL,
+							RepoPath:             commonDummy.RepoPath,
+							OutputDir:            commonDummy.OutputDir,
+							ContainerInputDir:    commonDummy.ContainerInputDir,
+							ContainerOutputFiles: []ContainerOutputFile{{From: "build/coreboot.rom", To: "../coreboot.rom"}},
+						},
+						DefconfigPath: "dummy",
+					},
+				},
+			},
+		},
+		{
+			name:    "kconfig overrides",
+			wantErr: nil,
+			opts: Config{
+				Coreboot: map[string]CorebootOpts{
+					"coreboot-A": {
+						CommonOpts:    commonDummy,
+						DefconfigPath: "dummy",
+						KconfigOpts: KconfigOpts{
+							ConfigFragments: []string{"debug.fragment"},
+							Kconfig: map[string]string{
+								"CONFIG_CONSOLE_SERIAL":   "y",
+								"CONFIG_TPM2":             "n",
+								"CONFIG_LOCALVERSION":     `"build \"42\""`,
+								"CONFIG_UART_FOR_CONSOLE": "1",
+								"CONFIG_CBFS_SIZE":        "0x200000",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:    "kconfig override without CONFIG_ prefix",
+			wantErr: ErrFailedValidation,
+			opts: Config{
+				Coreboot: map[string]CorebootOpts{
+					"coreboot-A": {
+						CommonOpts:    commonDummy,
+						DefconfigPath: "dummy",
+						KconfigOpts:   KconfigOpts{Kconfig: map[string]string{"CONSOLE_SERIAL": "y"}},
+					},
+				},
+			},
+		},
+		{
+			name:    "kconfig override with unquoted string",
+			wantErr: ErrFailedValidation,
+			opts: Config{
+				Coreboot: map[string]CorebootOpts{
+					"coreboot-A": {
+						CommonOpts:    commonDummy,
+						DefconfigPath: "dummy",
+						KconfigOpts:   KconfigOpts{Kconfig: map[string]string{"CONFIG_LOCALVERSION": "build 42"}},
+					},
+				},
+			},
+		},
+		{
+			name:    "unsupported defconfig check",
+			wantErr: ErrFailedValidation,
+			opts: Config{
+				Coreboot: map[string]CorebootOpts{
+					"coreboot-A": {
+						CommonOpts:    commonDummy,
+						DefconfigPath: "dummy",
+						KconfigOpts:   KconfigOpts{DefconfigCheck: "ignore"},
+					},
+				},
+			},
+		},
+		{
+			name:    "edk2 build command",
+			wantErr: nil,
+			opts: Config{
+				Edk2: map[string]Edk2Opts{
+					"edk2-A": {
+						CommonOpts:   commonDummy,
+						Edk2Specific: Edk2Specific{BuildCommand: "source ./edksetup.sh; build"},
+					},
+				},
+			},
+		},
+		{
+			name:    "edk2 structured build",
+			wantErr: nil,
+			opts: Config{
+				Edk2: map[string]Edk2Opts{
+					"edk2-A": {
+						CommonOpts: commonDummy,
+						Edk2Specific: Edk2Specific{
+							Platform:    "UefiPayloadPkg/UefiPayloadPkg.dsc",
+							Toolchain:   "GCC5",
+							BuildTarget: "RELEASE",
+							Defines:     map[string]string{"BOOTLOADER": "COREBOOT"},
+							ReportTypes: []string{"PCD", "FLASH"},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:    "edk2 without build command and platform",
+			wantErr: ErrFailedValidation,
+			opts: Config{
+				Edk2: map[string]Edk2Opts{
+					"edk2-A": {CommonOpts: commonDummy},
+				},
+			},
+		},
+		{
+			name:    "edk2 platform without toolchain",
+			wantErr: ErrFailedValidation,
+			opts: Config{
+				Edk2: map[string]Edk2Opts{
+					"edk2-A": {
+						CommonOpts:   commonDummy,
+						Edk2Specific: Edk2Specific{Platform: "OvmfPkg/OvmfPkgX64.dsc"},
+					},
+				},
+			},
+		},
+		{
+			name:    "edk2 platform together with defconfig",
+			wantErr: ErrFailedValidation,
+			opts: Config{
+				Edk2: map[string]Edk2Opts{
+					"edk2-A": {
+						CommonOpts:    commonDummy,
+						DefconfigPath: "dummy",
+						Edk2Specific:  Edk2Specific{Platform: "OvmfPkg/OvmfPkgX64.dsc", Toolchain: "GCC5"},
+					},
+				},
+			},
+		},
+		{
+			name:    "edk2 define with space in name",
+			wantErr: ErrFailedValidation,
+			opts: Config{
+				Edk2: map[string]Edk2Opts{
+					"edk2-A": {
+						CommonOpts: commonDummy,
+						Edk2Specific: Edk2Specific{
+							Platform:  "OvmfPkg/OvmfPkgX64.dsc",
+							Toolchain: "GCC5",
+							Defines:   map[string]string{"TPM ENABLE": "TRUE"},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:    "multi-board coreboot",
+			wantErr: nil,
+			opts: Config{
+				Coreboot: map[string]CorebootOpts{
+					"coreboot-A": {
+						CommonOpts: commonDummy,
+						Defconfigs: []string{"boards/", "dummy"},
+					},
+				},
+			},
+		},
+		{
+			name:    "defconfig_path together with defconfigs",
+			wantErr: ErrFailedValidation,
+			opts: Config{
+				Coreboot: map[string]CorebootOpts{
+					"coreboot-A": {
+						CommonOpts:    commonDummy,
+						DefconfigPath: "dummy",
+						Defconfigs:    []string{"boards/"},
+					},
+				},
+			},
+		},
+		{
+			name:    "valid cbfstool entries",
+			wantErr: nil,
+			opts: Config{
+				Coreboot: map[string]CorebootOpts{
+					"coreboot-A": {
+						CommonOpts:    commonDummy,
+						DefconfigPath: "dummy",
+						CbfstoolEntries: []CbfstoolEntry{
+							{Operation: "add", Path: "logo.jpg", Name: "bootsplash.jpg", Type: "bootsplash", Compression: "lzma"},
+							{Operation: "remove", Name: "img/nvramcui"},
+							{Operation: "write", Path: "vboot.bin", Region: "FW_MAIN_A"},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:    "cbfstool add without type",
+			wantErr: ErrFailedValidation,
+			opts: Config{
+				Coreboot: map[string]CorebootOpts{
+					"coreboot-A": {
+						CommonOpts:      commonDummy,
+						DefconfigPath:   "dummy",
+						CbfstoolEntries: []CbfstoolEntry{{Operation: "add", Path: "vbt.bin", Name: "vbt.bin"}},
+					},
+				},
+			},
+		},
+		{
+			name:    "cbfstool unknown operation",
+			wantErr: ErrFailedValidation,
+			opts: Config{
+				FirmwareStitching: map[string]FirmwareStitchingOpts{
+					"stitching-A": {
+						CommonOpts:      commonDummy,
+						BaseFilePath:    "dummy",
+						CbfstoolEntries: []CbfstoolEntry{{Operation: "extract", Path: "vbt.bin", Name: "vbt.bin"}},
+					},
+				},
+			},
+		},
+		{
+			name:    "cbfstool write without region",
+			wantErr: ErrFailedValidation,
+			opts: Config{
+				FirmwareStitching: map[string]FirmwareStitchingOpts{
+					"stitching-A": {
+						CommonOpts:      commonDummy,
+						BaseFilePath:    "dummy",
+						CbfstoolEntries: []CbfstoolEntry{{Operation: "write", Path: "vboot.bin"}},
+					},
+				},
+			},
+		},
 		{
 			name:    "missing common opts",
 			wantErr: ErrFailedValidation,
@@ -380,6 +732,93 @@ func TestValidateConfigNestedOutputDirs(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:    "invalid - output dir is previous output of another module",
+			wantErr: ErrPreviousOutputCollision,
+			opts: Config{
+				Coreboot: map[string]CorebootOpts{
+					"coreboot-A": {
+						CommonOpts: CommonOpts{
+							SdkURL:             commonDummy.SdkURL,
+							RepoPath:           commonDummy.RepoPath,
+							OutputDir:          "output/",
+							ContainerInputDir:  commonDummy.ContainerInputDir,
+							KeepPreviousOutput: true,
+						},
+						DefconfigPath: "dummy",
+					},
+				},
+				Linux: map[string]LinuxOpts{
+					"linux-A": {
+						CommonOpts: CommonOpts{
+							SdkURL:            commonDummy.SdkURL,
+							RepoPath:          commonDummy.RepoPath,
+							OutputDir:         "output.prev/",
+							ContainerInputDir: commonDummy.ContainerInputDir,
+						},
+						DefconfigPath: "dummy",
+					},
+				},
+			},
+		},
+		{
+			name:    "invalid - output dir inside previous output of another module",
+			wantErr: ErrPreviousOutputCollision,
+			opts: Config{
+				Coreboot: map[string]CorebootOpts{
+					"coreboot-A": {
+						CommonOpts: CommonOpts{
+							SdkURL:             commonDummy.SdkURL,
+							RepoPath:           commonDummy.RepoPath,
+							OutputDir:          "output/",
+							ContainerInputDir:  commonDummy.ContainerInputDir,
+							KeepPreviousOutput: true,
+						},
+						DefconfigPath: "dummy",
+					},
+				},
+				Linux: map[string]LinuxOpts{
+					"linux-A": {
+						CommonOpts: CommonOpts{
+							SdkURL:            commonDummy.SdkURL,
+							RepoPath:          commonDummy.RepoPath,
+							OutputDir:         "output.prev/linux/",
+							ContainerInputDir: commonDummy.ContainerInputDir,
+						},
+						DefconfigPath: "dummy",
+					},
+				},
+			},
+		},
+		{
+			name:    "valid - previous output is not kept",
+			wantErr: nil,
+			opts: Config{
+				Coreboot: map[string]CorebootOpts{
+					"coreboot-A": {
+						CommonOpts: CommonOpts{
+							SdkURL:             commonDummy.SdkURL,
+							RepoPath:           commonDummy.RepoPath,
+							OutputDir:          "output/",
+							ContainerInputDir:  commonDummy.ContainerInputDir,
+							KeepPreviousOutput: false,
+						},
+						DefconfigPath: "dummy",
+					},
+				},
+				Linux: map[string]LinuxOpts{
+					"linux-A": {
+						CommonOpts: CommonOpts{
+							SdkURL:            commonDummy.SdkURL,
+							RepoPath:          commonDummy.RepoPath,
+							OutputDir:         "output.prev/",
+							ContainerInputDir: commonDummy.ContainerInputDir,
+						},
+						DefconfigPath: "dummy",
+					},
+				},
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -656,3 +1095,233 @@ func TestOffsetToLineNumber(t *testing.T) {
 		})
 	}
 }
+
+func TestCommonOptsGetEnvVars(t *testing.T) {
+	testCases := []struct {
+		name     string
+		opts     CommonOpts
+		hostEnv  map[string]string
+		expected map[string]string
+	}{
+		{
+			name:     "empty",
+			opts:     CommonOpts{},
+			expected: map[string]string{},
+		},
+		{
+			name: "literal values",
+			opts: CommonOpts{
+				Env: map[string]string{"BUILD_ID": "42"},
+			},
+			expected: map[string]string{"BUILD_ID": "42"},
+		},
+		{
+			name: "passthrough with missing variable",
+			opts: CommonOpts{
+				EnvPassthrough: []string{"FA_TEST_PRESENT", "FA_TEST_MISSING"},
+			},
+			hostEnv:  map[string]string{"FA_TEST_PRESENT": "present"},
+			expected: map[string]string{"FA_TEST_PRESENT": "present"},
+		},
+		{
+			name: "literal value takes precedence",
+			opts: CommonOpts{
+				Env:            map[string]string{"FA_TEST_PRESENT": "literal"},
+				EnvPassthrough: []string{"FA_TEST_PRESENT"},
+			},
+			hostEnv:  map[string]string{"FA_TEST_PRESENT": "present"},
+			expected: map[string]string{"FA_TEST_PRESENT": "literal"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for key, value := range tc.hostEnv {
+				t.Setenv(key, value)
+			}
+
+			assert.Equal(t, tc.expected, tc.opts.GetEnvVars())
+		})
+	}
+
+	t.Run("user-defined variables override recipe", func(t *testing.T) {
+		opts := CommonOpts{Env: map[string]string{"ARCH": "arm64"}}
+		recipeEnvVars := map[string]string{"ARCH": "x86", "CROSS_COMPILE": "aarch64-linux-gnu-"}
+
+		envVars := opts.containerEnvVars(recipeEnvVars)
+		assert.Equal(t, map[string]string{"ARCH": "arm64", "CROSS_COMPILE": "aarch64-linux-gnu-"}, envVars)
+		// Recipe map must stay untouched
+		assert.Equal(t, "x86", recipeEnvVars["ARCH"])
+	})
+}
+
+func TestCommonOptsGetSecrets(t *testing.T) {
+	t.Setenv("FA_TEST_SECRET", "hunter2")
+
+	opts := CommonOpts{Secrets: []string{"FA_TEST_SECRET"}}
+	secrets, err := opts.GetSecrets()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"FA_TEST_SECRET": "hunter2"}, secrets)
+
+	opts.Secrets = append(opts.Secrets, "FA_TEST_SECRET_MISSING")
+	_, err = opts.GetSecrets()
+	assert.ErrorIs(t, err, ErrSecretUndefined)
+}
+
+func TestCommonOptsNetworkDisabled(t *testing.T) {
+	testCases := []struct {
+		name     string
+		network  string
+		hermetic bool
+		expected bool
+	}{
+		{
+			name:     "default",
+			expected: false,
+		},
+		{
+			name:     "explicit default",
+			network:  "default",
+			expected: false,
+		},
+		{
+			name:     "module without network",
+			network:  NetworkNone,
+			expected: true,
+		},
+		{
+			name:     "hermetic build",
+			hermetic: true,
+			expected: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := WithBuildOptions(t.Context(), BuildOptions{Hermetic: tc.hermetic})
+			opts := CommonOpts{Network: tc.network}
+
+			assert.Equal(t, tc.expected, opts.networkDisabled(ctx))
+		})
+	}
+}
+
+func TestContainerOutputFileJSON(t *testing.T) {
+	testCases := []struct {
+		name string
+		json string
+		file ContainerOutputFile
+	}{
+		{
+			name: "plain string",
+			json: `"build/coreboot.rom"`,
+			file: ContainerOutputFile{From: "build/coreboot.rom"},
+		},
+		{
+			name: "object",
+			json: `{"from":"build/coreboot.rom","to":"images/board.rom","optional":true}`,
+			file: ContainerOutputFile{From: "build/coreboot.rom", To: "images/board.rom", Optional: true},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var file ContainerOutputFile
+
+			assert.NoError(t, json.Unmarshal([]byte(tc.json), &file))
+			assert.Equal(t, tc.file, file)
+
+			// Round trip
+			data, err := json.Marshal(file)
+			assert.NoError(t, err)
+			assert.JSONEq(t, tc.json, string(data))
+		})
+	}
+}
+
+func TestCommonOptsGetArtifacts(t *testing.T) {
+	opts := CommonOpts{
+		OutputDir: "output",
+		ContainerOutputFiles: []ContainerOutputFile{
+			{From: "build/coreboot.rom"},
+			{From: "build/coreboot.rom", To: "images/board.rom"},
+			{From: "build/coreboot.rom", To: "images/"},
+			{From: "Build/**/*.fd", Optional: true},
+		},
+	}
+
+	testCases := []struct {
+		wantHostPath string
+		wantHostDir  bool
+		wantFilePath string
+	}{
+		{wantHostPath: "output", wantHostDir: true, wantFilePath: "output/coreboot.rom"},
+		{wantHostPath: "output/images/board.rom", wantHostDir: false, wantFilePath: "output/images/board.rom"},
+		{wantHostPath: "output/images", wantHostDir: true, wantFilePath: "output/images/coreboot.rom"},
+		{wantHostPath: "output", wantHostDir: true, wantFilePath: "output"},
+	}
+
+	artifacts := *opts.GetArtifacts()
+	assert.Len(t, artifacts, len(testCases))
+
+	for i, tc := range testCases {
+		assert.Equal(t, filepath.Join(ContainerWorkDir, opts.ContainerOutputFiles[i].From), artifacts[i].ContainerPath)
+		assert.Equal(t, tc.wantHostPath, artifacts[i].HostPath)
+		assert.Equal(t, tc.wantHostDir, artifacts[i].HostDir)
+		assert.Equal(t, opts.ContainerOutputFiles[i].Optional, artifacts[i].Optional)
+		assert.Equal(t, tc.wantFilePath, opts.ContainerOutputFiles[i].HostPath(opts.OutputDir))
+	}
+}
+
+func TestConfigModuleType(t *testing.T) {
+	config := Config{
+		Coreboot: map[string]CorebootOpts{"coreboot-A": {}},
+		URoot:    map[string]URootOpts{"u-root-A": {}},
+		FirmwareStitching: map[string]FirmwareStitchingOpts{
+			"stitching-A": {},
+		},
+	}
+
+	assert.Equal(t, "coreboot", config.ModuleType("coreboot-A"))
+	assert.Equal(t, "u-root", config.ModuleType("u-root-A"))
+	assert.Equal(t, "firmware_stitching", config.ModuleType("stitching-A"))
+	assert.Equal(t, "", config.ModuleType("dummy"))
+}
+
+func TestWithCommonOpts(t *testing.T) {
+	original := CorebootOpts{
+		CommonOpts:    CommonOpts{OutputDir: "output-coreboot"},
+		DefconfigPath: "defconfig",
+	}
+
+	modified := original.withCommonOpts(func(opts *CommonOpts) {
+		opts.OutputDir = "somewhere-else"
+	})
+
+	// Original is untouched
+	assert.Equal(t, "output-coreboot", original.OutputDir)
+
+	corebootOpts, ok := modified.(CorebootOpts)
+	assert.True(t, ok)
+	assert.Equal(t, "somewhere-else", corebootOpts.OutputDir)
+	assert.Equal(t, original.DefconfigPath, corebootOpts.DefconfigPath)
+
+	// Every module type returns a copy of itself
+	modules := map[string]FirmwareModule{
+		"coreboot":           CorebootOpts{},
+		"edk2":               Edk2Opts{},
+		"firmware_stitching": FirmwareStitchingOpts{},
+		"linux":              LinuxOpts{},
+		"u-boot":             UBootOpts{},
+		"universal":          UniversalOpts{},
+		"u-root":             URootOpts{},
+	}
+	for name, module := range modules {
+		t.Run(name, func(t *testing.T) {
+			modified := module.withCommonOpts(func(opts *CommonOpts) {
+				opts.OutputDir = "somewhere-else"
+			})
+
+			assert.IsType(t, module, modified)
+			assert.Equal(t, "somewhere-else", modified.GetOutputDir())
+			assert.Empty(t, module.GetOutputDir())
+		})
+	}
+}
@@ -8,9 +8,11 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 
 	"dagger.io/dagger"
@@ -44,7 +46,18 @@ type CorebootOpts struct {
 	Depends []string `json:"depends"`
 
 	// Gives the (relative) path to the defconfig that should be used to build the target.
-	DefconfigPath string `json:"defconfig_path" validate:"required,filepath"`
+	// Either 'defconfig_path' or 'defconfigs' must be set.
+	DefconfigPath string `json:"defconfig_path" validate:"required_without=Defconfigs,excluded_with=Defconfigs,omitempty,filepath"`
+
+	// Multi-board build, list of (relative) paths to defconfigs or to directories with defconfigs.
+	// All boards are built one after another in the same container, with 'make distclean' in between,
+	//   artifacts of each board are stored in '<output_dir>/<defconfig-name>/'.
+	// Example:
+	//   ["boards/qemu_q35_defconfig", "boards/protectli/"]
+	Defconfigs []string `json:"defconfigs" validate:"dive,filepath|dirpath"`
+
+	// Kconfig fragments and overrides applied on top of the defconfig
+	KconfigOpts
 
 	// Blobs
 	// The blobs will be copied into the container into directory:
@@ -56,7 +69,15 @@ type CorebootOpts struct {
 	//     "CONFIG_PAYLOAD_FILE": "./my-payload.bin"
 	//   Will result in blob "my-payload.bin" at
 	//     "3rdparty/blobs/mainboard/${CONFIG_MAINBOARD_DIR}/my-payload.bin"
+	// Blob can also reference artifact of a module listed in 'depends' as 'dep:<module-id>/<file>',
+	//   such blob is not copied, the config value points into '/deps/<module-id>/<file>'
+	// Example:
+	//     "CONFIG_PAYLOAD_FILE": "dep:edk2-example/UEFIPAYLOAD.fd"
 	Blobs map[string]string `json:"blobs"`
+
+	// List of instructions for cbfstool, applied to 'build/coreboot.rom' after the build
+	// Useful for files which are not part of coreboot build, for example bootsplash or VBT
+	CbfstoolEntries []CbfstoolEntry `json:"cbfstool_entries" validate:"dive"`
 }
 
 // ANCHOR_END: CorebootOpts
@@ -71,6 +92,13 @@ func (opts CorebootOpts) GetArtifacts() *[]container.Artifacts {
 	return opts.CommonOpts.GetArtifacts()
 }
 
+// withCommonOpts returns a copy of the module with modified common options
+func (opts CorebootOpts) withCommonOpts(modify func(opts *CommonOpts)) FirmwareModule {
+	modify(&opts.CommonOpts)
+
+	return opts
+}
+
 // ANCHOR: CorebootOptsGetSources
 
 // GetSources returns slice of paths to all sources which are used for build
@@ -78,7 +106,13 @@ func (opts CorebootOpts) GetSources() []string {
 	sources := opts.CommonOpts.GetSources()
 
 	// Add DefconfigPath to list of sources
-	sources = append(sources, opts.DefconfigPath)
+	if opts.DefconfigPath != "" {
+		sources = append(sources, opts.DefconfigPath)
+	}
+
+	sources = append(sources, opts.Defconfigs...)
+	sources = append(sources, opts.kconfigSources()...)
+	sources = append(sources, cbfstoolSources(opts.CbfstoolEntries)...)
 
 	// Add blobs to list of sources
 	blobs, err := opts.ProcessBlobs()
@@ -103,6 +137,11 @@ func (opts CorebootOpts) GetSources() []string {
 	}
 
 	for blob := range blobs {
+		if isDependencyPath(blobs[blob].Path) {
+			// Artifacts of dependencies are not sources, change detection covers them via 'depends'
+			continue
+		}
+
 		// Path to local file on host
 		src := filepath.Join(
 			pwd,
@@ -136,8 +175,65 @@ func (opts CorebootOpts) ProcessBlobs() ([]BlobDef, error) {
 	return blobs, nil
 }
 
-// buildFirmware builds coreboot with all blobs and stuff
-func (opts CorebootOpts) buildFirmware(ctx context.Context, client *dagger.Client) error {
+// sbomBlobs returns list of binary blobs built into the firmware
+func (opts CorebootOpts) sbomBlobs() []sbomBlob {
+	blobs := []sbomBlob{}
+
+	for _, key := range slices.Sorted(maps.Keys(opts.Blobs)) {
+		if key == "" || opts.Blobs[key] == "" || isDependencyPath(opts.Blobs[key]) {
+			// Artifacts of dependencies are described by their own SBOM
+			continue
+		}
+
+		blobs = append(blobs, sbomBlob{
+			Path:    opts.Blobs[key],
+			Comment: fmt.Sprintf("coreboot blob for '%s'", key),
+		})
+	}
+
+	return append(blobs, cbfstoolSbomBlobs(opts.CbfstoolEntries)...)
+}
+
+// prepareContainer spins up a container ready to build coreboot, returns it together with the build steps
+// In multi-board build, the container is prepared for the first board
+func (opts CorebootOpts) prepareContainer(ctx context.Context, client *dagger.Client) (*dagger.Container, [][]string, error) {
+	if opts.isMultiBoard() {
+		boards, err := opts.boards()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		slog.Info(fmt.Sprintf("Multi-board build, preparing container for the first board '%s'", boards[0].Name))
+
+		opts = opts.forBoard(boards[0])
+	}
+
+	myContainer, err := opts.setupBuildContainer(ctx, client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return opts.prepareBoard(ctx, client, myContainer)
+}
+
+// setupBuildContainer spins up a container with coreboot repository, shared by all boards
+func (opts CorebootOpts) setupBuildContainer(ctx context.Context, client *dagger.Client) (*dagger.Container, error) {
+	// Setup environment variables in the container
+	envVars, err := corebootPassEnvVars(opts.RepoPath)
+	if err != nil {
+		slog.Error(
+			"Failed to extract environment variables from current environment",
+			slog.Any("error", err),
+		)
+
+		return nil, fmt.Errorf("coreboot build failed: %w", err)
+	}
+
+	secrets, err := opts.GetSecrets()
+	if err != nil {
+		return nil, err
+	}
+
 	// Spin up container
 	containerOpts := container.SetupOpts{
 		ContainerURL:      opts.SdkURL,
@@ -147,18 +243,32 @@ func (opts CorebootOpts) buildFirmware(ctx context.Context, client *dagger.Clien
 		ContainerInputDir: opts.ContainerInputDir,
 		InputDirs:         opts.InputDirs,
 		InputFiles:        opts.InputFiles,
+		EnvVars:           opts.containerEnvVars(envVars),
+		Secrets:           secrets,
 	}
 
-	myContainer, err := container.Setup(ctx, client, &containerOpts)
+	myContainer, err := opts.setupModuleContainer(ctx, client, &containerOpts)
 	if err != nil {
 		slog.Error(
 			"Failed to start a container",
 			slog.Any("error", err),
 		)
 
-		return err
+		return nil, err
+	}
+
+	// Apply patches before anything else touches the repository
+	myContainer, err = opts.applyPatches(ctx, client, myContainer)
+	if err != nil {
+		return nil, err
 	}
 
+	return myContainer, nil
+}
+
+// prepareBoard copies the defconfig and blobs of the board into the container, returns it together
+// with the build steps
+func (opts CorebootOpts) prepareBoard(ctx context.Context, client *dagger.Client, myContainer *dagger.Container) (*dagger.Container, [][]string, error) {
 	// Copy over the defconfig file
 	defconfigBasename := filepath.Base(opts.DefconfigPath)
 	//   not sure why, but without the 'pwd' I am getting different results between CI and 'go test'
@@ -170,7 +280,7 @@ func (opts CorebootOpts) buildFirmware(ctx context.Context, client *dagger.Clien
 			slog.Any("error", err),
 		)
 
-		return err
+		return nil, nil, err
 	}
 
 	myContainer = myContainer.WithFile(
@@ -180,19 +290,23 @@ func (opts CorebootOpts) buildFirmware(ctx context.Context, client *dagger.Clien
 
 	// Get value of CONFIG_MAINBOARD_DIR / MAINBOARD_DIR variable from dotconfig
 	//   to extract value of 'CONFIG_MAINBOARD_DIR', there must be '.config'
-	generateDotConfigCmd := []string{"make", fmt.Sprintf("KBUILD_DEFCONFIG=%s", defconfigBasename), "defconfig"}
+	dotConfigContainer, err := opts.runBuildSteps(ctx, myContainer, [][]string{
+		opts.defconfigCmd(),
+		{"./util/scripts/config", "-s", "CONFIG_MAINBOARD_DIR"},
+	})
+
+	var mainboardDir string
+	if err == nil {
+		mainboardDir, err = dotConfigContainer.Stdout(ctx)
+	}
 
-	mainboardDir, err := myContainer.
-		WithExec(generateDotConfigCmd).
-		WithExec([]string{"./util/scripts/config", "-s", "CONFIG_MAINBOARD_DIR"}).
-		Stdout(ctx)
 	if err != nil {
 		slog.Error(
 			"Failed to get value of MAINBOARD_DIR from .config",
 			slog.Any("error", err),
 		)
 
-		return err
+		return nil, nil, err
 	}
 	//   strip newline from mainboardDir
 	mainboardDir = strings.ReplaceAll(mainboardDir, "\n", "")
@@ -203,9 +317,17 @@ func (opts CorebootOpts) buildFirmware(ctx context.Context, client *dagger.Clien
 		// -f: ignore nonexistent files
 		{"rm", "-f", ".config"},
 		// generate dotconfig from defconfig
-		generateDotConfigCmd,
+		opts.defconfigCmd(),
 	}
 
+	// Apply Kconfig fragments and overrides
+	fragmentSteps, err := opts.scriptKconfigSteps("./util/scripts/config")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buildSteps = append(buildSteps, fragmentSteps...)
+
 	// Handle blobs
 	// Firstly copy all the blobs into building container.
 	// Then use './util/scripts/config' script in coreboot repository to update configuration
@@ -217,10 +339,25 @@ func (opts CorebootOpts) buildFirmware(ctx context.Context, client *dagger.Clien
 			slog.Any("error", err),
 		)
 
-		return err
+		return nil, nil, err
 	}
 
 	for blob := range blobs {
+		if isDependencyPath(blobs[blob].Path) {
+			// Artifact of a dependency is already mounted into container, only point the config at it
+			dst, err := dependencyContainerPath(blobs[blob].Path, opts.Depends)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			buildSteps = append(
+				buildSteps,
+				[]string{"./util/scripts/config", "--set-str", blobs[blob].KconfigKey, dst},
+			)
+
+			continue
+		}
+
 		// Path to local file on host
 		src := filepath.Join(
 			pwd,
@@ -241,7 +378,7 @@ func (opts CorebootOpts) buildFirmware(ctx context.Context, client *dagger.Clien
 				slog.Any("error", err),
 			)
 
-			return err
+			return nil, nil, err
 		}
 
 		if errors.Is(err, filesystem.ErrPathIsDirectory) {
@@ -272,7 +409,7 @@ func (opts CorebootOpts) buildFirmware(ctx context.Context, client *dagger.Clien
 				slog.Any("error", err),
 			)
 
-			return err
+			return nil, nil, err
 		}
 
 		// Fix defconfig
@@ -283,6 +420,11 @@ func (opts CorebootOpts) buildFirmware(ctx context.Context, client *dagger.Clien
 		)
 	}
 
+	if len(fragmentSteps) > 0 {
+		// resolve dependencies of options changed by fragments and overrides
+		buildSteps = append(buildSteps, []string{"make", "olddefconfig"})
+	}
+
 	buildSteps = append(
 		buildSteps,
 		// compile
@@ -291,39 +433,72 @@ func (opts CorebootOpts) buildFirmware(ctx context.Context, client *dagger.Clien
 		[]string{"make", "savedefconfig"},
 	)
 
-	// Setup environment variables in the container
-	// envVars := map[string]string{}
-	envVars, err := corebootPassEnvVars(opts.RepoPath)
+	// Modify CBFS of the built image, with cbfstool built from the same tree
+	myContainer, cbfstoolSteps, err := withCbfstoolEntries(client, myContainer, pwd, corebootCbfstoolPath, "build/coreboot.rom", opts.CbfstoolEntries, opts.Depends)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buildSteps = append(buildSteps, cbfstoolSteps...)
+
+	return myContainer, buildSteps, nil
+}
+
+// defconfigCmd returns command which generates '.config' from 'defconfig_path' copied into the container
+func (opts CorebootOpts) defconfigCmd() []string {
+	return []string{"make", fmt.Sprintf("KBUILD_DEFCONFIG=%s", filepath.Base(opts.DefconfigPath)), "defconfig"}
+}
+
+// buildFirmware builds coreboot with all blobs and stuff
+func (opts CorebootOpts) buildFirmware(ctx context.Context, client *dagger.Client) error {
+	if opts.isMultiBoard() {
+		return opts.buildBoards(ctx, client)
+	}
+
+	myContainer, err := opts.setupBuildContainer(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	_, err = opts.buildBoard(ctx, client, myContainer)
+
+	return err
+}
+
+// buildBoard builds coreboot for 'defconfig_path' in given container and exports the artifacts,
+// returns the container after the build
+func (opts CorebootOpts) buildBoard(ctx context.Context, client *dagger.Client, myContainer *dagger.Container) (*dagger.Container, error) {
+	myContainer, buildSteps, err := opts.prepareBoard(ctx, client, myContainer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build
+	myContainer, err = opts.runBuildSteps(ctx, myContainer, buildSteps)
 	if err != nil {
 		slog.Error(
-			"Failed to extract environment variables from current environment",
+			"Failed to build coreboot",
 			slog.Any("error", err),
 		)
 
-		return fmt.Errorf("coreboot build failed: %w", err)
+		return nil, fmt.Errorf("coreboot build failed: %w", err)
 	}
 
-	for key, value := range envVars {
-		myContainer = myContainer.WithEnvVariable(key, value)
+	// Check that requested Kconfig options made it into '.config'
+	//   paths to blobs are changed by firmware-action on purpose
+	err = opts.checkKconfig(ctx, myContainer, opts.DefconfigPath, opts.OutputDir, slices.Collect(maps.Keys(opts.Blobs)))
+	if err != nil {
+		return nil, err
 	}
 
-	// Build
-	for step := range buildSteps {
-		myContainer, err = myContainer.
-			WithExec(buildSteps[step]).
-			Sync(ctx)
-		if err != nil {
-			slog.Error(
-				"Failed to build coreboot",
-				slog.Any("error", err),
-			)
-
-			return fmt.Errorf("coreboot build failed: %w", err)
-		}
+	// Check that the defconfig is not out of date
+	err = opts.checkDefconfig(ctx, myContainer, opts.DefconfigPath, [][]string{opts.defconfigCmd()}, opts.runBuildSteps)
+	if err != nil {
+		return nil, err
 	}
 
 	// Extract artifacts
-	return container.GetArtifacts(ctx, myContainer, opts.CommonOpts.GetArtifacts())
+	return myContainer, exportArtifacts(ctx, myContainer, opts.CommonOpts.GetArtifacts())
 }
 
 func corebootPassEnvVars(repoPath string) (map[string]string, error) {
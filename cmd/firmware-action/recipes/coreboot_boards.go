@@ -0,0 +1,213 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / coreboot_boards
+package recipes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+var (
+	// ErrNoBoards is raised when 'defconfigs' does not contain any defconfig
+	ErrNoBoards = errors.New("no defconfig found in 'defconfigs'")
+
+	// ErrDuplicateBoard is raised when two defconfigs in 'defconfigs' have the same filename
+	ErrDuplicateBoard = errors.New("defconfigs in 'defconfigs' must have unique filenames")
+
+	// ErrBoardsFailed is raised when one or more boards of multi-board build failed
+	ErrBoardsFailed = errors.New("one or more boards failed to build")
+)
+
+// corebootBoard is a single board of multi-board coreboot build
+type corebootBoard struct {
+	// Name of the board, the filename of its defconfig
+	Name string
+
+	// Path to the defconfig
+	DefconfigPath string
+}
+
+// isMultiBoard returns true if the module builds multiple boards, see 'defconfigs'
+func (opts CorebootOpts) isMultiBoard() bool {
+	return len(opts.Defconfigs) > 0
+}
+
+// boards returns all boards of multi-board build, directories in 'defconfigs' are expanded into
+// all files they contain (hidden files excluded, sorted by name)
+func (opts CorebootOpts) boards() ([]corebootBoard, error) {
+	boards := []corebootBoard{}
+	names := map[string]string{}
+
+	for _, path := range opts.Defconfigs {
+		defconfigs := []string{path}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			slog.Error(
+				fmt.Sprintf("Defconfig '%s' was not found", path),
+				slog.String("suggestion", "each item in 'defconfigs' must be either defconfig or directory with defconfigs"),
+				slog.Any("error", err),
+			)
+
+			return nil, err
+		}
+
+		if info.IsDir() {
+			entries, err := os.ReadDir(path)
+			if err != nil {
+				return nil, err
+			}
+
+			defconfigs = []string{}
+
+			for _, entry := range entries {
+				if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+					defconfigs = append(defconfigs, filepath.Join(path, entry.Name()))
+				}
+			}
+		}
+
+		for _, defconfig := range defconfigs {
+			name := filepath.Base(defconfig)
+			if previous, ok := names[name]; ok {
+				err := fmt.Errorf("%w: '%s' and '%s'", ErrDuplicateBoard, previous, defconfig)
+				slog.Error(
+					fmt.Sprintf("Defconfigs '%s' and '%s' have the same filename", previous, defconfig),
+					slog.String("suggestion", "artifacts of each board are stored in directory named after its defconfig, rename one of them"),
+					slog.Any("error", err),
+				)
+
+				return nil, err
+			}
+
+			names[name] = defconfig
+			boards = append(boards, corebootBoard{Name: name, DefconfigPath: defconfig})
+		}
+	}
+
+	if len(boards) == 0 {
+		slog.Error(
+			"Multi-board build has no boards to build",
+			slog.String("suggestion", "add defconfigs into directories listed in 'defconfigs'"),
+			slog.Any("error", ErrNoBoards),
+		)
+
+		return nil, ErrNoBoards
+	}
+
+	return boards, nil
+}
+
+// forBoard returns copy of opts which builds only the given board, into its own subdirectory
+// of the output directory
+func (opts CorebootOpts) forBoard(board corebootBoard) CorebootOpts {
+	opts.DefconfigPath = board.DefconfigPath
+	opts.Defconfigs = nil
+	opts.OutputDir = filepath.Join(opts.OutputDir, board.Name)
+
+	return opts
+}
+
+// outputDirs returns output directories of all boards in multi-board build, each board has its own
+// subdirectory of the output directory
+func (opts CorebootOpts) outputDirs() ([]string, error) {
+	if !opts.isMultiBoard() {
+		return opts.CommonOpts.outputDirs()
+	}
+
+	boards, err := opts.boards()
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := []string{}
+	for _, board := range boards {
+		dirs = append(dirs, opts.forBoard(board).OutputDir)
+	}
+
+	return dirs, nil
+}
+
+// buildBoards builds all boards one after another in the same container, with 'make distclean'
+// in between, so that the coreboot repository is copied into the container only once
+// Failure of a board does not stop the build of the remaining boards, but the module fails as a whole,
+// so artifacts of the boards which were built successfully are discarded together with the rest of
+// the output, the same as for any other failed build
+func (opts CorebootOpts) buildBoards(ctx context.Context, client *dagger.Client) error {
+	boards, err := opts.boards()
+	if err != nil {
+		return err
+	}
+
+	myContainer, err := opts.setupBuildContainer(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	details := getBuildDetails(ctx)
+	errs := []error{}
+
+	for index, board := range boards {
+		slog.Info(
+			fmt.Sprintf("Building board %d of %d: '%s'", index+1, len(boards), board.Name),
+			slog.String("defconfig", board.DefconfigPath),
+		)
+
+		start := time.Now()
+		boardOpts := opts.forBoard(board)
+
+		builtContainer, err := boardOpts.buildBoard(ctx, client, myContainer)
+		if err == nil && index < len(boards)-1 {
+			// Clean the repository for the next board, a failed board leaves the container as it was
+			builtContainer, err = boardOpts.runBuildSteps(ctx, builtContainer, [][]string{{"make", "distclean"}})
+			if err == nil {
+				myContainer = builtContainer
+			}
+		}
+
+		if err != nil && ctx.Err() != nil {
+			err = errors.Join(err, ErrBuildInterrupted)
+		}
+
+		details.Boards = append(details.Boards, BoardResult{
+			Name:        board.Name,
+			BuildResult: err,
+			Duration:    time.Since(start),
+		})
+
+		if err != nil {
+			slog.Error(
+				fmt.Sprintf("Failed to build board '%s'", board.Name),
+				slog.Any("error", err),
+			)
+
+			errs = append(errs, fmt.Errorf("board '%s': %w", board.Name, err))
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if len(errs) > 0 {
+		if built := len(details.Boards) - len(errs); built > 0 {
+			slog.Warn(
+				fmt.Sprintf("Artifacts of %d successfully built board(s) are discarded, because %d board(s) failed", built, len(errs)),
+				slog.String("suggestion", "fix or remove the failed boards from 'defconfigs', previous output directory is kept untouched"),
+			)
+		}
+
+		return errors.Join(append([]error{ErrBoardsFailed}, errs...)...)
+	}
+
+	return nil
+}
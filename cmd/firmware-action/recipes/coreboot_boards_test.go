@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / coreboot_boards
+package recipes
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorebootBoards(t *testing.T) {
+	tmpDir := t.TempDir()
+	boardsDir := filepath.Join(tmpDir, "boards")
+	otherDir := filepath.Join(tmpDir, "other")
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(boardsDir, "subdir"), 0o755))
+	assert.NoError(t, os.MkdirAll(otherDir, 0o755))
+
+	for _, file := range []string{
+		filepath.Join(boardsDir, "qemu_q35_defconfig"),
+		filepath.Join(boardsDir, "qemu_i440fx_defconfig"),
+		filepath.Join(boardsDir, ".hidden"),
+		filepath.Join(otherDir, "qemu_q35_defconfig"),
+		filepath.Join(tmpDir, "protectli_vp2410_defconfig"),
+	} {
+		assert.NoError(t, os.WriteFile(file, []byte("CONFIG_VENDOR_EMULATION=y\n"), 0o644))
+	}
+
+	testCases := []struct {
+		name       string
+		defconfigs []string
+		wantBoards []corebootBoard
+		wantErr    error
+	}{
+		{
+			name:       "directory and file",
+			defconfigs: []string{boardsDir, filepath.Join(tmpDir, "protectli_vp2410_defconfig")},
+			wantBoards: []corebootBoard{
+				{Name: "qemu_i440fx_defconfig", DefconfigPath: filepath.Join(boardsDir, "qemu_i440fx_defconfig")},
+				{Name: "qemu_q35_defconfig", DefconfigPath: filepath.Join(boardsDir, "qemu_q35_defconfig")},
+				{Name: "protectli_vp2410_defconfig", DefconfigPath: filepath.Join(tmpDir, "protectli_vp2410_defconfig")},
+			},
+		},
+		{
+			name:       "duplicate filename",
+			defconfigs: []string{boardsDir, otherDir},
+			wantErr:    ErrDuplicateBoard,
+		},
+		{
+			name:       "empty directory",
+			defconfigs: []string{filepath.Join(boardsDir, "subdir")},
+			wantErr:    ErrNoBoards,
+		},
+		{
+			name:       "missing defconfig",
+			defconfigs: []string{filepath.Join(tmpDir, "missing_defconfig")},
+			wantErr:    os.ErrNotExist,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := CorebootOpts{Defconfigs: tc.defconfigs}
+			assert.True(t, opts.isMultiBoard())
+
+			boards, err := opts.boards()
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.wantBoards, boards)
+		})
+	}
+}
+
+func TestCorebootForBoard(t *testing.T) {
+	opts := CorebootOpts{
+		CommonOpts: CommonOpts{OutputDir: "output-coreboot"},
+		Defconfigs: []string{"boards/"},
+	}
+
+	boardOpts := opts.forBoard(corebootBoard{Name: "qemu_q35_defconfig", DefconfigPath: "boards/qemu_q35_defconfig"})
+	assert.False(t, boardOpts.isMultiBoard())
+	assert.Equal(t, "boards/qemu_q35_defconfig", boardOpts.DefconfigPath)
+	assert.Equal(t, filepath.Join("output-coreboot", "qemu_q35_defconfig"), boardOpts.OutputDir)
+
+	// The original options are not modified
+	assert.Equal(t, "output-coreboot", opts.OutputDir)
+	assert.True(t, opts.isMultiBoard())
+}
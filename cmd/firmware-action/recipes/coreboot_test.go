@@ -201,17 +201,17 @@ func TestCorebootBuild(t *testing.T) {
 
 	common := CommonOpts{
 		OutputDir: "output",
-		ContainerOutputFiles: []string{
-			"build/coreboot.rom",
-			"defconfig",
+		ContainerOutputFiles: []ContainerOutputFile{
+			{From: "build/coreboot.rom"},
+			{From: "defconfig"},
 		},
 	}
 	// The universal module is used in this test to check version of compiled coreboot binary
 	optionsUniversal := UniversalOpts{
 		CommonOpts: CommonOpts{
 			OutputDir: "output-universal",
-			ContainerOutputFiles: []string{
-				"build_info.txt",
+			ContainerOutputFiles: []ContainerOutputFile{
+				{From: "build_info.txt"},
 			},
 			ContainerInputDir: "input",
 		},
@@ -476,17 +476,17 @@ func TestCorebootSubmodule(t *testing.T) {
 
 	common := CommonOpts{
 		OutputDir: "output",
-		ContainerOutputFiles: []string{
-			"build/coreboot.rom",
-			"defconfig",
+		ContainerOutputFiles: []ContainerOutputFile{
+			{From: "build/coreboot.rom"},
+			{From: "defconfig"},
 		},
 	}
 	// The universal module is used in this test to check version of compiled coreboot binary
 	optionsUniversal := UniversalOpts{
 		CommonOpts: CommonOpts{
 			OutputDir: "output-universal",
-			ContainerOutputFiles: []string{
-				"build_info.txt",
+			ContainerOutputFiles: []ContainerOutputFile{
+				{From: "build_info.txt"},
 			},
 			ContainerInputDir: "input",
 		},
@@ -0,0 +1,232 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / dependencies
+package recipes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"dagger.io/dagger"
+	"github.com/9elements/firmware-action/cmd/firmware-action/container"
+)
+
+// DependencyPrefix marks path to artifact of a module listed in 'depends'
+// Example: 'dep:edk2-example/UEFIPAYLOAD.fd' is file 'UEFIPAYLOAD.fd' in output directory of module 'edk2-example'
+const DependencyPrefix = "dep:"
+
+// DependenciesContainerDir is directory in container where output directories of dependencies are mounted
+const DependenciesContainerDir = "/deps"
+
+// ErrDependencyReference is raised when path references module which is not listed in 'depends'
+var ErrDependencyReference = errors.New("referenced module is not listed in 'depends'")
+
+var (
+	dependencyEnvVarPattern = regexp.MustCompile(`[^A-Z0-9]+`)
+
+	// Characters with special meaning in glob pattern, see filepath.Match
+	globMetaPattern = regexp.MustCompile(`[*?[]`)
+)
+
+// dependencyContainerDir returns path in container where output directory of the dependency is mounted
+func dependencyContainerDir(moduleID string) string {
+	return path.Join(DependenciesContainerDir, moduleID)
+}
+
+// dependencyEnvVar returns name of environment variable holding path to output directory of the dependency,
+// for example 'FA_DEP_EDK2_EXAMPLE_DIR' for module 'edk2-example'
+func dependencyEnvVar(moduleID string) string {
+	name := dependencyEnvVarPattern.ReplaceAllString(strings.ToUpper(moduleID), "_")
+
+	return fmt.Sprintf("FA_DEP_%s_DIR", strings.Trim(name, "_"))
+}
+
+// isDependencyPath returns true if path references artifact of a dependency
+func isDependencyPath(value string) bool {
+	return strings.HasPrefix(value, DependencyPrefix)
+}
+
+// dependencyContainerPath converts 'dep:<module-id>/<file>' into path in container
+// The module must be listed in 'depends'
+func dependencyContainerPath(value string, depends []string) (string, error) {
+	moduleID, file, _ := strings.Cut(strings.TrimPrefix(value, DependencyPrefix), "/")
+
+	if !slices.Contains(depends, moduleID) {
+		err := fmt.Errorf("%w: '%s'", ErrDependencyReference, value)
+		slog.Error(
+			fmt.Sprintf("Path '%s' references module '%s'", value, moduleID),
+			slog.String("suggestion", fmt.Sprintf("Add '%s' into 'depends', the path must look like '%s<module-id>/<file>'", moduleID, DependencyPrefix)),
+			slog.Any("error", err),
+		)
+
+		return "", err
+	}
+
+	return path.Join(dependencyContainerDir(moduleID), file), nil
+}
+
+type dependencyOutputsKey struct{}
+
+// withDependencyOutputs returns a copy of ctx which carries output directories of all dependencies of the target
+func withDependencyOutputs(ctx context.Context, target string, config *Config) context.Context {
+	modules := config.AllModules()
+	outputs := map[string]string{}
+
+	if module, ok := modules[target]; ok {
+		for _, dependency := range module.GetDepends() {
+			if dependencyModule, ok := modules[dependency]; ok {
+				outputs[dependency] = dependencyModule.GetOutputDir()
+			}
+		}
+	}
+
+	return context.WithValue(ctx, dependencyOutputsKey{}, outputs)
+}
+
+// getDependencyOutputs returns output directories of dependencies carried by ctx, indexed by module ID
+func getDependencyOutputs(ctx context.Context) map[string]string {
+	outputs, _ := ctx.Value(dependencyOutputsKey{}).(map[string]string)
+
+	return outputs
+}
+
+// setupModuleContainer sets up the container of the module, shared by all recipes:
+//   - the container itself, with repository and input files (see setupContainer)
+//   - output directories of dependencies, see mountDependencies
+//   - with network disabled, check that the container can run commands without network
+func (opts CommonOpts) setupModuleContainer(ctx context.Context, client *dagger.Client, containerOpts *container.SetupOpts) (*dagger.Container, error) {
+	myContainer, err := setupContainer(ctx, client, containerOpts)
+	if err != nil {
+		return myContainer, err
+	}
+
+	if opts.networkDisabled(ctx) {
+		if err := container.CheckNoNetworkTools(ctx, myContainer); err != nil {
+			return nil, err
+		}
+	}
+
+	return mountDependencies(ctx, client, myContainer)
+}
+
+// mountDependencies mounts output directories of dependencies read-only into the container, at
+// '/deps/<module-id>/', and exposes their paths in 'FA_DEP_<MODULE_ID>_DIR' environment variables
+func mountDependencies(ctx context.Context, client *dagger.Client, myContainer *dagger.Container) (*dagger.Container, error) {
+	pwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := getDependencyOutputs(ctx)
+
+	for _, moduleID := range slices.Sorted(maps.Keys(outputs)) {
+		hostDir := outputs[moduleID]
+		if !filepath.IsAbs(hostDir) {
+			hostDir = filepath.Join(pwd, hostDir)
+		}
+
+		if _, err := os.Stat(hostDir); err != nil {
+			slog.Warn(
+				fmt.Sprintf("Output directory of dependency '%s' does not exist, it will not be available in the container", moduleID),
+				slog.String("suggestion", "build needed modules or use '--recursive' build"),
+			)
+
+			continue
+		}
+
+		containerDir := dependencyContainerDir(moduleID)
+		myContainer = myContainer.
+			WithMountedDirectory(containerDir, client.Host().Directory(hostDir), dagger.ContainerWithMountedDirectoryOpts{ReadOnly: true}).
+			WithEnvVariable(dependencyEnvVar(moduleID), containerDir)
+	}
+
+	return myContainer, nil
+}
+
+// escapeGlob escapes characters with special meaning in glob pattern
+func escapeGlob(path string) string {
+	return globMetaPattern.ReplaceAllString(path, "[$0]")
+}
+
+// dependencyOutputPatterns returns glob patterns of all outputs of the module on host, each of them
+// must match at least one file or directory
+// Files matching glob pattern in 'container_output_files' are placed in the output directory
+// under their base names
+// Multi-board coreboot module exports the outputs of each board into its own directory
+func dependencyOutputPatterns(module FirmwareModule) ([]string, error) {
+	outputDirs, err := module.outputDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	patterns := []string{}
+
+	for _, outputDir := range outputDirs {
+		for _, path := range module.GetContainerOutputDirs() {
+			patterns = append(patterns, escapeGlob(filepath.Join(outputDir, filepath.Base(path))))
+		}
+
+		for _, file := range module.GetContainerOutputFiles() {
+			// Optional files might be legitimately missing
+			if file.Optional {
+				continue
+			}
+
+			if file.IsGlobPattern() {
+				patterns = append(patterns, filepath.Join(escapeGlob(file.HostPath(outputDir)), filepath.Base(file.From)))
+
+				continue
+			}
+
+			patterns = append(patterns, escapeGlob(file.HostPath(outputDir)))
+		}
+	}
+
+	return patterns, nil
+}
+
+// checkDependencyOutputs checks that outputs of all modules listed in 'depends' of the target exist
+func checkDependencyOutputs(modules map[string]FirmwareModule, target string) error {
+	for _, prerequisite := range modules[target].GetDepends() {
+		patterns, err := dependencyOutputPatterns(modules[prerequisite])
+		if err != nil {
+			return errors.Join(err, ErrDependencyOutputMissing)
+		}
+
+		for _, pattern := range patterns {
+			slog.Debug(
+				"Checking output of dependency",
+				slog.String("dependency", prerequisite),
+				slog.String("pattern", pattern),
+			)
+
+			matches, err := filepath.Glob(pattern)
+			if err == nil && len(matches) == 0 {
+				err = os.ErrNotExist
+			}
+
+			if err != nil {
+				slog.Error(
+					"Missing output files and/or directories from one or more required module(s) defined in 'Depends'",
+					slog.String("suggestion", "build needed modules or use '--recursive' build"),
+					slog.String("module", prerequisite),
+					slog.String("path", pattern),
+					slog.Any("error", errors.Join(err, ErrDependencyOutputMissing)),
+				)
+
+				return ErrDependencyOutputMissing
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / dependencies
+package recipes
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDependencyEnvVar(t *testing.T) {
+	assert.Equal(t, "FA_DEP_EDK2_EXAMPLE_DIR", dependencyEnvVar("edk2-example"))
+	assert.Equal(t, "FA_DEP_COREBOOT_QEMU_DIR", dependencyEnvVar("coreboot.qemu"))
+	assert.Equal(t, "FA_DEP_LINUX_DIR", dependencyEnvVar("-linux-"))
+}
+
+func TestDependencyContainerPath(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    string
+		depends  []string
+		wantPath string
+		wantErr  error
+	}{
+		{
+			name:     "file in output of dependency",
+			value:    "dep:edk2-example/UEFIPAYLOAD.fd",
+			depends:  []string{"edk2-example"},
+			wantPath: "/deps/edk2-example/UEFIPAYLOAD.fd",
+		},
+		{
+			name:     "nested file",
+			value:    "dep:linux-example/boot/bzImage",
+			depends:  []string{"u-root", "linux-example"},
+			wantPath: "/deps/linux-example/boot/bzImage",
+		},
+		{
+			name:    "module not in depends",
+			value:   "dep:edk2-example/UEFIPAYLOAD.fd",
+			depends: []string{"linux-example"},
+			wantErr: ErrDependencyReference,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.True(t, isDependencyPath(tc.value))
+
+			path, err := dependencyContainerPath(tc.value, tc.depends)
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.wantPath, path)
+		})
+	}
+}
+
+func TestWithDependencyOutputs(t *testing.T) {
+	config := Config{
+		Edk2: map[string]Edk2Opts{
+			"edk2-example": {CommonOpts: CommonOpts{OutputDir: "output-edk2"}},
+		},
+		Coreboot: map[string]CorebootOpts{
+			"coreboot-example": {
+				CommonOpts: CommonOpts{OutputDir: "output-coreboot"},
+				Depends:    []string{"edk2-example"},
+			},
+		},
+	}
+
+	ctx := withDependencyOutputs(context.Background(), "coreboot-example", &config)
+	assert.Equal(t, map[string]string{"edk2-example": "output-edk2"}, getDependencyOutputs(ctx))
+
+	ctx = withDependencyOutputs(context.Background(), "edk2-example", &config)
+	assert.Empty(t, getDependencyOutputs(ctx))
+
+	assert.Nil(t, getDependencyOutputs(context.Background()))
+}
+
+func TestCheckDependencyOutputs(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	modules := map[string]FirmwareModule{
+		"edk2-example": Edk2Opts{
+			CommonOpts: CommonOpts{
+				OutputDir:           "output-[edk2]",
+				ContainerOutputDirs: []string{"Build/"},
+				ContainerOutputFiles: []ContainerOutputFile{
+					{From: "Build/*/FV/*.fd"},
+					{From: "Build/report.txt", Optional: true},
+				},
+			},
+		},
+		"coreboot-example": CorebootOpts{
+			Depends: []string{"edk2-example"},
+		},
+	}
+
+	// Nothing was built yet
+	assert.ErrorIs(t, checkDependencyOutputs(modules, "coreboot-example"), ErrDependencyOutputMissing)
+
+	// Glob pattern did not match anything, the output directory alone is not enough
+	assert.NoError(t, os.MkdirAll(filepath.Join("output-[edk2]", "Build"), 0o755))
+	assert.ErrorIs(t, checkDependencyOutputs(modules, "coreboot-example"), ErrDependencyOutputMissing)
+
+	// Optional file can be missing
+	assert.NoError(t, os.WriteFile(filepath.Join("output-[edk2]", "OVMF.fd"), []byte{}, 0o644))
+	assert.NoError(t, checkDependencyOutputs(modules, "coreboot-example"))
+}
+
+func TestCheckDependencyOutputsMultiBoard(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	assert.NoError(t, os.MkdirAll("boards", 0o755))
+	for _, board := range []string{"board-a_defconfig", "board-b_defconfig"} {
+		assert.NoError(t, os.WriteFile(filepath.Join("boards", board), []byte{}, 0o644))
+	}
+
+	modules := map[string]FirmwareModule{
+		"coreboot-example": CorebootOpts{
+			Defconfigs: []string{"boards/"},
+			CommonOpts: CommonOpts{
+				OutputDir:            "output-coreboot",
+				ContainerOutputFiles: []ContainerOutputFile{{From: "build/coreboot.rom"}},
+			},
+		},
+		"stitching-example": FirmwareStitchingOpts{
+			Depends: []string{"coreboot-example"},
+		},
+	}
+
+	// Outputs are exported per board, not directly into the output directory
+	assert.NoError(t, os.MkdirAll("output-coreboot", 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join("output-coreboot", "coreboot.rom"), []byte{}, 0o644))
+	assert.ErrorIs(t, checkDependencyOutputs(modules, "stitching-example"), ErrDependencyOutputMissing)
+
+	// All boards must be built
+	assert.NoError(t, os.MkdirAll(filepath.Join("output-coreboot", "board-a_defconfig"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join("output-coreboot", "board-a_defconfig", "coreboot.rom"), []byte{}, 0o644))
+	assert.ErrorIs(t, checkDependencyOutputs(modules, "stitching-example"), ErrDependencyOutputMissing)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join("output-coreboot", "board-b_defconfig"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join("output-coreboot", "board-b_defconfig", "coreboot.rom"), []byte{}, 0o644))
+	assert.NoError(t, checkDependencyOutputs(modules, "stitching-example"))
+}
@@ -4,9 +4,13 @@
 package recipes
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
 	"strings"
@@ -77,6 +81,46 @@ type ChangeConfig struct {
 	Change
 
 	Config *Config
+
+	// Environment variables passed from the host with 'env_passthrough', as resolved for this build
+	//   they are not part of the configuration, so they are stored next to it (only hashes of values)
+	EnvPassthrough map[string]string
+}
+
+// envPassthroughFile returns path to the file with passed-through environment variables,
+// next to the configuration checkpoint
+func (c *ChangeConfig) envPassthroughFile() string {
+	return strings.TrimSuffix(c.ResultFile, filepath.Ext(c.ResultFile)) + ".env_passthrough.json"
+}
+
+// envPassthroughHashes returns names of passed-through environment variables with SHA-256 of their
+// values, values might be sensitive and are never stored in plain text
+func (c *ChangeConfig) envPassthroughHashes() map[string]string {
+	hashes := map[string]string{}
+
+	for name, value := range c.EnvPassthrough {
+		hash := sha256.Sum256([]byte(value))
+		hashes[name] = hex.EncodeToString(hash[:])
+	}
+
+	return hashes
+}
+
+// envPassthroughChanged returns whether passed-through environment variables differ from the ones
+// used for previous build, missing file is the same as no variables
+func (c *ChangeConfig) envPassthroughChanged() bool {
+	oldHashes := map[string]string{}
+
+	content, err := os.ReadFile(c.envPassthroughFile())
+	if err == nil {
+		err = json.Unmarshal(content, &oldHashes)
+	}
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return true
+	}
+
+	return !maps.Equal(oldHashes, c.envPassthroughHashes())
 }
 
 // DetectChanges is a method for detecting changes based on Configuration file
@@ -115,7 +159,7 @@ func (c *ChangeConfig) DetectChanges(target string) bool {
 
 		oldModules := oldConfig.AllModules()
 		modules := c.Config.AllModules()
-		c.ChangesDetected = !cmp.Equal(modules[target], oldModules[target])
+		c.ChangesDetected = !cmp.Equal(modules[target], oldModules[target]) || c.envPassthroughChanged()
 
 		return c.ChangesDetected
 	}
@@ -150,6 +194,18 @@ func (c *ChangeConfig) SaveCheckpoint(override bool) {
 				slog.Any("error", err),
 			)
 		}
+
+		content, err := json.MarshalIndent(c.envPassthroughHashes(), "", "  ")
+		if err == nil {
+			err = os.WriteFile(c.envPassthroughFile(), content, 0o666)
+		}
+
+		if err != nil {
+			slog.Warn(
+				"Failed to create a snapshot of passed-through environment variables for detecting future changes",
+				slog.Any("error", err),
+			)
+		}
 	}
 }
 
@@ -260,6 +316,26 @@ func (c *AllChanges) DetectChanges(target string) bool {
 	return result
 }
 
+// Reasons returns list of change detection methods which detected changes
+// Must be called after DetectChanges
+func (c *AllChanges) Reasons() []string {
+	reasons := []string{}
+
+	if c.TimeStamp.ChangesDetected {
+		reasons = append(reasons, "source files changed (time-stamp)")
+	}
+
+	if c.Configuration.ChangesDetected {
+		reasons = append(reasons, "configuration changed")
+	}
+
+	if c.GitHash.ChangesDetected {
+		reasons = append(reasons, "git commit changed")
+	}
+
+	return reasons
+}
+
 // SaveCheckpoint is a method for saving checkpoint files for future change detection
 func (c *AllChanges) SaveCheckpoint(target string, override bool) {
 	slog.Debug("Saving change detection checkpoints")
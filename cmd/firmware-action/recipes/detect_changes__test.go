@@ -91,8 +91,8 @@ func TestChangeConfig(t *testing.T) {
 					RepoPath:          repoPath,
 					OutputDir:         outputDir,
 					ContainerInputDir: "inputs/",
-					ContainerOutputFiles: []string{
-						"file.rom",
+					ContainerOutputFiles: []ContainerOutputFile{
+						{From: "file.rom"},
 					},
 				},
 				UniversalSpecific: UniversalSpecific{
@@ -105,8 +105,8 @@ func TestChangeConfig(t *testing.T) {
 					RepoPath:          repoPath,
 					OutputDir:         outputDir2,
 					ContainerInputDir: "inputs/",
-					ContainerOutputFiles: []string{
-						"file.rom",
+					ContainerOutputFiles: []ContainerOutputFile{
+						{From: "file.rom"},
 					},
 				},
 				UniversalSpecific: UniversalSpecific{
@@ -137,6 +137,63 @@ func TestChangeConfig(t *testing.T) {
 	opts.BuildCommands = []string{}
 	myChangeConfig.Config.Universal[target] = opts
 	assert.True(t, myChangeConfig.DetectChanges(target))
+	myChangeConfig.SaveCheckpoint(true)
+	assert.False(t, myChangeConfig.DetectChanges(target))
+
+	// change value of passed-through environment variable
+	myChangeConfig.EnvPassthrough = map[string]string{"BUILD_ID": "42"}
+	assert.True(t, myChangeConfig.DetectChanges(target))
+	myChangeConfig.SaveCheckpoint(true)
+	assert.False(t, myChangeConfig.DetectChanges(target))
+
+	content, err := os.ReadFile(myChangeConfig.envPassthroughFile())
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "\"42\"", "values must not be stored in plain text")
+
+	myChangeConfig.EnvPassthrough = map[string]string{"BUILD_ID": "43"}
+	assert.True(t, myChangeConfig.DetectChanges(target))
+
+	// variable is no longer defined on the host
+	myChangeConfig.EnvPassthrough = map[string]string{}
+	assert.True(t, myChangeConfig.DetectChanges(target))
+}
+
+func TestChangeConfigKconfig(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	const target = "coreboot"
+
+	CompiledConfigsDir = filepath.Join(tmpDir, ".firmware-action", "configs")
+
+	config := Config{
+		Coreboot: map[string]CorebootOpts{
+			target: {
+				CommonOpts: CommonOpts{
+					SdkURL:            "whatever",
+					RepoPath:          tmpDir,
+					OutputDir:         "output-coreboot/",
+					ContainerInputDir: "inputs/",
+				},
+				DefconfigPath: "defconfig",
+				KconfigOpts: KconfigOpts{
+					Kconfig: map[string]string{"CONFIG_CONSOLE_SERIAL": "y"},
+				},
+			},
+		},
+	}
+
+	myChangeConfig := ChangeConfig{
+		Change: Change{
+			ResultFile: filepath.Join(CompiledConfigsDir, filesystem.Filenamify(target, "json")),
+		},
+		Config: &config,
+	}
+	myChangeConfig.SaveCheckpoint(false)
+	assert.False(t, myChangeConfig.DetectChanges(target))
+
+	// Flip a single Kconfig option
+	config.Coreboot[target].Kconfig["CONFIG_CONSOLE_SERIAL"] = "n"
+	assert.True(t, myChangeConfig.DetectChanges(target))
 }
 
 func gitRepoPrepare(t *testing.T, tmpDir string) {
@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / diff
+package recipes
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is number of unchanged lines shown around each change in unified diff
+const diffContext = 3
+
+// diffLine is single line of diff, kind is one of ' ', '-' or '+'
+type diffLine struct {
+	kind byte
+	text string
+}
+
+// diffLines returns line-by-line difference between a and b, based on longest common subsequence
+// Intended for small files like defconfig, time and memory are O(len(a) * len(b))
+func diffLines(a []string, b []string) []diffLine {
+	// lcs[i][j] is length of longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	lines := []diffLine{}
+	i, j := 0, 0
+
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{kind: ' ', text: a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			// Prefer deletions before insertions, the same way as 'diff -u'
+			lines = append(lines, diffLine{kind: '-', text: a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{kind: '+', text: b[j]})
+			j++
+		}
+	}
+
+	return lines
+}
+
+// splitLines splits text into lines, trailing newline does not produce an empty line
+func splitLines(text string) []string {
+	if text == "" {
+		return []string{}
+	}
+
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// unifiedDiff returns difference between two texts in unified diff format, empty string if they are equal
+func unifiedDiff(fromName string, toName string, from string, to string) string {
+	lines := diffLines(splitLines(from), splitLines(to))
+
+	// Find ranges of lines to print, changes closer than 2*diffContext lines are merged into one hunk
+	type hunk struct{ start, end int }
+
+	hunks := []hunk{}
+
+	for index, line := range lines {
+		if line.kind == ' ' {
+			continue
+		}
+
+		start := max(index-diffContext, 0)
+		end := min(index+diffContext+1, len(lines))
+
+		if len(hunks) > 0 && start <= hunks[len(hunks)-1].end {
+			hunks[len(hunks)-1].end = end
+		} else {
+			hunks = append(hunks, hunk{start: start, end: end})
+		}
+	}
+
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var diff strings.Builder
+
+	fmt.Fprintf(&diff, "--- %s\n+++ %s\n", fromName, toName)
+
+	// Line numbers (1-based) in 'from' and 'to' at the start of current position
+	fromLine, toLine, position := 1, 1, 0
+
+	for _, h := range hunks {
+		// Skip unchanged lines before the hunk
+		for ; position < h.start; position++ {
+			fromLine++
+			toLine++
+		}
+
+		fromCount, toCount := 0, 0
+
+		for _, line := range lines[h.start:h.end] {
+			if line.kind != '+' {
+				fromCount++
+			}
+
+			if line.kind != '-' {
+				toCount++
+			}
+		}
+
+		fmt.Fprintf(&diff, "@@ -%s +%s @@\n", hunkRange(fromLine, fromCount), hunkRange(toLine, toCount))
+
+		for _, line := range lines[h.start:h.end] {
+			fmt.Fprintf(&diff, "%c%s\n", line.kind, line.text)
+
+			if line.kind != '+' {
+				fromLine++
+			}
+
+			if line.kind != '-' {
+				toLine++
+			}
+		}
+
+		position = h.end
+	}
+
+	return diff.String()
+}
+
+// hunkRange formats range of lines in hunk header
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		// Empty range points to the line before
+		return fmt.Sprintf("%d,0", start-1)
+	}
+
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+
+	return fmt.Sprintf("%d,%d", start, count)
+}
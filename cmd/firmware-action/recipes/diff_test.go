@@ -0,0 +1,37 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / diff
+package recipes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	from := "CONFIG_A=y\nCONFIG_B=y\nCONFIG_C=y\nCONFIG_D=y\nCONFIG_E=y\nCONFIG_F=y\nCONFIG_G=y\nCONFIG_H=y\nCONFIG_I=y\nCONFIG_J=y\n"
+	to := "CONFIG_A=y\nCONFIG_C=y\nCONFIG_D=y\nCONFIG_E=y\nCONFIG_F=y\nCONFIG_G=y\nCONFIG_H=y\nCONFIG_I=y\nCONFIG_J=n\nCONFIG_K=y\n"
+
+	// Same output as 'diff -u'
+	assert.Equal(t, `--- a
++++ b
+@@ -1,5 +1,4 @@
+ CONFIG_A=y
+-CONFIG_B=y
+ CONFIG_C=y
+ CONFIG_D=y
+ CONFIG_E=y
+@@ -7,4 +6,5 @@
+ CONFIG_G=y
+ CONFIG_H=y
+ CONFIG_I=y
+-CONFIG_J=y
++CONFIG_J=n
++CONFIG_K=y
+`, unifiedDiff("a", "b", from, to))
+
+	assert.Empty(t, unifiedDiff("a", "b", from, from))
+
+	assert.Equal(t, "--- a\n+++ b\n@@ -0,0 +1 @@\n+CONFIG_A=y\n", unifiedDiff("a", "b", "", "CONFIG_A=y\n"))
+}
@@ -8,8 +8,12 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
+	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
 
 	"dagger.io/dagger"
 	"github.com/9elements/firmware-action/cmd/firmware-action/container"
@@ -23,11 +27,53 @@ import (
 type Edk2Specific struct {
 	// Specifies which build command to use
 	// GCC version is exposed in the container as USE_GCC_VERSION environment variable
+	// Either 'build_command' or 'platform' must be set
 	// Examples:
 	//   "source ./edksetup.sh; build -t GCC5 -a IA32 -p UefiPayloadPkg/UefiPayloadPkg.dsc"
 	//   "python UefiPayloadPkg/UniversalPayloadBuild.py"
 	//   "Intel/AlderLakeFspPkg/BuildFv.sh"
-	BuildCommand string `json:"build_command" validate:"required"`
+	BuildCommand string `json:"build_command" validate:"required_without=Platform,excluded_with=Platform"`
+
+	// Structured alternative to 'build_command', firmware-action assembles the
+	//   'source ./edksetup.sh; build ...' invocation itself, architectures are taken from 'arch'
+	// Gives the (relative) path to the platform description file (DSC), '-p'
+	// Example:
+	//   "UefiPayloadPkg/UefiPayloadPkg.dsc"
+	Platform string `json:"platform" validate:"omitempty,filepath"`
+
+	// Toolchain tag, '-t', required with 'platform'
+	// Examples:
+	//   - GCC5
+	//   - CLANGDWARF
+	Toolchain string `json:"toolchain" validate:"required_with=Platform"`
+
+	// Build target, '-b', defaults to 'DEBUG'
+	BuildTarget string `json:"build_target" validate:"omitempty,oneof=DEBUG RELEASE NOOPT"`
+
+	// Macro definitions, '-D NAME=VALUE'
+	// Example:
+	//   {"BOOTLOADER": "COREBOOT", "TPM_ENABLE": "TRUE"}
+	Defines map[string]string `json:"defines" validate:"dive,keys,required,excludesall= =,endkeys"`
+
+	// Gives the (relative) path to the build report, '-y'
+	// The report is exported into 'output_dir' together with the flash device images
+	// With 'build_command' it is only exported, the command itself must create the report
+	ReportFile string `json:"report_file" validate:"omitempty,filepath"`
+
+	// Types of information in the build report, '-Y'
+	// For supported options see 'build --help', for example 'PCD', 'FLASH' or 'DEPEX'
+	ReportTypes []string `json:"report_types" validate:"dive,oneof=PCD LIBRARY FLASH DEPEX BUILD_FLAGS FIXED_ADDRESS HASH EXECUTION_ORDER COMPILE_INFO"`
+
+	// Additional (relative) paths to directories with packages, exposed as PACKAGES_PATH
+	// Example:
+	//   ["edk2-platforms/Platform/Intel", "edk2-non-osi/Silicon/Intel"]
+	PackagesPaths []string `json:"packages_paths" validate:"dive,filepath|dirpath"`
+
+	// Export flash device images, firmware volumes, EFI images and map files from the build directory
+	//   into stable layout inside 'output_dir', works with both 'platform' and 'build_command'
+	// The build directory is '<OUTPUT_DIRECTORY>/<build_target>_<toolchain>/', with 'build_command'
+	//   it is searched for in 'Build/'
+	AutoArtifacts bool `json:"auto_artifacts"`
 }
 
 // ANCHOR_END: Edk2Specific
@@ -58,7 +104,8 @@ type Edk2Opts struct {
 	// Gives the (relative) path to the defconfig that should be used to build the target.
 	// For EDK2 this is a one-line file containing the build arguments such as
 	//   '-D BOOTLOADER=COREBOOT -D TPM_ENABLE=TRUE -D NETWORK_IPXE=TRUE'.
-	DefconfigPath string `json:"defconfig_path" validate:"filepath"`
+	// Appended to 'build_command', use 'defines' together with 'platform' instead.
+	DefconfigPath string `json:"defconfig_path" validate:"excluded_with=Platform,omitempty,filepath"`
 }
 
 // ANCHOR_END: Edk2Opts
@@ -73,6 +120,13 @@ func (opts Edk2Opts) GetArtifacts() *[]container.Artifacts {
 	return opts.CommonOpts.GetArtifacts()
 }
 
+// withCommonOpts returns a copy of the module with modified common options
+func (opts Edk2Opts) withCommonOpts(modify func(opts *CommonOpts)) FirmwareModule {
+	modify(&opts.CommonOpts)
+
+	return opts
+}
+
 // GetSources returns slice of paths to all sources which are used for build
 func (opts Edk2Opts) GetSources() []string {
 	sources := opts.CommonOpts.GetSources()
@@ -83,13 +137,164 @@ func (opts Edk2Opts) GetSources() []string {
 	return sources
 }
 
-// buildFirmware builds edk2 or Intel FSP
-func (opts Edk2Opts) buildFirmware(ctx context.Context, client *dagger.Client) error {
+// buildTarget returns build target, 'DEBUG' if not set
+func (opts Edk2Opts) buildTarget() string {
+	if opts.BuildTarget == "" {
+		return "DEBUG"
+	}
+
+	return opts.BuildTarget
+}
+
+// edk2Archs translates 'arch' into list of architectures for '-a'
+func edk2Archs(arch string) []string {
+	switch arch {
+	case "":
+		// Use the default from 'Conf/target.txt'
+		return []string{}
+	case "IA32X64":
+		return []string{"IA32", "X64"}
+	default:
+		return []string{arch}
+	}
+}
+
+// buildArgs returns arguments of edk2 'build' command assembled from the structured options
+func (opts Edk2Opts) buildArgs() []string {
+	args := []string{
+		"-p", opts.Platform,
+		"-t", opts.Toolchain,
+		"-b", opts.buildTarget(),
+		"-n", fmt.Sprintf("%d", runtime.NumCPU()),
+	}
+
+	for _, arch := range edk2Archs(opts.Arch) {
+		args = append(args, "-a", arch)
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(opts.Defines)) {
+		args = append(args, "-D", fmt.Sprintf("%s=%s", name, opts.Defines[name]))
+	}
+
+	if opts.ReportFile != "" {
+		args = append(args, "-y", opts.ReportFile)
+	}
+
+	for _, reportType := range opts.ReportTypes {
+		args = append(args, "-Y", reportType)
+	}
+
+	return args
+}
+
+// buildCmd returns build step which sets up edk2 environment and runs 'build'
+// Arguments are passed as positional parameters of the shell, so they do not need any quoting
+func (opts Edk2Opts) buildCmd() []string {
+	return append([]string{"bash", "-c", `source ./edksetup.sh && build "$@"`, "build"}, opts.buildArgs()...)
+}
+
+// fdArtifacts returns flash device images (FD) and firmware volumes (FV) produced by structured
+// build and the build report, if any
+// Both images are optional, packages without '[FD]' section (such as MdeModulePkg) produce none,
+// the build fails only if it did not produce the build directory at all
+func (opts Edk2Opts) fdArtifacts(ctx context.Context, myContainer *dagger.Container) ([]container.Artifacts, error) {
+	buildDir := filepath.Join("Build", "*")
+
+	outputDirectory, err := opts.edk2OutputDirectory(containerRepoFileReader(ctx, myContainer))
+	if err != nil {
+		slog.Warn(
+			fmt.Sprintf("Failed to find output directory of platform '%s', searching whole 'Build' directory instead", opts.Platform),
+			slog.Any("error", err),
+		)
+	} else {
+		buildDir = outputDirectory
+	}
+
+	buildDir = filepath.Join(buildDir, fmt.Sprintf("%s_%s", opts.buildTarget(), opts.Toolchain))
+	workDir := myContainer.Directory(ContainerWorkDir)
+
+	matches, err := workDir.Glob(ctx, buildDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matches) == 0 {
+		err = fmt.Errorf("%w: '%s' does not exist", ErrEdk2BuildDir, buildDir)
+		slog.Error(
+			"edk2 build did not produce anything",
+			slog.String("suggestion", "check the log of the build, and that 'build_target' and 'toolchain' match the build"),
+			slog.Any("error", err),
+		)
+
+		return nil, err
+	}
+
+	imagePatterns := []string{
+		filepath.Join(buildDir, "FV", "*.fd"),
+		filepath.Join(buildDir, "FV", "*.Fv"),
+	}
+
+	artifacts := []container.Artifacts{}
+	images := 0
+
+	for _, pattern := range imagePatterns {
+		matches, err := workDir.Glob(ctx, pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		images += len(matches)
+		artifacts = append(artifacts, container.Artifacts{
+			ContainerPath: filepath.Join(ContainerWorkDir, pattern),
+			HostPath:      opts.OutputDir,
+			HostDir:       true,
+			Optional:      true,
+		})
+	}
+
+	if images == 0 {
+		slog.Warn(
+			fmt.Sprintf("edk2 build of platform '%s' did not produce any flash device image nor firmware volume", opts.Platform),
+			slog.String("suggestion", "this is expected for packages without '[FD]' section, list other artifacts in 'container_output_dirs' or 'container_output_files'"),
+		)
+	}
+
+	if opts.ReportFile != "" {
+		artifacts = append(artifacts, opts.reportArtifact())
+	}
+
+	return artifacts, nil
+}
+
+// reportArtifact returns the build report, exported directly into output directory
+func (opts Edk2Opts) reportArtifact() container.Artifacts {
+	return container.Artifacts{
+		ContainerPath: filepath.Join(ContainerWorkDir, opts.ReportFile),
+		HostPath:      filepath.Join(opts.OutputDir, filepath.Base(opts.ReportFile)),
+	}
+}
+
+// prepareContainer spins up a container ready to build edk2, returns it together with the build steps
+func (opts Edk2Opts) prepareContainer(ctx context.Context, client *dagger.Client) (*dagger.Container, [][]string, error) {
 	envVars := map[string]string{
 		"WORKSPACE":      ContainerWorkDir,
 		"EDK_TOOLS_PATH": "/tools/Edk2/BaseTools",
 	}
 
+	if len(opts.PackagesPaths) > 0 {
+		packagesPaths := []string{}
+		for _, packagesPath := range opts.PackagesPaths {
+			packagesPaths = append(packagesPaths, filepath.Join(ContainerWorkDir, packagesPath))
+		}
+
+		envVars["PACKAGES_PATH"] = strings.Join(packagesPaths, ":")
+	}
+
+	secrets, err := opts.GetSecrets()
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Spin up container
 	containerOpts := container.SetupOpts{
 		ContainerURL:      opts.SdkURL,
@@ -99,21 +304,24 @@ func (opts Edk2Opts) buildFirmware(ctx context.Context, client *dagger.Client) e
 		ContainerInputDir: opts.ContainerInputDir,
 		InputDirs:         opts.InputDirs,
 		InputFiles:        opts.InputFiles,
+		EnvVars:           opts.containerEnvVars(envVars),
+		Secrets:           secrets,
 	}
 
-	myContainer, err := container.Setup(ctx, client, &containerOpts)
+	myContainer, err := opts.setupModuleContainer(ctx, client, &containerOpts)
 	if err != nil {
 		slog.Error(
 			"Failed to start a container",
 			slog.Any("error", err),
 		)
 
-		return err
+		return nil, nil, err
 	}
 
-	// Setup environment variables in the container
-	for key, value := range envVars {
-		myContainer = myContainer.WithEnvVariable(key, value)
+	// Apply patches before anything else touches the repository
+	myContainer, err = opts.applyPatches(ctx, client, myContainer)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Assemble build arguments
@@ -124,7 +332,7 @@ func (opts Edk2Opts) buildFirmware(ctx context.Context, client *dagger.Client) e
 		if _, err := os.Stat(opts.DefconfigPath); !errors.Is(err, os.ErrNotExist) {
 			defconfigFileArgs, err = os.ReadFile(opts.DefconfigPath)
 			if err != nil {
-				return err
+				return nil, nil, err
 			}
 		} else {
 			slog.Warn(
@@ -143,23 +351,54 @@ func (opts Edk2Opts) buildFirmware(ctx context.Context, client *dagger.Client) e
 		buildSteps = append(buildSteps, []string{"bash", "-c", "cd ${TOOLSDIR}/Edk2/; make -C BaseTools/ -j $(nproc)"})
 	}
 
-	buildSteps = append(buildSteps, []string{"bash", "-c", fmt.Sprintf("%s %s", opts.BuildCommand, string(defconfigFileArgs))})
+	if opts.Platform != "" {
+		buildSteps = append(buildSteps, opts.buildCmd())
+	} else {
+		buildSteps = append(buildSteps, []string{"bash", "-c", fmt.Sprintf("%s %s", opts.BuildCommand, string(defconfigFileArgs))})
+	}
+
+	return myContainer, buildSteps, nil
+}
+
+// buildFirmware builds edk2 or Intel FSP
+func (opts Edk2Opts) buildFirmware(ctx context.Context, client *dagger.Client) error {
+	myContainer, buildSteps, err := opts.prepareContainer(ctx, client)
+	if err != nil {
+		return err
+	}
 
 	// Build
-	for step := range buildSteps {
-		myContainer, err = myContainer.
-			WithExec(buildSteps[step]).
-			Sync(ctx)
+	myContainer, err = opts.runBuildSteps(ctx, myContainer, buildSteps)
+	if err != nil {
+		slog.Error(
+			"Failed to build edk2",
+			slog.Any("error", err),
+		)
+
+		return fmt.Errorf("edk2 build failed: %w", err)
+	}
+
+	// Extract artifacts
+	artifacts := opts.GetArtifacts()
+
+	switch {
+	case opts.AutoArtifacts:
+		autoArtifacts, err := opts.autoArtifacts(ctx, myContainer)
 		if err != nil {
-			slog.Error(
-				"Failed to build edk2",
-				slog.Any("error", err),
-			)
+			return err
+		}
 
-			return fmt.Errorf("edk2 build failed: %w", err)
+		*artifacts = append(*artifacts, autoArtifacts...)
+	case opts.Platform != "":
+		fdArtifacts, err := opts.fdArtifacts(ctx, myContainer)
+		if err != nil {
+			return err
 		}
+
+		*artifacts = append(*artifacts, fdArtifacts...)
+	case opts.ReportFile != "":
+		*artifacts = append(*artifacts, opts.reportArtifact())
 	}
 
-	// Extract artifacts
-	return container.GetArtifacts(ctx, myContainer, opts.GetArtifacts())
+	return exportArtifacts(ctx, myContainer, artifacts)
 }
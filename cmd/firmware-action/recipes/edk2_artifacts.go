@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / edk2_artifacts
+package recipes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"dagger.io/dagger"
+	"github.com/9elements/firmware-action/cmd/firmware-action/container"
+)
+
+// ErrEdk2BuildDir is raised when build directory of edk2 can't be found for 'auto_artifacts'
+var ErrEdk2BuildDir = errors.New("failed to find edk2 build directory")
+
+// edk2ArchDirs lists names of architecture directories in edk2 build directory
+var edk2ArchDirs = []string{"IA32", "X64", "ARM", "AARCH64", "RISCV64", "LOONGARCH64", "EBC"}
+
+// selectEdk2BuildDir picks the build directory out of directories matched in the container,
+// exactly one must be found
+func selectEdk2BuildDir(matches []string) (string, error) {
+	dirs := []string{}
+
+	for _, match := range matches {
+		// Matches are 'FV' directories, the build directory is their parent
+		dirs = append(dirs, filepath.Dir(strings.TrimSuffix(match, "/")))
+	}
+
+	slices.Sort(dirs)
+	dirs = slices.Compact(dirs)
+
+	switch len(dirs) {
+	case 0:
+		err := fmt.Errorf("%w: no 'FV' directory in 'Build'", ErrEdk2BuildDir)
+		slog.Error(
+			"Failed to find edk2 build directory",
+			slog.String("suggestion", "'auto_artifacts' expects the build in 'Build/<platform>/<target>_<toolchain>/'"),
+			slog.Any("error", err),
+		)
+
+		return "", err
+	case 1:
+		return dirs[0], nil
+	default:
+		err := fmt.Errorf("%w: multiple candidates %v", ErrEdk2BuildDir, dirs)
+		slog.Error(
+			"Found multiple edk2 build directories",
+			slog.String("suggestion", "use 'platform' so that the build directory is known, or remove stale 'Build' directory from the repository"),
+			slog.Any("error", err),
+		)
+
+		return "", err
+	}
+}
+
+// edk2BuildDir returns build directory '<OUTPUT_DIRECTORY>/<target>_<toolchain>' relative to the
+// repository, it is searched for in the container if it can't be figured out from the options
+func (opts Edk2Opts) edk2BuildDir(ctx context.Context, myContainer *dagger.Container) (string, error) {
+	pattern := filepath.Join("Build", "*", "*", "FV")
+
+	if opts.Platform != "" {
+		buildDirName := fmt.Sprintf("%s_%s", opts.buildTarget(), opts.Toolchain)
+
+		outputDirectory, err := opts.edk2OutputDirectory(containerRepoFileReader(ctx, myContainer))
+		if err == nil {
+			return filepath.Join(outputDirectory, buildDirName), nil
+		}
+
+		slog.Warn(
+			fmt.Sprintf("Failed to find output directory of platform '%s', searching 'Build' directory instead", opts.Platform),
+			slog.Any("error", err),
+		)
+
+		pattern = filepath.Join("Build", "*", buildDirName, "FV")
+	}
+
+	matches, err := myContainer.Directory(ContainerWorkDir).Glob(ctx, pattern)
+	if err != nil {
+		return "", err
+	}
+
+	return selectEdk2BuildDir(matches)
+}
+
+// edk2ArtifactLayout returns artifacts from edk2 build directory in stable layout inside output directory:
+//   - fd/             flash device images
+//   - fv/             firmware volumes
+//   - map/            map files of firmware volumes
+//   - efi/<ARCH>/     EFI images of all modules
+//   - map/<ARCH>/     map files of all modules
+//
+// Only flash device images are required, the rest is exported if present
+func edk2ArtifactLayout(buildDir string, archs []string, outputDir string) []container.Artifacts {
+	buildDir = filepath.Join(ContainerWorkDir, buildDir)
+
+	artifacts := []container.Artifacts{
+		{
+			ContainerPath: filepath.Join(buildDir, "FV", "*.fd"),
+			HostPath:      filepath.Join(outputDir, "fd"),
+			HostDir:       true,
+		},
+		{
+			ContainerPath: filepath.Join(buildDir, "FV", "*.Fv"),
+			HostPath:      filepath.Join(outputDir, "fv"),
+			HostDir:       true,
+			Optional:      true,
+		},
+		{
+			ContainerPath: filepath.Join(buildDir, "FV", "*.map"),
+			HostPath:      filepath.Join(outputDir, "map"),
+			HostDir:       true,
+			Optional:      true,
+		},
+	}
+
+	for _, arch := range archs {
+		artifacts = append(artifacts,
+			container.Artifacts{
+				ContainerPath: filepath.Join(buildDir, arch, "*.efi"),
+				HostPath:      filepath.Join(outputDir, "efi", arch),
+				HostDir:       true,
+				Optional:      true,
+			},
+			container.Artifacts{
+				ContainerPath: filepath.Join(buildDir, arch, "*.map"),
+				HostPath:      filepath.Join(outputDir, "map", arch),
+				HostDir:       true,
+				Optional:      true,
+			},
+		)
+	}
+
+	return artifacts
+}
+
+// autoArtifacts finds the build directory in the container and returns all edk2 artifacts in
+// stable layout, together with the build report
+func (opts Edk2Opts) autoArtifacts(ctx context.Context, myContainer *dagger.Container) ([]container.Artifacts, error) {
+	buildDir, err := opts.edk2BuildDir(ctx, myContainer)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info(fmt.Sprintf("Exporting edk2 artifacts from '%s'", buildDir))
+
+	// Architecture directories which exist in the build directory
+	archs := []string{}
+
+	entries, err := myContainer.Directory(filepath.Join(ContainerWorkDir, buildDir)).Entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if arch := strings.TrimSuffix(entry, "/"); slices.Contains(edk2ArchDirs, arch) {
+			archs = append(archs, arch)
+		}
+	}
+
+	artifacts := edk2ArtifactLayout(buildDir, archs, opts.OutputDir)
+
+	if opts.ReportFile != "" {
+		artifacts = append(artifacts, opts.reportArtifact())
+	}
+
+	return artifacts, nil
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / edk2_artifacts
+package recipes
+
+import (
+	"testing"
+
+	"github.com/9elements/firmware-action/cmd/firmware-action/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectEdk2BuildDir(t *testing.T) {
+	testCases := []struct {
+		name    string
+		matches []string
+		wantDir string
+		wantErr error
+	}{
+		{
+			name:    "single build directory",
+			matches: []string{"Build/UefiPayloadPkgX64/DEBUG_GCC5/FV/"},
+			wantDir: "Build/UefiPayloadPkgX64/DEBUG_GCC5",
+		},
+		{
+			name:    "no build directory",
+			matches: []string{},
+			wantErr: ErrEdk2BuildDir,
+		},
+		{
+			name: "stale build directory",
+			matches: []string{
+				"Build/UefiPayloadPkgX64/DEBUG_GCC5/FV",
+				"Build/UefiPayloadPkgX64/RELEASE_GCC5/FV",
+			},
+			wantErr: ErrEdk2BuildDir,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := selectEdk2BuildDir(tc.matches)
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.wantDir, dir)
+		})
+	}
+}
+
+func TestEdk2ArtifactLayout(t *testing.T) {
+	artifacts := edk2ArtifactLayout("Build/OvmfX64/RELEASE_GCC5", []string{"X64"}, "output-edk2")
+
+	assert.Equal(t, []container.Artifacts{
+		{
+			ContainerPath: "/workdir/Build/OvmfX64/RELEASE_GCC5/FV/*.fd",
+			HostPath:      "output-edk2/fd",
+			HostDir:       true,
+		},
+		{
+			ContainerPath: "/workdir/Build/OvmfX64/RELEASE_GCC5/FV/*.Fv",
+			HostPath:      "output-edk2/fv",
+			HostDir:       true,
+			Optional:      true,
+		},
+		{
+			ContainerPath: "/workdir/Build/OvmfX64/RELEASE_GCC5/FV/*.map",
+			HostPath:      "output-edk2/map",
+			HostDir:       true,
+			Optional:      true,
+		},
+		{
+			ContainerPath: "/workdir/Build/OvmfX64/RELEASE_GCC5/X64/*.efi",
+			HostPath:      "output-edk2/efi/X64",
+			HostDir:       true,
+			Optional:      true,
+		},
+		{
+			ContainerPath: "/workdir/Build/OvmfX64/RELEASE_GCC5/X64/*.map",
+			HostPath:      "output-edk2/map/X64",
+			HostDir:       true,
+			Optional:      true,
+		},
+	}, artifacts)
+}
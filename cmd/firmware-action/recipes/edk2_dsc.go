@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / edk2_dsc
+package recipes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// ErrEdk2OutputDirectory is raised when output directory can't be figured out from platform description file
+var ErrEdk2OutputDirectory = errors.New("failed to get OUTPUT_DIRECTORY from platform description file")
+
+var (
+	// Assignment in '[Defines]' section or 'DEFINE' statement, 'NAME = VALUE'
+	dscAssignmentPattern = regexp.MustCompile(`^(DEFINE\s+)?([A-Za-z_][A-Za-z0-9_]*)\s*=\s*(.*)$`)
+
+	// Macro usage, '$(NAME)'
+	dscMacroPattern = regexp.MustCompile(`\$\(([A-Za-z_][A-Za-z0-9_]*)\)`)
+)
+
+// dscDefines holds values defined in platform description file (DSC)
+type dscDefines struct {
+	// Values in '[Defines]' section, for example 'OUTPUT_DIRECTORY'
+	Defines map[string]string
+
+	// Macros defined with 'DEFINE' statements anywhere in the file
+	Macros map[string]string
+}
+
+// parseDsc extracts defines and macros from content of platform description file
+// Conditional directives such as '!if' are not evaluated, the last definition wins
+func parseDsc(content string) dscDefines {
+	result := dscDefines{
+		Defines: map[string]string{},
+		Macros:  map[string]string{},
+	}
+	inDefines := false
+
+	for _, line := range strings.Split(content, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		line = strings.TrimSpace(line)
+
+		if strings.HasPrefix(line, "[") {
+			section := strings.ToLower(strings.Trim(line, "[]"))
+			inDefines = section == "defines" || strings.HasPrefix(section, "defines.")
+
+			continue
+		}
+
+		match := dscAssignmentPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		value := strings.TrimSpace(match[3])
+
+		switch {
+		case match[1] != "":
+			result.Macros[match[2]] = value
+		case inDefines:
+			result.Defines[match[2]] = value
+		}
+	}
+
+	return result
+}
+
+// expandDscMacros replaces all '$(NAME)' in value, fails on undefined macro
+func expandDscMacros(value string, macros map[string]string) (string, error) {
+	var err error
+
+	expanded := dscMacroPattern.ReplaceAllStringFunc(value, func(macro string) string {
+		name := dscMacroPattern.FindStringSubmatch(macro)[1]
+
+		replacement, ok := macros[name]
+		if !ok {
+			err = fmt.Errorf("%w: macro '%s' is not defined", ErrEdk2OutputDirectory, name)
+		}
+
+		return replacement
+	})
+
+	return expanded, err
+}
+
+// repoFileReader reads file given by path relative to the repository
+type repoFileReader func(path string) ([]byte, error)
+
+// containerRepoFileReader reads files of the repository in the container, where patches are applied
+func containerRepoFileReader(ctx context.Context, myContainer *dagger.Container) repoFileReader {
+	return func(path string) ([]byte, error) {
+		content, err := myContainer.File(filepath.Join(ContainerWorkDir, path)).Contents(ctx)
+
+		return []byte(content), err
+	}
+}
+
+// readDsc returns content of the platform description file, which is searched for in the
+// repository and in all 'packages_paths', the same way as edk2 does
+func (opts Edk2Opts) readDsc(readFile repoFileReader) (string, error) {
+	candidates := []string{opts.Platform}
+	for _, packagesPath := range opts.PackagesPaths {
+		candidates = append(candidates, filepath.Join(packagesPath, opts.Platform))
+	}
+
+	for _, candidate := range candidates {
+		if content, err := readFile(candidate); err == nil {
+			return string(content), nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: '%s' not found: %w", ErrEdk2OutputDirectory, opts.Platform, os.ErrNotExist)
+}
+
+// edk2OutputDirectory returns OUTPUT_DIRECTORY of the platform, relative to the repository
+// The DSC is read with 'readFile', during build from the container so that patches are taken into account
+func (opts Edk2Opts) edk2OutputDirectory(readFile repoFileReader) (string, error) {
+	content, err := opts.readDsc(readFile)
+	if err != nil {
+		return "", err
+	}
+
+	dsc := parseDsc(content)
+
+	outputDirectory, ok := dsc.Defines["OUTPUT_DIRECTORY"]
+	if !ok {
+		return "", fmt.Errorf("%w: '%s' does not define it", ErrEdk2OutputDirectory, opts.Platform)
+	}
+
+	// Macros given on command line take precedence over the ones in DSC
+	macros := dsc.Macros
+	for name, value := range dsc.Defines {
+		if _, ok := macros[name]; !ok {
+			macros[name] = value
+		}
+	}
+
+	for name, value := range opts.Defines {
+		macros[name] = value
+	}
+
+	macros["TARGET"] = opts.buildTarget()
+	macros["TOOL_CHAIN_TAG"] = opts.Toolchain
+
+	return expandDscMacros(outputDirectory, macros)
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / edk2_dsc
+package recipes
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testDsc = `## @file
+# Example platform
+##
+
+[Defines]
+  PLATFORM_NAME                  = UefiPayloadPkg
+  SUPPORTED_ARCHITECTURES        = IA32|X64
+  BUILD_TARGETS                  = DEBUG|RELEASE|NOOPT
+  OUTPUT_DIRECTORY               = Build/UefiPayloadPkg$(BUILD_ARCH) # per architecture
+
+  DEFINE BUILD_ARCH              = X64
+  DEFINE SOURCE_DEBUG_ENABLE     = FALSE
+
+[LibraryClasses]
+  BaseLib|MdePkg/Library/BaseLib/BaseLib.inf
+`
+
+func TestParseDsc(t *testing.T) {
+	dsc := parseDsc(testDsc)
+	assert.Equal(t, "UefiPayloadPkg", dsc.Defines["PLATFORM_NAME"])
+	assert.Equal(t, "Build/UefiPayloadPkg$(BUILD_ARCH)", dsc.Defines["OUTPUT_DIRECTORY"])
+	assert.Equal(t, map[string]string{"BUILD_ARCH": "X64", "SOURCE_DEBUG_ENABLE": "FALSE"}, dsc.Macros)
+	assert.NotContains(t, dsc.Defines, "BuildLib|MdePkg/Library/BaseLib/BaseLib.inf")
+}
+
+func TestExpandDscMacros(t *testing.T) {
+	expanded, err := expandDscMacros("Build/$(NAME)_$(ARCH)", map[string]string{"NAME": "Ovmf", "ARCH": "X64"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Build/Ovmf_X64", expanded)
+
+	_, err = expandDscMacros("Build/$(UNDEFINED)", map[string]string{})
+	assert.ErrorIs(t, err, ErrEdk2OutputDirectory)
+}
+
+func TestEdk2OutputDirectory(t *testing.T) {
+	repoPath := t.TempDir()
+	dscDir := filepath.Join(repoPath, "edk2-platforms", "UefiPayloadPkg")
+	assert.NoError(t, os.MkdirAll(dscDir, 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dscDir, "UefiPayloadPkg.dsc"), []byte(testDsc), 0o644))
+
+	opts := Edk2Opts{
+		CommonOpts: CommonOpts{RepoPath: repoPath},
+		Edk2Specific: Edk2Specific{
+			Platform:      "UefiPayloadPkg/UefiPayloadPkg.dsc",
+			Toolchain:     "GCC5",
+			PackagesPaths: []string{"edk2-platforms"},
+		},
+	}
+
+	readFile := func(path string) ([]byte, error) {
+		return os.ReadFile(filepath.Join(repoPath, path))
+	}
+
+	// DSC is found in packages path, macro defined in DSC
+	outputDirectory, err := opts.edk2OutputDirectory(readFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "Build/UefiPayloadPkgX64", outputDirectory)
+
+	// Macro given with 'defines' takes precedence
+	opts.Defines = map[string]string{"BUILD_ARCH": "IA32"}
+	outputDirectory, err = opts.edk2OutputDirectory(readFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "Build/UefiPayloadPkgIA32", outputDirectory)
+
+	// Missing DSC
+	opts.PackagesPaths = nil
+	_, err = opts.edk2OutputDirectory(readFile)
+	assert.ErrorIs(t, err, ErrEdk2OutputDirectory)
+}
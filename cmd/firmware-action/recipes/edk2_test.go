@@ -6,15 +6,61 @@
 package recipes
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"testing"
 
 	"dagger.io/dagger"
 	"github.com/stretchr/testify/assert"
 )
 
+func TestEdk2BuildCmd(t *testing.T) {
+	opts := Edk2Opts{
+		Arch: "IA32X64",
+		Edk2Specific: Edk2Specific{
+			Platform:    "UefiPayloadPkg/UefiPayloadPkg.dsc",
+			Toolchain:   "GCC5",
+			BuildTarget: "RELEASE",
+			Defines: map[string]string{
+				"TPM_ENABLE": "TRUE",
+				"BOOTLOADER": "COREBOOT",
+				"VERSION":    "with spaces and 'quotes'",
+			},
+			ReportFile:  "Build/report.txt",
+			ReportTypes: []string{"PCD", "FLASH"},
+		},
+	}
+
+	cmd := opts.buildCmd()
+	assert.Equal(t, []string{"bash", "-c", `source ./edksetup.sh && build "$@"`, "build"}, cmd[:4])
+	assert.Equal(t, []string{
+		"-p", "UefiPayloadPkg/UefiPayloadPkg.dsc",
+		"-t", "GCC5",
+		"-b", "RELEASE",
+		"-n", fmt.Sprintf("%d", runtime.NumCPU()),
+		"-a", "IA32",
+		"-a", "X64",
+		"-D", "BOOTLOADER=COREBOOT",
+		"-D", "TPM_ENABLE=TRUE",
+		"-D", "VERSION=with spaces and 'quotes'",
+		"-y", "Build/report.txt",
+		"-Y", "PCD",
+		"-Y", "FLASH",
+	}, cmd[4:])
+
+	// Defaults
+	opts = Edk2Opts{Edk2Specific: Edk2Specific{Platform: "OvmfPkg/OvmfPkgX64.dsc", Toolchain: "GCC5"}}
+	assert.Equal(t, []string{
+		"-p", "OvmfPkg/OvmfPkgX64.dsc",
+		"-t", "GCC5",
+		"-b", "DEBUG",
+		"-n", fmt.Sprintf("%d", runtime.NumCPU()),
+	}, opts.buildArgs())
+}
+
 func TestEdk2(t *testing.T) {
 	// This test is really slow (like 100 seconds)
 	if testing.Short() {
@@ -0,0 +1,471 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / kconfig
+package recipes
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// kconfigContainerDir is directory in container into which Kconfig fragments are copied
+const kconfigContainerDir = "/tmp/firmware-action-kconfig"
+
+// DotConfigFileName is name of the final '.config' exported into output directory
+const DotConfigFileName = "dotconfig"
+
+var (
+	// ErrKconfigMismatch is raised when requested Kconfig options are missing or changed in final '.config'
+	ErrKconfigMismatch = errors.New("requested Kconfig options did not survive into .config")
+	// ErrDefconfigDrift is raised when defconfig generated by 'make savedefconfig' differs from the input one
+	ErrDefconfigDrift = errors.New("defconfig differs from the one generated by savedefconfig")
+)
+
+// ANCHOR: KconfigOpts
+
+// KconfigOpts is used to store Kconfig options common to coreboot, Linux and U-Boot
+type KconfigOpts struct {
+	// Gives the (relative) paths to Kconfig fragments, which are applied in given order
+	//   on top of the defconfig.
+	// Fragment has the same format as defconfig, but contains only a few options.
+	// Example:
+	//   "config_fragments": [ "configs/debug.fragment", "configs/tpm.fragment" ]
+	ConfigFragments []string `json:"config_fragments" validate:"dive,filepath"`
+
+	// Specifies Kconfig options which are set after the defconfig and fragments are applied,
+	//   they take precedence over both.
+	// Values are written the same way as in defconfig:
+	//   - 'y', 'n' or 'm' for bool and tristate options
+	//   - quoted string for string options
+	//   - decimal or hexadecimal (with '0x' prefix) number for int and hex options
+	// Example:
+	//   "kconfig": {
+	//     "CONFIG_CONSOLE_SERIAL": "y",
+	//     "CONFIG_LOCALVERSION": "\"${BUILD_ID}\""
+	//   }
+	Kconfig map[string]string `json:"kconfig" validate:"dive,keys,startswith=CONFIG_,endkeys,kconfig_value"`
+
+	// Kconfig silently drops options whose dependencies are not met. After the build, all options
+	//   set in defconfig, fragments and 'kconfig' are compared with the final '.config', and
+	//   dropped or changed options are reported as warnings.
+	// When enabled, dropped or changed options fail the build instead.
+	// The final '.config' is always exported into 'output_dir' as 'dotconfig'.
+	StrictKconfig bool `json:"strict_kconfig"`
+
+	// Specifies what to do when 'defconfig' generated by 'make savedefconfig' differs from
+	//   the defconfig given in 'defconfig_path', which happens for example after version bump.
+	// Supported options:
+	//   - '' (empty) - no check
+	//   - 'warn' - show unified diff in the log and in the build report
+	//   - 'fail' - the same as 'warn', but also fail the build
+	//   - 'update' - the same as 'warn', but also overwrite 'defconfig_path' with the generated defconfig
+	// The defconfig for comparison is generated from 'defconfig_path' alone, options from
+	//   'config_fragments' and 'kconfig' are not part of it and never end up in 'defconfig_path'.
+	DefconfigCheck string `json:"defconfig_check" validate:"omitempty,oneof=warn fail update"`
+}
+
+// ANCHOR_END: KconfigOpts
+
+// kconfigSymbol is single Kconfig option as written in defconfig
+type kconfigSymbol struct {
+	Name  string
+	Value string // 'n' for options which are not set
+}
+
+var (
+	// kconfigValuePattern matches bool / tristate, string, int and hex values
+	kconfigValuePattern  = regexp.MustCompile(`^(y|n|m|"(?:[^"\\]|\\.)*"|-?[0-9]+|0[xX][0-9a-fA-F]+)$`)
+	kconfigSetPattern    = regexp.MustCompile(`^(CONFIG_[A-Za-z0-9_]+)=(.*)$`)
+	kconfigNotSetPattern = regexp.MustCompile(`^# (CONFIG_[A-Za-z0-9_]+) is not set$`)
+)
+
+// parseKconfig returns all options in defconfig, fragment or '.config', in order of appearance
+func parseKconfig(content string) []kconfigSymbol {
+	symbols := []kconfigSymbol{}
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if match := kconfigSetPattern.FindStringSubmatch(line); match != nil {
+			symbols = append(symbols, kconfigSymbol{Name: match[1], Value: match[2]})
+		} else if match := kconfigNotSetPattern.FindStringSubmatch(line); match != nil {
+			symbols = append(symbols, kconfigSymbol{Name: match[1], Value: "n"})
+		}
+	}
+
+	return symbols
+}
+
+// readKconfig reads and parses Kconfig file on host
+func readKconfig(path string) ([]kconfigSymbol, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		slog.Error(
+			fmt.Sprintf("Failed to read Kconfig file '%s'", path),
+			slog.String("suggestion", "Check 'defconfig_path' and 'config_fragments' in the configuration file"),
+			slog.Any("error", err),
+		)
+
+		return nil, err
+	}
+
+	return parseKconfig(string(content)), nil
+}
+
+// kconfigScriptCmd returns command which sets the option with 'scripts/config' (or coreboot's
+// 'util/scripts/config'), the type of the option is derived from its value
+func kconfigScriptCmd(script string, symbol kconfigSymbol) []string {
+	switch {
+	case symbol.Value == "y":
+		return []string{script, "--enable", symbol.Name}
+	case symbol.Value == "n":
+		return []string{script, "--disable", symbol.Name}
+	case symbol.Value == "m":
+		return []string{script, "--module", symbol.Name}
+	case strings.HasPrefix(symbol.Value, `"`):
+		value, err := strconv.Unquote(symbol.Value)
+		if err != nil {
+			value = strings.Trim(symbol.Value, `"`)
+		}
+
+		return []string{script, "--set-str", symbol.Name, value}
+	default:
+		// Integer or hexadecimal number
+		return []string{script, "--set-val", symbol.Name, symbol.Value}
+	}
+}
+
+// kconfigOverrides returns options from 'kconfig', sorted by name
+func (opts KconfigOpts) kconfigOverrides() []kconfigSymbol {
+	symbols := []kconfigSymbol{}
+
+	for _, name := range slices.Sorted(maps.Keys(opts.Kconfig)) {
+		symbols = append(symbols, kconfigSymbol{Name: name, Value: opts.Kconfig[name]})
+	}
+
+	return symbols
+}
+
+// kconfigFragment returns content of fragment with options from 'kconfig'
+func kconfigFragment(symbols []kconfigSymbol) string {
+	var fragment strings.Builder
+
+	for _, symbol := range symbols {
+		if symbol.Value == "n" {
+			fmt.Fprintf(&fragment, "# %s is not set\n", symbol.Name)
+		} else {
+			fmt.Fprintf(&fragment, "%s=%s\n", symbol.Name, symbol.Value)
+		}
+	}
+
+	return fragment.String()
+}
+
+// kconfigSources returns paths to all fragments
+func (opts KconfigOpts) kconfigSources() []string {
+	return opts.ConfigFragments
+}
+
+// fragmentContainerPath returns path to the fragment in the container
+// Fragments are prefixed with their index, so that fragments with the same name do not collide
+func fragmentContainerPath(index int, fragment string) string {
+	return filepath.Join(kconfigContainerDir, fmt.Sprintf("%02d_%s", index, filepath.Base(fragment)))
+}
+
+// overridesContainerPath returns path in the container to fragment generated from 'kconfig'
+func (opts KconfigOpts) overridesContainerPath() string {
+	return fragmentContainerPath(len(opts.ConfigFragments), "kconfig.fragment")
+}
+
+// withFragments copies all fragments into the container, together with fragment generated from 'kconfig'
+func (opts KconfigOpts) withFragments(client *dagger.Client, myContainer *dagger.Container, pwd string) *dagger.Container {
+	for index, fragment := range opts.ConfigFragments {
+		myContainer = myContainer.WithFile(
+			fragmentContainerPath(index, fragment),
+			client.Host().File(filepath.Join(pwd, fragment)),
+		)
+	}
+
+	if len(opts.Kconfig) > 0 {
+		myContainer = myContainer.WithNewFile(
+			opts.overridesContainerPath(),
+			kconfigFragment(opts.kconfigOverrides()),
+		)
+	}
+
+	return myContainer
+}
+
+// mergeKconfigSteps returns build steps which merge fragments and 'kconfig' into '.config'
+// with 'merge_config.sh', as used by Linux and U-Boot
+func (opts KconfigOpts) mergeKconfigSteps() [][]string {
+	if len(opts.ConfigFragments) == 0 && len(opts.Kconfig) == 0 {
+		return [][]string{}
+	}
+
+	// -m: only merge the fragments, 'make olddefconfig' is run afterwards
+	mergeCmd := []string{"scripts/kconfig/merge_config.sh", "-m", ".config"}
+	for index, fragment := range opts.ConfigFragments {
+		mergeCmd = append(mergeCmd, fragmentContainerPath(index, fragment))
+	}
+
+	if len(opts.Kconfig) > 0 {
+		mergeCmd = append(mergeCmd, opts.overridesContainerPath())
+	}
+
+	return [][]string{
+		mergeCmd,
+		{"make", "olddefconfig"},
+	}
+}
+
+// scriptKconfigSteps returns build steps which apply fragments and 'kconfig' to '.config' option
+// by option with given 'scripts/config' tool, as used by coreboot
+// 'make olddefconfig' must be run afterwards
+func (opts KconfigOpts) scriptKconfigSteps(script string) ([][]string, error) {
+	steps := [][]string{}
+
+	for _, fragment := range opts.ConfigFragments {
+		symbols, err := readKconfig(fragment)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, symbol := range symbols {
+			steps = append(steps, kconfigScriptCmd(script, symbol))
+		}
+	}
+
+	for _, symbol := range opts.kconfigOverrides() {
+		steps = append(steps, kconfigScriptCmd(script, symbol))
+	}
+
+	return steps, nil
+}
+
+// kconfigDifference describes requested option which has different value in final '.config'
+type kconfigDifference struct {
+	Name      string
+	Requested string
+	Final     string // 'n' if the option is missing
+}
+
+// kconfigValuesEqual compares two values of Kconfig option, numbers are compared by value
+func kconfigValuesEqual(a string, b string) bool {
+	if a == b {
+		return true
+	}
+
+	numberA, errA := strconv.ParseInt(a, 0, 64)
+	numberB, errB := strconv.ParseInt(b, 0, 64)
+
+	return errA == nil && errB == nil && numberA == numberB
+}
+
+// kconfigDifferences returns requested options which are missing or have different value in final options
+// Options listed in 'ignore' are not compared
+func kconfigDifferences(requested []kconfigSymbol, final []kconfigSymbol, ignore []string) []kconfigDifference {
+	// Later occurrence wins, the same way as in Kconfig
+	wanted := map[string]string{}
+	for _, symbol := range requested {
+		wanted[symbol.Name] = symbol.Value
+	}
+
+	got := map[string]string{}
+	for _, symbol := range final {
+		got[symbol.Name] = symbol.Value
+	}
+
+	differences := []kconfigDifference{}
+
+	for _, name := range slices.Sorted(maps.Keys(wanted)) {
+		if slices.Contains(ignore, name) {
+			continue
+		}
+
+		value, ok := got[name]
+		if !ok {
+			// Option which is not present is not set
+			value = "n"
+		}
+
+		if !kconfigValuesEqual(wanted[name], value) {
+			differences = append(differences, kconfigDifference{Name: name, Requested: wanted[name], Final: value})
+		}
+	}
+
+	return differences
+}
+
+// requestedKconfig returns all options set in defconfig, fragments and 'kconfig', in order in which they are applied
+func (opts KconfigOpts) requestedKconfig(defconfigPath string) ([]kconfigSymbol, error) {
+	requested := []kconfigSymbol{}
+
+	for _, path := range append([]string{defconfigPath}, opts.ConfigFragments...) {
+		symbols, err := readKconfig(path)
+		if err != nil {
+			return nil, err
+		}
+
+		requested = append(requested, symbols...)
+	}
+
+	return append(requested, opts.kconfigOverrides()...), nil
+}
+
+// checkKconfig exports final '.config' from the container into output directory and compares it with
+// options requested in defconfig, fragments and 'kconfig'
+// Options listed in 'ignore' are set by firmware-action itself (for example paths to blobs) and are not compared
+func (opts KconfigOpts) checkKconfig(ctx context.Context, myContainer *dagger.Container, defconfigPath string, outputDir string, ignore []string) error {
+	stopTiming := recordPhase(ctx, PhaseArtifactExport, "export .config")
+	dotConfig := myContainer.File(filepath.Join(ContainerWorkDir, ".config"))
+
+	_, err := dotConfig.Export(ctx, filepath.Join(outputDir, DotConfigFileName))
+
+	stopTiming()
+
+	if err != nil {
+		slog.Error(
+			"Failed to export .config from container",
+			slog.Any("error", err),
+		)
+
+		return err
+	}
+
+	content, err := dotConfig.Contents(ctx)
+	if err != nil {
+		return err
+	}
+
+	requested, err := opts.requestedKconfig(defconfigPath)
+	if err != nil {
+		return err
+	}
+
+	differences := kconfigDifferences(requested, parseKconfig(content), ignore)
+	if len(differences) == 0 {
+		return nil
+	}
+
+	log := slog.Warn
+	if opts.StrictKconfig {
+		log = slog.Error
+	}
+
+	for _, difference := range differences {
+		log(
+			fmt.Sprintf("Kconfig option '%s' was requested as '%s', but ended up as '%s'", difference.Name, difference.Requested, difference.Final),
+			slog.String("suggestion", "Dependencies of the option are likely not met, check them with 'make menuconfig'"),
+		)
+	}
+
+	if opts.StrictKconfig {
+		err = fmt.Errorf("%w: %d option(s) dropped or changed", ErrKconfigMismatch, len(differences))
+		slog.Error(
+			"Final .config does not match requested Kconfig options",
+			slog.String("suggestion", fmt.Sprintf("Compare '%s' in output directory with defconfig, or disable 'strict_kconfig'", DotConfigFileName)),
+			slog.Any("error", err),
+		)
+
+		return err
+	}
+
+	return nil
+}
+
+// checkDefconfig compares defconfig generated by 'make savedefconfig' with the input defconfig,
+// as configured by 'defconfig_check'
+// The '.config' is generated again from the input defconfig alone with 'defconfigSteps', in a copy
+// of the container, so that fragments, 'kconfig' and options set by firmware-action (such as paths
+// to blobs) do not show up as a difference, and 'update' does not write them into the input defconfig
+func (opts KconfigOpts) checkDefconfig(
+	ctx context.Context,
+	myContainer *dagger.Container,
+	defconfigPath string,
+	defconfigSteps [][]string,
+	runBuildSteps func(context.Context, *dagger.Container, [][]string) (*dagger.Container, error),
+) error {
+	if opts.DefconfigCheck == "" {
+		return nil
+	}
+
+	savedefconfigContainer, err := runBuildSteps(ctx, myContainer, slices.Concat(defconfigSteps, [][]string{{"make", "savedefconfig"}}))
+	if err != nil {
+		slog.Error(
+			"Failed to generate defconfig with 'make savedefconfig'",
+			slog.Any("error", err),
+		)
+
+		return err
+	}
+
+	generated, err := savedefconfigContainer.File(filepath.Join(ContainerWorkDir, "defconfig")).Contents(ctx)
+	if err != nil {
+		slog.Error(
+			"Failed to read defconfig generated by 'make savedefconfig'",
+			slog.Any("error", err),
+		)
+
+		return err
+	}
+
+	original, err := os.ReadFile(defconfigPath)
+	if err != nil {
+		return err
+	}
+
+	diff := unifiedDiff(defconfigPath, "savedefconfig", string(original), generated)
+	if diff == "" {
+		slog.Info(fmt.Sprintf("Defconfig '%s' is up-to-date with savedefconfig", defconfigPath))
+
+		return nil
+	}
+
+	// Multi-board build collects diffs of all boards
+	getBuildDetails(ctx).DefconfigDiff += diff
+
+	switch opts.DefconfigCheck {
+	case "fail":
+		err = fmt.Errorf("%w: '%s'", ErrDefconfigDrift, defconfigPath)
+		slog.Error(
+			fmt.Sprintf("Defconfig '%s' differs from savedefconfig:\n%s", defconfigPath, diff),
+			slog.String("suggestion", "Update the defconfig, for example with 'defconfig_check' set to 'update'"),
+			slog.Any("error", err),
+		)
+
+		return err
+	case "update":
+		slog.Warn(fmt.Sprintf("Defconfig '%s' differs from savedefconfig, updating it:\n%s", defconfigPath, diff))
+
+		err = os.WriteFile(defconfigPath, []byte(generated), 0o644)
+		if err != nil {
+			slog.Error(
+				fmt.Sprintf("Failed to update defconfig '%s'", defconfigPath),
+				slog.Any("error", err),
+			)
+		}
+
+		return err
+	default:
+		slog.Warn(
+			fmt.Sprintf("Defconfig '%s' differs from savedefconfig:\n%s", defconfigPath, diff),
+			slog.String("suggestion", "Update the defconfig, for example with 'defconfig_check' set to 'update'"),
+		)
+
+		return nil
+	}
+}
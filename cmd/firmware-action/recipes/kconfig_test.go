@@ -0,0 +1,161 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / kconfig
+package recipes
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKconfig(t *testing.T) {
+	content := `# Comment
+CONFIG_VENDOR_EMULATION=y
+CONFIG_BOARD_EMULATION_QEMU_X86_Q35=y
+# CONFIG_CONSOLE_SERIAL is not set
+CONFIG_LOCALVERSION="my \"build\""
+CONFIG_CBFS_SIZE=0x200000
+
+CONFIG_UART_FOR_CONSOLE=1
+`
+	assert.Equal(t, []kconfigSymbol{
+		{Name: "CONFIG_VENDOR_EMULATION", Value: "y"},
+		{Name: "CONFIG_BOARD_EMULATION_QEMU_X86_Q35", Value: "y"},
+		{Name: "CONFIG_CONSOLE_SERIAL", Value: "n"},
+		{Name: "CONFIG_LOCALVERSION", Value: `"my \"build\""`},
+		{Name: "CONFIG_CBFS_SIZE", Value: "0x200000"},
+		{Name: "CONFIG_UART_FOR_CONSOLE", Value: "1"},
+	}, parseKconfig(content))
+}
+
+func TestKconfigScriptCmd(t *testing.T) {
+	testCases := []struct {
+		value string
+		want  []string
+	}{
+		{value: "y", want: []string{"config", "--enable", "CONFIG_FOO"}},
+		{value: "n", want: []string{"config", "--disable", "CONFIG_FOO"}},
+		{value: "m", want: []string{"config", "--module", "CONFIG_FOO"}},
+		{value: `"my \"build\""`, want: []string{"config", "--set-str", "CONFIG_FOO", `my "build"`}},
+		{value: `""`, want: []string{"config", "--set-str", "CONFIG_FOO", ""}},
+		{value: "0x200000", want: []string{"config", "--set-val", "CONFIG_FOO", "0x200000"}},
+		{value: "42", want: []string{"config", "--set-val", "CONFIG_FOO", "42"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			assert.Equal(t, tc.want, kconfigScriptCmd("config", kconfigSymbol{Name: "CONFIG_FOO", Value: tc.value}))
+		})
+	}
+}
+
+func TestKconfigFragments(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Chdir(tmpDir)
+
+	assert.NoError(t, os.MkdirAll("debug", 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join("debug", "fragment"), []byte("CONFIG_DEBUG=y\n"), 0o644))
+	assert.NoError(t, os.WriteFile("fragment", []byte("# CONFIG_TPM is not set\n"), 0o644))
+
+	opts := KconfigOpts{ConfigFragments: []string{filepath.Join("debug", "fragment"), "fragment"}}
+
+	assert.Equal(t, [][]string{
+		{"scripts/kconfig/merge_config.sh", "-m", ".config", kconfigContainerDir + "/00_fragment", kconfigContainerDir + "/01_fragment"},
+		{"make", "olddefconfig"},
+	}, opts.mergeKconfigSteps())
+
+	steps, err := opts.scriptKconfigSteps("./util/scripts/config")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"./util/scripts/config", "--enable", "CONFIG_DEBUG"},
+		{"./util/scripts/config", "--disable", "CONFIG_TPM"},
+	}, steps)
+
+	_, err = KconfigOpts{ConfigFragments: []string{"missing"}}.scriptKconfigSteps("./util/scripts/config")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	assert.Empty(t, KconfigOpts{}.mergeKconfigSteps())
+}
+
+func TestKconfigOverrides(t *testing.T) {
+	opts := KconfigOpts{
+		ConfigFragments: []string{},
+		Kconfig: map[string]string{
+			"CONFIG_LOCALVERSION":   `"build-42"`,
+			"CONFIG_CONSOLE_SERIAL": "y",
+			"CONFIG_TPM2":           "n",
+			"CONFIG_CBFS_SIZE":      "0x200000",
+		},
+	}
+
+	assert.Equal(t, `CONFIG_CBFS_SIZE=0x200000
+CONFIG_CONSOLE_SERIAL=y
+CONFIG_LOCALVERSION="build-42"
+# CONFIG_TPM2 is not set
+`, kconfigFragment(opts.kconfigOverrides()))
+
+	// Generated fragment parses back into the same options
+	assert.Equal(t, opts.kconfigOverrides(), parseKconfig(kconfigFragment(opts.kconfigOverrides())))
+
+	assert.Equal(t, [][]string{
+		{"scripts/kconfig/merge_config.sh", "-m", ".config", kconfigContainerDir + "/00_kconfig.fragment"},
+		{"make", "olddefconfig"},
+	}, opts.mergeKconfigSteps())
+
+	steps, err := opts.scriptKconfigSteps("./util/scripts/config")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"./util/scripts/config", "--set-val", "CONFIG_CBFS_SIZE", "0x200000"},
+		{"./util/scripts/config", "--enable", "CONFIG_CONSOLE_SERIAL"},
+		{"./util/scripts/config", "--set-str", "CONFIG_LOCALVERSION", "build-42"},
+		{"./util/scripts/config", "--disable", "CONFIG_TPM2"},
+	}, steps)
+}
+
+func TestKconfigDifferences(t *testing.T) {
+	requested := parseKconfig(`CONFIG_TPM2=y
+CONFIG_CONSOLE_SERIAL=y
+CONFIG_CBFS_SIZE=0x200000
+CONFIG_PAYLOAD_FILE="payload.elf"
+# CONFIG_DEBUG is not set
+CONFIG_LOCALVERSION="first"
+CONFIG_LOCALVERSION="second"
+`)
+	final := parseKconfig(`CONFIG_CONSOLE_SERIAL=y
+CONFIG_CBFS_SIZE=2097152
+CONFIG_PAYLOAD_FILE="3rdparty/blobs/mainboard/emulation/payload.elf"
+CONFIG_LOCALVERSION="first"
+`)
+
+	assert.Equal(t, []kconfigDifference{
+		{Name: "CONFIG_LOCALVERSION", Requested: `"second"`, Final: `"first"`},
+		{Name: "CONFIG_TPM2", Requested: "y", Final: "n"},
+	}, kconfigDifferences(requested, final, []string{"CONFIG_PAYLOAD_FILE"}))
+}
+
+func TestRequestedKconfig(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Chdir(tmpDir)
+
+	assert.NoError(t, os.WriteFile("defconfig", []byte("CONFIG_DEBUG=y\nCONFIG_TPM2=y\n"), 0o644))
+	assert.NoError(t, os.WriteFile("release.fragment", []byte("# CONFIG_DEBUG is not set\n"), 0o644))
+
+	opts := KconfigOpts{
+		ConfigFragments: []string{"release.fragment"},
+		Kconfig:         map[string]string{"CONFIG_TPM2": "n"},
+	}
+
+	requested, err := opts.requestedKconfig("defconfig")
+	assert.NoError(t, err)
+	assert.Equal(t, []kconfigSymbol{
+		{Name: "CONFIG_DEBUG", Value: "y"},
+		{Name: "CONFIG_TPM2", Value: "y"},
+		{Name: "CONFIG_DEBUG", Value: "n"},
+		{Name: "CONFIG_TPM2", Value: "n"},
+	}, requested)
+
+	// Nothing requested is dropped
+	assert.Empty(t, kconfigDifferences(requested, parseKconfig("# CONFIG_DEBUG is not set\n"), nil))
+}
@@ -55,6 +55,9 @@ type LinuxOpts struct {
 
 	// Gives the (relative) path to the defconfig that should be used to build the target.
 	DefconfigPath string `json:"defconfig_path" validate:"required,filepath"`
+
+	// Kconfig fragments and overrides applied on top of the defconfig
+	KconfigOpts
 }
 
 // ANCHOR_END: LinuxOpts
@@ -69,20 +72,38 @@ func (opts LinuxOpts) GetArtifacts() *[]container.Artifacts {
 	return opts.CommonOpts.GetArtifacts()
 }
 
+// withCommonOpts returns a copy of the module with modified common options
+func (opts LinuxOpts) withCommonOpts(modify func(opts *CommonOpts)) FirmwareModule {
+	modify(&opts.CommonOpts)
+
+	return opts
+}
+
 // GetSources returns slice of paths to all sources which are used for build
 func (opts LinuxOpts) GetSources() []string {
 	sources := opts.CommonOpts.GetSources()
 
 	// Add DefconfigPath to list of sources
 	sources = append(sources, opts.DefconfigPath)
+	sources = append(sources, opts.kconfigSources()...)
 
 	return sources
 }
 
-// buildFirmware builds linux kernel
-//
-//	docs: https://www.kernel.org/doc/html/latest/kbuild/index.html
-func (opts LinuxOpts) buildFirmware(ctx context.Context, client *dagger.Client) error {
+// prepareContainer spins up a container ready to build linux kernel, returns it together with the build steps
+func (opts LinuxOpts) prepareContainer(ctx context.Context, client *dagger.Client) (*dagger.Container, [][]string, error) {
+	// Setup environment variables in the container
+	//   Handle cross-compilation: Map architecture to cross-compiler
+	envVars, err := LinuxCrossCompilationArchMap(opts.Arch)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	secrets, err := opts.GetSecrets()
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Spin up container
 	containerOpts := container.SetupOpts{
 		ContainerURL:      opts.SdkURL,
@@ -92,16 +113,24 @@ func (opts LinuxOpts) buildFirmware(ctx context.Context, client *dagger.Client)
 		ContainerInputDir: opts.ContainerInputDir,
 		InputDirs:         opts.InputDirs,
 		InputFiles:        opts.InputFiles,
+		EnvVars:           opts.containerEnvVars(envVars),
+		Secrets:           secrets,
 	}
 
-	myContainer, err := container.Setup(ctx, client, &containerOpts)
+	myContainer, err := opts.setupModuleContainer(ctx, client, &containerOpts)
 	if err != nil {
 		slog.Error(
 			"Failed to start a container",
 			slog.Any("error", err),
 		)
 
-		return err
+		return nil, nil, err
+	}
+
+	// Apply patches before anything else touches the repository
+	myContainer, err = opts.applyPatches(ctx, client, myContainer)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Copy over the defconfig file
@@ -109,7 +138,7 @@ func (opts LinuxOpts) buildFirmware(ctx context.Context, client *dagger.Client)
 
 	err = ValidateLinuxDefconfigFilename(opts.DefconfigPath)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	//   not sure why, but without the 'pwd' I am getting different results between CI and 'go test'
 	pwd, err := os.Getwd()
@@ -120,24 +149,14 @@ func (opts LinuxOpts) buildFirmware(ctx context.Context, client *dagger.Client)
 			slog.Any("error", err),
 		)
 
-		return err
+		return nil, nil, err
 	}
 
 	myContainer = myContainer.WithFile(
 		filepath.Join(ContainerWorkDir, defconfigBasename),
 		client.Host().File(filepath.Join(pwd, opts.DefconfigPath)),
 	)
-
-	// Setup environment variables in the container
-	//   Handle cross-compilation: Map architecture to cross-compiler
-	envVars, err := LinuxCrossCompilationArchMap(opts.Arch)
-	if err != nil {
-		return err
-	}
-
-	for key, value := range envVars {
-		myContainer = myContainer.WithEnvVariable(key, value)
-	}
+	myContainer = opts.withFragments(client, myContainer, pwd)
 
 	// Assemble commands to build
 	// TODO: make independent on OS
@@ -150,29 +169,59 @@ func (opts LinuxOpts) buildFirmware(ctx context.Context, client *dagger.Client)
 		{"mv", defconfigBasename, fmt.Sprintf("arch/%s/configs/%s", NormalizeArchitectureForLinux(opts.Arch), defconfigBasename)},
 		// generate dotconfig from defconfig
 		{"make", defconfigBasename},
+	}
+
+	// Apply Kconfig fragments and overrides
+	buildSteps = append(buildSteps, opts.mergeKconfigSteps()...)
+
+	buildSteps = append(
+		buildSteps,
 		// compile
-		{"make", "-j", fmt.Sprintf("%d", runtime.NumCPU())},
+		[]string{"make", "-j", fmt.Sprintf("%d", runtime.NumCPU())},
 		// for documenting purposes
-		{"make", "savedefconfig"},
+		[]string{"make", "savedefconfig"},
+	)
+
+	return myContainer, buildSteps, nil
+}
+
+// buildFirmware builds linux kernel
+//
+//	docs: https://www.kernel.org/doc/html/latest/kbuild/index.html
+func (opts LinuxOpts) buildFirmware(ctx context.Context, client *dagger.Client) error {
+	myContainer, buildSteps, err := opts.prepareContainer(ctx, client)
+	if err != nil {
+		return err
 	}
 
 	// Execute build commands
-	for step := range buildSteps {
-		myContainer, err = myContainer.
-			WithExec(buildSteps[step]).
-			Sync(ctx)
-		if err != nil {
-			slog.Error(
-				"Failed to build linux",
-				slog.Any("error", err),
-			)
+	myContainer, err = opts.runBuildSteps(ctx, myContainer, buildSteps)
+	if err != nil {
+		slog.Error(
+			"Failed to build linux",
+			slog.Any("error", err),
+		)
 
-			return fmt.Errorf("linux build failed: %w", err)
-		}
+		return fmt.Errorf("linux build failed: %w", err)
+	}
+
+	// Check that requested Kconfig options made it into '.config'
+	err = opts.checkKconfig(ctx, myContainer, opts.DefconfigPath, opts.OutputDir, nil)
+	if err != nil {
+		return err
+	}
+
+	// Check that the defconfig is not out of date
+	//   the defconfig was moved into the configs directory during the build
+	defconfigSteps := [][]string{{"make", filepath.Base(opts.DefconfigPath)}}
+
+	err = opts.checkDefconfig(ctx, myContainer, opts.DefconfigPath, defconfigSteps, opts.runBuildSteps)
+	if err != nil {
+		return err
 	}
 
 	// Extract artifacts
-	return container.GetArtifacts(ctx, myContainer, opts.GetArtifacts())
+	return exportArtifacts(ctx, myContainer, opts.GetArtifacts())
 }
 
 // ValidateLinuxDefconfigFilename checks if defconfig filename is valid
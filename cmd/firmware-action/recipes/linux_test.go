@@ -31,9 +31,9 @@ func TestLinux(t *testing.T) {
 	linuxOpts := LinuxOpts{
 		CommonOpts: CommonOpts{
 			OutputDir: "output",
-			ContainerOutputFiles: []string{
-				"vmlinux",
-				"defconfig",
+			ContainerOutputFiles: []ContainerOutputFile{
+				{From: "vmlinux"},
+				{From: "defconfig"},
 			},
 		},
 		DefconfigPath: "custom_defconfig",
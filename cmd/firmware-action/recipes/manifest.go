@@ -0,0 +1,178 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / manifest
+package recipes
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"dagger.io/dagger"
+	"github.com/9elements/firmware-action/cmd/firmware-action/container"
+	"github.com/9elements/firmware-action/cmd/firmware-action/filesystem"
+)
+
+// ManifestFileName is name of the manifest file written into output directory of each module
+const ManifestFileName = "manifest.json"
+
+// FirmwareActionVersion is version of firmware-action recorded in manifests, set from main
+var FirmwareActionVersion = "dev"
+
+// Manifest describes artifacts produced by a module and where they came from
+// ANCHOR: Manifest
+type Manifest struct {
+	// ID of the module in configuration file
+	ModuleID string `json:"module_id"`
+
+	// Type of the module, for example 'coreboot' or 'edk2'
+	RecipeType string `json:"recipe_type"`
+
+	// Container image used for build, with digest if available
+	Image string `json:"image"`
+
+	// Output of 'git describe' for 'repo_path', empty if not a git repository
+	SourceVersion string `json:"source_version"`
+
+	// Version of firmware-action used for build
+	FirmwareActionVersion string `json:"firmware_action_version"`
+
+	// All files in output directory
+	Files []ManifestFile `json:"files"`
+}
+
+// ManifestFile describes a single file in output directory
+type ManifestFile struct {
+	// Path relative to output directory
+	Path string `json:"path"`
+
+	// Size in bytes
+	Size int64 `json:"size"`
+
+	SHA256 string `json:"sha256"`
+	SHA512 string `json:"sha512"`
+}
+
+// ANCHOR_END: Manifest
+
+// NewManifest creates manifest for the module, listing all files in 'outputDir'
+// (the staging directory, artifacts are described before they replace the output directory)
+func NewManifest(ctx context.Context, client *dagger.Client, target string, outputDir string, config *Config) (Manifest, error) {
+	module := config.AllModules()[target]
+
+	sourceVersion, err := filesystem.GitDescribe(module.GetRepoPath())
+	if err != nil {
+		slog.Warn(
+			fmt.Sprintf("Failed to get git version of '%s', it will be missing in the manifest", module.GetRepoPath()),
+			slog.Any("error", err),
+		)
+
+		sourceVersion = ""
+	}
+
+	files, err := hashOutputFiles(outputDir)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	return Manifest{
+		ModuleID:              target,
+		RecipeType:            config.ModuleType(target),
+		Image:                 container.ImageReference(ctx, client, module.GetSdkURL()),
+		SourceVersion:         sourceVersion,
+		FirmwareActionVersion: FirmwareActionVersion,
+		Files:                 files,
+	}, nil
+}
+
+// hashOutputFiles returns description with checksums of all files in output directory
+// (except the manifest and provenance)
+func hashOutputFiles(outputDir string) ([]ManifestFile, error) {
+	files := []ManifestFile{}
+
+	err := filepath.WalkDir(outputDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(outputDir, path)
+		if err != nil {
+			return err
+		}
+
+		if relPath == ManifestFileName || relPath == ProvenanceFileName {
+			return nil
+		}
+
+		file, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+
+		file.Path = filepath.ToSlash(relPath)
+		files = append(files, file)
+
+		return nil
+	})
+
+	return files, err
+}
+
+// hashFile returns size and checksums of the file
+func hashFile(path string) (ManifestFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	defer file.Close()
+
+	hash256 := sha256.New()
+	hash512 := sha512.New()
+
+	size, err := io.Copy(io.MultiWriter(hash256, hash512), file)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+
+	return ManifestFile{
+		Size:   size,
+		SHA256: hex.EncodeToString(hash256.Sum(nil)),
+		SHA512: hex.EncodeToString(hash512.Sum(nil)),
+	}, nil
+}
+
+// WriteManifest writes manifest into output directory of the module
+func WriteManifest(outputDir string, manifest Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	manifestPath := filepath.Join(outputDir, ManifestFileName)
+
+	err = os.WriteFile(manifestPath, append(data, '\n'), 0o644)
+	if err != nil {
+		slog.Error(
+			fmt.Sprintf("Failed to write manifest '%s'", manifestPath),
+			slog.Any("error", err),
+		)
+
+		return err
+	}
+
+	slog.Info(fmt.Sprintf("Manifest written to '%s'", manifestPath))
+
+	return nil
+}
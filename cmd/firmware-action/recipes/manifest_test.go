@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / manifest
+package recipes
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashOutputFiles(t *testing.T) {
+	outputDir := t.TempDir()
+
+	assert.NoError(t, os.WriteFile(filepath.Join(outputDir, "coreboot.rom"), []byte("test"), 0o644))
+	assert.NoError(t, os.MkdirAll(filepath.Join(outputDir, "Build"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(outputDir, "Build", "OVMF.fd"), []byte{}, 0o644))
+	// Manifest itself must not be listed
+	assert.NoError(t, os.WriteFile(filepath.Join(outputDir, ManifestFileName), []byte("{}"), 0o644))
+
+	files, err := hashOutputFiles(outputDir)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []ManifestFile{
+		{
+			Path:   "Build/OVMF.fd",
+			Size:   0,
+			SHA256: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			SHA512: "cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e",
+		},
+		{
+			Path:   "coreboot.rom",
+			Size:   4,
+			SHA256: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+			SHA512: "ee26b0dd4af7e749aa1a8ee3c10ae9923f618980772e473f8819a5d4940e0db27ac185f8a0e1d5f84f88bc887fd67b143732c304cc5fa9ad8e6f57f50028a8ff",
+		},
+	}, files)
+}
+
+func TestWriteManifest(t *testing.T) {
+	outputDir := t.TempDir()
+
+	manifest := Manifest{
+		ModuleID:              "coreboot-example",
+		RecipeType:            "coreboot",
+		Image:                 "ghcr.io/9elements/firmware-action/coreboot_4.19:main",
+		SourceVersion:         "4.19",
+		FirmwareActionVersion: "dev",
+		Files:                 []ManifestFile{{Path: "coreboot.rom", Size: 4}},
+	}
+	assert.NoError(t, WriteManifest(outputDir, manifest))
+
+	data, err := os.ReadFile(filepath.Join(outputDir, ManifestFileName))
+	assert.NoError(t, err)
+
+	var readManifest Manifest
+
+	assert.NoError(t, json.Unmarshal(data, &readManifest))
+	assert.Equal(t, manifest, readManifest)
+}
@@ -0,0 +1,201 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / patches
+package recipes
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// patchesContainerDir is directory in container into which patches are copied
+const patchesContainerDir = "/tmp/firmware-action-patches"
+
+// patchSeriesFile is the name of quilt series file, it lists patches in the order of application
+const patchSeriesFile = "series"
+
+// ErrPatchFailed is raised when patch could not be applied
+var ErrPatchFailed = errors.New("failed to apply patch")
+
+// patchApplyScript applies patch '$2' with strip level '$1' to the working directory, 'git apply' is
+// preferred as it handles binary diffs and renames of git-format-patch
+// Plain 'patch' is used only when 'git' is missing in the container or the working directory is not
+// a git repository, never to retry a patch refused by 'git apply'. Fuzz is disabled, so that 'patch'
+// is as strict as 'git apply' and can't apply a hunk at wrong place
+const patchApplyScript = `if command -v git >/dev/null 2>&1 && git rev-parse --is-inside-work-tree >/dev/null 2>&1; then
+	git apply --verbose -p"$1" "$2"
+else
+	patch --batch --forward --fuzz=0 -p"$1" -i "$2"
+fi`
+
+// patch is a single patch of the patch series
+type patch struct {
+	// Path to the patch on host
+	Path string
+
+	// Number of leading path components to strip, the '-p' option of 'patch'
+	Strip int
+}
+
+// parseSeries returns patches listed in quilt series file, in order, relative to 'dir'
+// Empty lines and comments are skipped, the only supported option is '-p'
+func parseSeries(content string, dir string) []patch {
+	patches := []patch{}
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		item := patch{Path: filepath.Join(dir, fields[0]), Strip: 1}
+		for _, option := range fields[1:] {
+			var strip int
+			if _, err := fmt.Sscanf(option, "-p%d", &strip); err == nil {
+				item.Strip = strip
+			}
+		}
+
+		patches = append(patches, item)
+	}
+
+	return patches
+}
+
+// patchesInDir returns patches in directory, either in order given by quilt 'series' file,
+// or all '*.patch' and '*.diff' files sorted by name (such as output of 'git format-patch')
+func patchesInDir(dir string) ([]patch, error) {
+	content, err := os.ReadFile(filepath.Join(dir, patchSeriesFile))
+	if err == nil {
+		return parseSeries(string(content), dir), nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	// Entries are sorted by name
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	patches := []patch{}
+
+	for _, entry := range entries {
+		extension := filepath.Ext(entry.Name())
+		if entry.Type().IsRegular() && (extension == ".patch" || extension == ".diff") {
+			patches = append(patches, patch{Path: filepath.Join(dir, entry.Name()), Strip: 1})
+		}
+	}
+
+	return patches, nil
+}
+
+// patchSeries returns all patches of the module in order of application, directories in 'patches'
+// are expanded into the patches they contain
+func (opts CommonOpts) patchSeries() ([]patch, error) {
+	patches := []patch{}
+
+	for _, path := range opts.Patches {
+		info, err := os.Stat(path)
+		if err != nil {
+			slog.Error(
+				fmt.Sprintf("Patch '%s' was not found", path),
+				slog.String("suggestion", "each item in 'patches' must be either patch file or directory with patches"),
+				slog.Any("error", err),
+			)
+
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			patches = append(patches, patch{Path: path, Strip: 1})
+
+			continue
+		}
+
+		dirPatches, err := patchesInDir(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(dirPatches) == 0 {
+			slog.Warn(
+				fmt.Sprintf("Directory '%s' in 'patches' does not contain any patches", path),
+				slog.String("suggestion", "directory must contain quilt 'series' file, or '*.patch' and '*.diff' files"),
+			)
+		}
+
+		patches = append(patches, dirPatches...)
+	}
+
+	return patches, nil
+}
+
+// applyPatches copies all patches into the container and applies them one after another to the
+// repository, before any build step is executed
+func (opts CommonOpts) applyPatches(ctx context.Context, client *dagger.Client, myContainer *dagger.Container) (*dagger.Container, error) {
+	patches, err := opts.patchSeries()
+	if err != nil || len(patches) == 0 {
+		return myContainer, err
+	}
+
+	pwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	for index, item := range patches {
+		if _, err := os.Stat(item.Path); err != nil {
+			slog.Error(
+				fmt.Sprintf("Patch '%s' was not found", item.Path),
+				slog.String("suggestion", "Double check the patch series, every listed patch must exist"),
+				slog.Any("error", err),
+			)
+
+			return nil, err
+		}
+
+		containerPath := filepath.Join(patchesContainerDir, fmt.Sprintf("%02d_%s", index, filepath.Base(item.Path)))
+		myContainer = myContainer.WithFile(containerPath, client.Host().File(filepath.Join(pwd, item.Path)))
+
+		slog.Info(fmt.Sprintf("Applying patch %d of %d: '%s'", index+1, len(patches), item.Path))
+
+		applyCmd := []string{"sh", "-c", patchApplyScript, "apply-patch", fmt.Sprint(item.Strip), containerPath}
+		result, err := opts.runStep(ctx, myContainer, PhaseContainerSetup, fmt.Sprintf("apply patch %s", item.Path), [][]string{applyCmd})
+
+		if err != nil {
+			err = fmt.Errorf("%w '%s': %w", ErrPatchFailed, item.Path, err)
+
+			// The reason is in the output of 'git apply' or 'patch'
+			var execErr *dagger.ExecError
+			if errors.As(err, &execErr) {
+				err = fmt.Errorf("%w\n%s", err, strings.TrimSpace(execErr.Stderr+"\n"+execErr.Stdout))
+			}
+
+			slog.Error(
+				fmt.Sprintf("Failed to apply patch '%s'", item.Path),
+				slog.String("suggestion", "the patch does not apply cleanly to the repository, rebase the patch or check that it is not already applied"),
+				slog.Any("error", err),
+			)
+
+			return nil, err
+		}
+
+		myContainer = result
+	}
+
+	return myContainer, nil
+}
@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / patches
+package recipes
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSeries(t *testing.T) {
+	content := `# quilt series
+0001-first.patch
+  0002-second.patch -p0   # legacy patch
+
+0003-third.patch -p1
+`
+	want := []patch{
+		{Path: filepath.Join("patches", "0001-first.patch"), Strip: 1},
+		{Path: filepath.Join("patches", "0002-second.patch"), Strip: 0},
+		{Path: filepath.Join("patches", "0003-third.patch"), Strip: 1},
+	}
+
+	assert.Equal(t, want, parseSeries(content, "patches"))
+}
+
+func TestPatchSeries(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// git-format-patch series, other files are ignored
+	formatPatchDir := filepath.Join(tmpDir, "format-patch")
+	assert.NoError(t, os.Mkdir(formatPatchDir, 0o755))
+	for _, name := range []string{"0002-b.patch", "0001-a.patch", "0003-c.diff", "README.md"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(formatPatchDir, name), []byte{}, 0o644))
+	}
+
+	// quilt series, order of 'series' wins over names
+	quiltDir := filepath.Join(tmpDir, "quilt")
+	assert.NoError(t, os.Mkdir(quiltDir, 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(quiltDir, "series"), []byte("z.patch\na.patch -p0\n"), 0o644))
+
+	singlePatch := filepath.Join(tmpDir, "single.patch")
+	assert.NoError(t, os.WriteFile(singlePatch, []byte{}, 0o644))
+
+	opts := CommonOpts{Patches: []string{formatPatchDir, quiltDir, singlePatch}}
+	patches, err := opts.patchSeries()
+	assert.NoError(t, err)
+	assert.Equal(t, []patch{
+		{Path: filepath.Join(formatPatchDir, "0001-a.patch"), Strip: 1},
+		{Path: filepath.Join(formatPatchDir, "0002-b.patch"), Strip: 1},
+		{Path: filepath.Join(formatPatchDir, "0003-c.diff"), Strip: 1},
+		{Path: filepath.Join(quiltDir, "z.patch"), Strip: 1},
+		{Path: filepath.Join(quiltDir, "a.patch"), Strip: 0},
+		{Path: singlePatch, Strip: 1},
+	}, patches)
+
+	// Missing patch
+	opts = CommonOpts{Patches: []string{filepath.Join(tmpDir, "missing.patch")}}
+	_, err = opts.patchSeries()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestCommonOptsGetSourcesPatches(t *testing.T) {
+	opts := CommonOpts{RepoPath: "coreboot", Patches: []string{"patches/"}}
+
+	assert.Equal(t, []string{"coreboot", "patches/"}, opts.GetSources())
+}
+
+func TestPatchApplyScript(t *testing.T) {
+	for _, tool := range []string{"sh", "git", "patch"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("'%s' is not installed", tool)
+		}
+	}
+
+	const original = "line 1\nline 2\nline 3\nline 4\nline 5\nline 6\nline 7\n"
+	// Context does not match the original ('line 2' is different), 'patch' with default fuzz
+	// would apply it anyway
+	const mismatchedPatch = `--- a/file.txt
++++ b/file.txt
+@@ -1,6 +1,6 @@
+ line 1
+ line two
+ line 3
+-line 4
++line four
+ line 5
+ line 6
+`
+	const goodPatch = `--- a/file.txt
++++ b/file.txt
+@@ -3,3 +3,3 @@
+ line 3
+-line 4
++line four
+ line 5
+`
+
+	testCases := []struct {
+		name    string
+		gitRepo bool
+		patch   string
+		wantErr bool
+		want    string
+	}{
+		{
+			name:    "git repository",
+			gitRepo: true,
+			patch:   goodPatch,
+			want:    "line 1\nline 2\nline 3\nline four\nline 5\nline 6\nline 7\n",
+		},
+		{
+			name:    "git repository, patch refused by git is not retried with patch",
+			gitRepo: true,
+			patch:   mismatchedPatch,
+			wantErr: true,
+			want:    original,
+		},
+		{
+			name:  "not a git repository",
+			patch: goodPatch,
+			want:  "line 1\nline 2\nline 3\nline four\nline 5\nline 6\nline 7\n",
+		},
+		{
+			name:    "not a git repository, no fuzz",
+			patch:   mismatchedPatch,
+			wantErr: true,
+			want:    original,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			workDir := filepath.Join(tmpDir, "repo")
+			assert.NoError(t, os.Mkdir(workDir, 0o755))
+			assert.NoError(t, os.WriteFile(filepath.Join(workDir, "file.txt"), []byte(original), 0o644))
+
+			if tc.gitRepo {
+				assert.NoError(t, exec.Command("git", "-C", workDir, "init", "--quiet").Run())
+			}
+
+			patchPath := filepath.Join(tmpDir, "change.patch")
+			assert.NoError(t, os.WriteFile(patchPath, []byte(tc.patch), 0o644))
+
+			cmd := exec.Command("sh", "-c", patchApplyScript, "apply-patch", "1", patchPath)
+			cmd.Dir = workDir
+			cmd.Env = append(os.Environ(), "GIT_CEILING_DIRECTORIES="+tmpDir)
+			output, err := cmd.CombinedOutput()
+
+			if tc.wantErr {
+				assert.Error(t, err)
+				assert.NotEmpty(t, output, "reason of the failure must be printed")
+			} else {
+				assert.NoError(t, err, string(output))
+			}
+
+			content, err := os.ReadFile(filepath.Join(workDir, "file.txt"))
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, string(content))
+		})
+	}
+}
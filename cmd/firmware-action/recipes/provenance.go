@@ -0,0 +1,595 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / provenance
+package recipes
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"dagger.io/dagger"
+	"github.com/9elements/firmware-action/cmd/firmware-action/container"
+	"github.com/9elements/firmware-action/cmd/firmware-action/filesystem"
+)
+
+// Errors for provenance
+var (
+	ErrProvenanceKey       = errors.New("unsupported or invalid key")
+	ErrProvenanceSignature = errors.New("provenance signature verification failed")
+	ErrProvenanceMismatch  = errors.New("artifact does not match provenance")
+)
+
+// provenanceKeySuggestion is suggestion logged when the key for signing provenance can't be used
+const provenanceKeySuggestion = "the key must be Ed25519 or ECDSA private key in PKCS #8 PEM format, for example generated with 'openssl genpkey -algorithm ed25519 -out key.pem'"
+
+// ProvenanceFileName is name of the provenance file written into output directory of each module
+const ProvenanceFileName = "provenance.intoto.json"
+
+const (
+	inTotoStatementType   = "https://in-toto.io/Statement/v1"
+	inTotoPayloadType     = "application/vnd.in-toto+json"
+	slsaProvenanceType    = "https://slsa.dev/provenance/v1"
+	firmwareActionBuilder = "https://github.com/9elements/firmware-action"
+)
+
+// in-toto statement with SLSA provenance predicate
+//   https://github.com/in-toto/attestation/blob/main/spec/v1/statement.md
+//   https://slsa.dev/spec/v1.0/provenance
+
+type inTotoStatement struct {
+	Type          string               `json:"_type"`
+	Subject       []resourceDescriptor `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     slsaProvenance       `json:"predicate"`
+}
+
+type resourceDescriptor struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest"`
+}
+
+type slsaProvenance struct {
+	BuildDefinition slsaBuildDefinition `json:"buildDefinition"`
+	RunDetails      slsaRunDetails      `json:"runDetails"`
+}
+
+type slsaBuildDefinition struct {
+	BuildType            string               `json:"buildType"`
+	ExternalParameters   provenanceParameters `json:"externalParameters"`
+	ResolvedDependencies []resourceDescriptor `json:"resolvedDependencies"`
+}
+
+type provenanceParameters struct {
+	Target string          `json:"target"`
+	Flags  provenanceFlags `json:"flags"`
+	Config json.RawMessage `json:"config"`
+}
+
+// provenanceFlags are command line flags which affect the build result
+// Flags which only help with debugging, and paths on host (such as the signing key), are deliberately left out
+type provenanceFlags struct {
+	Hermetic bool `json:"hermetic"`
+}
+
+// newProvenanceFlags picks flags relevant for provenance out of build options
+func newProvenanceFlags(opts BuildOptions) provenanceFlags {
+	return provenanceFlags{
+		Hermetic: opts.Hermetic,
+	}
+}
+
+type slsaRunDetails struct {
+	Builder  slsaBuilder  `json:"builder"`
+	Metadata slsaMetadata `json:"metadata"`
+}
+
+type slsaBuilder struct {
+	ID                  string               `json:"id"`
+	Version             map[string]string    `json:"version"`
+	BuilderDependencies []resourceDescriptor `json:"builderDependencies"`
+}
+
+type slsaMetadata struct {
+	FinishedOn string `json:"finishedOn"`
+}
+
+// DSSE envelope, used to (optionally) sign the statement
+//   https://github.com/secure-systems-lab/dsse/blob/master/envelope.md
+
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// dssePAE returns DSSE pre-authentication encoding of the payload, which is what gets signed
+func dssePAE(payloadType string, payload []byte) []byte {
+	return fmt.Appendf(nil, "DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload)
+}
+
+// fileDescriptors returns resource descriptors with SHA-256 digests of all files in the path (file or directory)
+func fileDescriptors(path string) ([]resourceDescriptor, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+
+	if info.IsDir() {
+		relPaths, err := listOutputFiles(path)
+		if err != nil {
+			return nil, err
+		}
+
+		files = []string{}
+		for _, relPath := range relPaths {
+			files = append(files, filepath.Join(path, relPath))
+		}
+	}
+
+	descriptors := []resourceDescriptor{}
+
+	for _, file := range files {
+		hashes, err := hashFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		descriptors = append(descriptors, resourceDescriptor{
+			Name:   filepath.ToSlash(file),
+			Digest: map[string]string{"sha256": hashes.SHA256},
+		})
+	}
+
+	return descriptors, nil
+}
+
+// artifactDescriptors returns resource descriptors of all artifacts in output directory,
+// metadata generated by firmware-action are not included
+func artifactDescriptors(outputDir string) ([]resourceDescriptor, error) {
+	files, err := hashOutputFiles(outputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	descriptors := []resourceDescriptor{}
+
+	for _, file := range files {
+		if file.Path == SBOMFileName {
+			continue
+		}
+
+		descriptors = append(descriptors, resourceDescriptor{
+			Name:   file.Path,
+			Digest: map[string]string{"sha256": file.SHA256},
+		})
+	}
+
+	return descriptors, nil
+}
+
+// provenanceMaterials returns everything the module was built from: repository commit, input files,
+// blobs and artifacts of modules it depends on
+func provenanceMaterials(target string, config *Config) ([]resourceDescriptor, error) {
+	modules := config.AllModules()
+	module := modules[target]
+
+	materials := []resourceDescriptor{}
+
+	// Repository
+	commit, err := filesystem.GitCommitHash(module.GetRepoPath())
+	if err != nil {
+		slog.Warn(
+			fmt.Sprintf("Failed to get git commit of '%s', it will be missing in the provenance", module.GetRepoPath()),
+			slog.Any("error", err),
+		)
+	} else {
+		materials = append(materials, resourceDescriptor{
+			Name:   module.GetRepoPath(),
+			Digest: map[string]string{"gitCommit": commit},
+		})
+	}
+
+	// Input files, directories and blobs
+	//   the first of sources is the repository
+	paths := module.GetSources()
+	if len(paths) > 0 {
+		paths = paths[1:]
+	}
+
+	for _, blob := range module.sbomBlobs() {
+		paths = append(paths, blob.Path)
+	}
+
+	seen := []string{}
+
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil || path == "" || slices.Contains(seen, absPath) {
+			continue
+		}
+
+		seen = append(seen, absPath)
+
+		descriptors, err := fileDescriptors(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		materials = append(materials, descriptors...)
+	}
+
+	// Artifacts of dependencies
+	for _, dependency := range module.GetDepends() {
+		outputDir := modules[dependency].GetOutputDir()
+
+		descriptors, err := artifactDescriptors(outputDir)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range descriptors {
+			descriptors[i].Name = filepath.ToSlash(filepath.Join(outputDir, descriptors[i].Name))
+		}
+
+		materials = append(materials, descriptors...)
+	}
+
+	return materials, nil
+}
+
+// NewProvenance creates in-toto statement with SLSA provenance of the module, artifacts in
+// 'outputDir' (the staging directory) are the subjects
+// Must be called after successful build
+func NewProvenance(ctx context.Context, client *dagger.Client, target string, outputDir string, config *Config) (inTotoStatement, error) {
+	module := config.AllModules()[target]
+
+	subjects, err := artifactDescriptors(outputDir)
+	if err != nil {
+		return inTotoStatement{}, err
+	}
+
+	materials, err := provenanceMaterials(target, config)
+	if err != nil {
+		return inTotoStatement{}, err
+	}
+
+	// Configuration snapshot, the same as saved for change detection
+	configSnapshot, err := json.Marshal(config)
+	if err != nil {
+		return inTotoStatement{}, err
+	}
+
+	// Builder
+	builderDependencies := []resourceDescriptor{}
+
+	image := container.ImageReference(ctx, client, module.GetSdkURL())
+
+	imageDescriptor := resourceDescriptor{URI: image, Digest: map[string]string{}}
+	if _, digest, found := bytes.Cut([]byte(image), []byte("@sha256:")); found {
+		imageDescriptor.Digest["sha256"] = string(digest)
+	}
+
+	builderDependencies = append(builderDependencies, imageDescriptor)
+
+	return inTotoStatement{
+		Type:          inTotoStatementType,
+		Subject:       subjects,
+		PredicateType: slsaProvenanceType,
+		Predicate: slsaProvenance{
+			BuildDefinition: slsaBuildDefinition{
+				BuildType: fmt.Sprintf("%s/buildtypes/%s/v1", firmwareActionBuilder, config.ModuleType(target)),
+				ExternalParameters: provenanceParameters{
+					Target: target,
+					Flags:  newProvenanceFlags(GetBuildOptions(ctx)),
+					Config: configSnapshot,
+				},
+				ResolvedDependencies: materials,
+			},
+			RunDetails: slsaRunDetails{
+				Builder: slsaBuilder{
+					ID:                  firmwareActionBuilder,
+					Version:             map[string]string{"firmware-action": FirmwareActionVersion},
+					BuilderDependencies: builderDependencies,
+				},
+				Metadata: slsaMetadata{
+					FinishedOn: time.Now().UTC().Format(time.RFC3339),
+				},
+			},
+		},
+	}, nil
+}
+
+// readPEM reads the first PEM block from file
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM data found in '%s'", ErrProvenanceKey, path)
+	}
+
+	return block, nil
+}
+
+// keyID returns identifier of the public key (SHA-256 of its PKIX encoding)
+func keyID(publicKey crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(der)
+
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// readPrivateKey reads private key used to sign provenance (PKCS #8 PEM, Ed25519 or ECDSA)
+func readPrivateKey(keyPath string) (crypto.Signer, error) {
+	block, err := readPEM(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrProvenanceKey, err)
+	}
+
+	switch privateKey := key.(type) {
+	case ed25519.PrivateKey:
+		return privateKey, nil
+	case *ecdsa.PrivateKey:
+		return privateKey, nil
+	default:
+		return nil, fmt.Errorf("%w: only Ed25519 and ECDSA keys are supported", ErrProvenanceKey)
+	}
+}
+
+// CheckProvenanceKey checks that the private key can be used to sign provenance
+// Called before anything is built, so that a bad key does not fail the build only after it is done
+func CheckProvenanceKey(keyPath string) error {
+	_, err := readPrivateKey(keyPath)
+	if err != nil {
+		slog.Error(
+			fmt.Sprintf("Key '%s' can't be used to sign provenance", keyPath),
+			slog.String("suggestion", provenanceKeySuggestion),
+			slog.Any("error", err),
+		)
+	}
+
+	return err
+}
+
+// signPAE signs the pre-authentication encoding with private key (PKCS #8 PEM, Ed25519 or ECDSA)
+func signPAE(keyPath string, pae []byte) (dsseSignature, error) {
+	key, err := readPrivateKey(keyPath)
+	if err != nil {
+		return dsseSignature{}, err
+	}
+
+	var signature []byte
+
+	switch privateKey := key.(type) {
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(privateKey, pae)
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(pae)
+
+		signature, err = ecdsa.SignASN1(rand.Reader, privateKey, digest[:])
+		if err != nil {
+			return dsseSignature{}, err
+		}
+	}
+
+	id, err := keyID(key.Public())
+	if err != nil {
+		return dsseSignature{}, err
+	}
+
+	return dsseSignature{KeyID: id, Sig: base64.StdEncoding.EncodeToString(signature)}, nil
+}
+
+// verifyPAE checks that the signature of pre-authentication encoding was made by the public key (PKIX PEM)
+func verifyPAE(keyPath string, pae []byte, signature dsseSignature) (bool, error) {
+	block, err := readPEM(keyPath)
+	if err != nil {
+		return false, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return false, fmt.Errorf("%w: %w", ErrProvenanceKey, err)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature.Sig)
+	if err != nil {
+		return false, nil //nolint:nilerr // malformed signature is simply not valid
+	}
+
+	switch publicKey := key.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(publicKey, pae, sig), nil
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(pae)
+
+		return ecdsa.VerifyASN1(publicKey, digest[:], sig), nil
+	default:
+		return false, fmt.Errorf("%w: only Ed25519 and ECDSA keys are supported", ErrProvenanceKey)
+	}
+}
+
+// WriteProvenance writes the statement into output directory of the module as DSSE envelope,
+// signed if path to private key is given
+func WriteProvenance(outputDir string, statement inTotoStatement, keyPath string) error {
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return err
+	}
+
+	envelope := dsseEnvelope{
+		PayloadType: inTotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []dsseSignature{},
+	}
+
+	if keyPath != "" {
+		signature, err := signPAE(keyPath, dssePAE(inTotoPayloadType, payload))
+		if err != nil {
+			slog.Error(
+				fmt.Sprintf("Failed to sign provenance with key '%s'", keyPath),
+				slog.String("suggestion", provenanceKeySuggestion),
+				slog.Any("error", err),
+			)
+
+			return err
+		}
+
+		envelope.Signatures = append(envelope.Signatures, signature)
+	}
+
+	data, err := json.MarshalIndent(envelope, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	provenancePath := filepath.Join(outputDir, ProvenanceFileName)
+
+	err = os.WriteFile(provenancePath, append(data, '\n'), 0o644)
+	if err != nil {
+		slog.Error(
+			fmt.Sprintf("Failed to write provenance '%s'", provenancePath),
+			slog.Any("error", err),
+		)
+
+		return err
+	}
+
+	slog.Info(fmt.Sprintf("Provenance written to '%s'", provenancePath))
+
+	return nil
+}
+
+// VerifyProvenance checks that the artifact is a subject of the provenance, and if public key is given,
+// that the provenance is signed with matching private key
+func VerifyProvenance(artifactPath string, provenancePath string, keyPath string) error {
+	if provenancePath == "" {
+		provenancePath = filepath.Join(filepath.Dir(artifactPath), ProvenanceFileName)
+	}
+
+	data, err := os.ReadFile(provenancePath)
+	if err != nil {
+		slog.Error(
+			fmt.Sprintf("Failed to read provenance '%s'", provenancePath),
+			slog.String("suggestion", "specify path to the provenance with '--provenance'"),
+			slog.Any("error", err),
+		)
+
+		return err
+	}
+
+	var envelope dsseEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return err
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return err
+	}
+
+	// Signature
+	if keyPath != "" {
+		verified := false
+
+		for _, signature := range envelope.Signatures {
+			valid, err := verifyPAE(keyPath, dssePAE(envelope.PayloadType, payload), signature)
+			if err != nil {
+				return err
+			}
+
+			verified = verified || valid
+		}
+
+		if !verified {
+			err = fmt.Errorf("%w: no valid signature for key '%s'", ErrProvenanceSignature, keyPath)
+			slog.Error(
+				"Provenance is not signed with the given key",
+				slog.String("suggestion", "make sure to use public key matching the private key used to sign the provenance"),
+				slog.Any("error", err),
+			)
+
+			return err
+		}
+
+		slog.Info("Provenance signature is valid")
+	} else {
+		slog.Warn(
+			"Provenance signature was not verified",
+			slog.String("suggestion", "specify public key with '--key' to verify the signature"),
+		)
+	}
+
+	// Artifact
+	var statement inTotoStatement
+	if err := json.Unmarshal(payload, &statement); err != nil {
+		return err
+	}
+
+	hashes, err := hashFile(artifactPath)
+	if err != nil {
+		return err
+	}
+
+	for _, subject := range statement.Subject {
+		if filepath.Base(subject.Name) == filepath.Base(artifactPath) && subject.Digest["sha256"] == hashes.SHA256 {
+			slog.Info(
+				fmt.Sprintf("Artifact '%s' matches provenance", artifactPath),
+				slog.String("target", statement.Predicate.BuildDefinition.ExternalParameters.Target),
+				slog.String("sha256", hashes.SHA256),
+			)
+
+			return nil
+		}
+	}
+
+	err = fmt.Errorf("%w: '%s' with sha256 '%s' is not listed in '%s'", ErrProvenanceMismatch, artifactPath, hashes.SHA256, provenancePath)
+	slog.Error(
+		"Artifact does not match provenance",
+		slog.String("suggestion", "the artifact was modified after the build, or it comes from a different build"),
+		slog.Any("error", err),
+	)
+
+	return err
+}
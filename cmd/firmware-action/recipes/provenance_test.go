@@ -0,0 +1,208 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / provenance
+package recipes
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDssePAE(t *testing.T) {
+	// Example from DSSE specification
+	assert.Equal(t, "DSSEv1 29 http://example.com/HelloWorld 11 hello world", string(dssePAE("http://example.com/HelloWorld", []byte("hello world"))))
+}
+
+// writeKeyPair writes PEM encoded private and public key into directory, returns their paths
+func writeKeyPair(t *testing.T, dir string, privateKey crypto.Signer) (string, string) {
+	t.Helper()
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	assert.NoError(t, err)
+
+	publicDER, err := x509.MarshalPKIXPublicKey(privateKey.Public())
+	assert.NoError(t, err)
+
+	privatePath := filepath.Join(dir, "key.pem")
+	publicPath := filepath.Join(dir, "key.pub")
+
+	assert.NoError(t, os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0o600))
+	assert.NoError(t, os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0o644))
+
+	return privatePath, publicPath
+}
+
+func TestProvenance(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Chdir(tmpDir)
+
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.MkdirAll("ed25519", 0o755))
+	assert.NoError(t, os.MkdirAll("ecdsa", 0o755))
+	assert.NoError(t, os.MkdirAll("other", 0o755))
+
+	ed25519Private, ed25519Public := writeKeyPair(t, "ed25519", ed25519Key)
+	ecdsaPrivate, ecdsaPublic := writeKeyPair(t, "ecdsa", ecdsaKey)
+	_, otherPublic := writeKeyPair(t, "other", otherKey)
+
+	testCases := []struct {
+		name       string
+		signKey    string
+		verifyKey  string
+		tamper     bool
+		wantErr    error
+		wantSigned bool
+	}{
+		{
+			name:       "ed25519",
+			signKey:    ed25519Private,
+			verifyKey:  ed25519Public,
+			wantSigned: true,
+		},
+		{
+			name:       "ecdsa",
+			signKey:    ecdsaPrivate,
+			verifyKey:  ecdsaPublic,
+			wantSigned: true,
+		},
+		{
+			name:    "unsigned without key",
+			signKey: "",
+		},
+		{
+			name:      "unsigned with key",
+			signKey:   "",
+			verifyKey: ed25519Public,
+			wantErr:   ErrProvenanceSignature,
+		},
+		{
+			name:       "wrong key",
+			signKey:    ed25519Private,
+			verifyKey:  otherPublic,
+			wantErr:    ErrProvenanceSignature,
+			wantSigned: true,
+		},
+		{
+			name:       "modified artifact",
+			signKey:    ed25519Private,
+			verifyKey:  ed25519Public,
+			tamper:     true,
+			wantErr:    ErrProvenanceMismatch,
+			wantSigned: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			outputDir := t.TempDir()
+			artifact := filepath.Join(outputDir, "coreboot.rom")
+			assert.NoError(t, os.WriteFile(artifact, []byte("test"), 0o644))
+
+			subjects, err := artifactDescriptors(outputDir)
+			assert.NoError(t, err)
+
+			statement := inTotoStatement{
+				Type:          inTotoStatementType,
+				Subject:       subjects,
+				PredicateType: slsaProvenanceType,
+			}
+			assert.NoError(t, WriteProvenance(outputDir, statement, tc.signKey))
+
+			envelope, err := os.ReadFile(filepath.Join(outputDir, ProvenanceFileName))
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantSigned, len(signaturesOf(t, envelope)) > 0)
+
+			if tc.tamper {
+				assert.NoError(t, os.WriteFile(artifact, []byte("tampered"), 0o644))
+			}
+
+			err = VerifyProvenance(artifact, "", tc.verifyKey)
+			assert.ErrorIs(t, err, tc.wantErr)
+		})
+	}
+}
+
+// signaturesOf returns signatures in DSSE envelope
+func signaturesOf(t *testing.T, data []byte) []dsseSignature {
+	t.Helper()
+
+	var envelope dsseEnvelope
+
+	assert.NoError(t, json.Unmarshal(data, &envelope))
+
+	return envelope.Signatures
+}
+
+func TestArtifactDescriptors(t *testing.T) {
+	outputDir := t.TempDir()
+
+	for _, file := range []string{"coreboot.rom", ManifestFileName, SBOMFileName, ProvenanceFileName} {
+		assert.NoError(t, os.WriteFile(filepath.Join(outputDir, file), []byte("test"), 0o644))
+	}
+
+	descriptors, err := artifactDescriptors(outputDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []resourceDescriptor{
+		{
+			Name:   "coreboot.rom",
+			Digest: map[string]string{"sha256": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"},
+		},
+	}, descriptors)
+}
+
+func TestCheckProvenanceKey(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "ed25519"), 0o755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "rsa"), 0o755))
+
+	ed25519Private, ed25519Public := writeKeyPair(t, filepath.Join(tmpDir, "ed25519"), ed25519Key)
+	rsaPrivate, _ := writeKeyPair(t, filepath.Join(tmpDir, "rsa"), rsaKey)
+
+	garbage := filepath.Join(tmpDir, "garbage.pem")
+	assert.NoError(t, os.WriteFile(garbage, []byte("not a key"), 0o600))
+
+	assert.NoError(t, CheckProvenanceKey(ed25519Private))
+	assert.ErrorIs(t, CheckProvenanceKey(ed25519Public), ErrProvenanceKey)
+	assert.ErrorIs(t, CheckProvenanceKey(rsaPrivate), ErrProvenanceKey)
+	assert.ErrorIs(t, CheckProvenanceKey(garbage), ErrProvenanceKey)
+	assert.ErrorIs(t, CheckProvenanceKey(filepath.Join(tmpDir, "missing.pem")), os.ErrNotExist)
+}
+
+func TestProvenanceFlags(t *testing.T) {
+	flags := newProvenanceFlags(BuildOptions{
+		Hermetic:              true,
+		ShellOnFailure:        true,
+		ExportFailedContainer: "/home/user/failed.tar",
+		ProvenanceKey:         "/home/user/.keys/provenance.pem",
+	})
+
+	data, err := json.Marshal(flags)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"hermetic": true}`, string(data))
+}
@@ -9,10 +9,13 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
 	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"dagger.io/dagger"
 	"github.com/9elements/firmware-action/cmd/firmware-action/container"
@@ -24,11 +27,13 @@ import (
 // Errors for recipes
 var (
 	ErrBuildFailed               = errors.New("build failed")
+	ErrBuildInterrupted          = errors.New("build was interrupted")
 	ErrBuildUpToDate             = errors.New("build is up-to-date")
 	ErrDependencyTreeUndefDep    = errors.New("module has invalid dependency")
 	ErrDependencyTreeUnderTarget = errors.New("target not found in dependency tree")
 	ErrDependencyOutputMissing   = errors.New("output of one or more dependencies is missing")
 	ErrFailedValidation          = errors.New("config failed validation")
+	ErrNotReproducible           = errors.New("build is not reproducible")
 	ErrTargetInvalid             = errors.New("unsupported target")
 	ErrTargetMissing             = errors.New("no target specified")
 )
@@ -47,6 +52,8 @@ var (
 	GitRepoHashDir = filepath.Join(StatusDir, "git-hashes")
 	// ArtifactDir specifies directory where to store a copy of artifacts for caching in CI
 	ArtifactDir = filepath.Join(StatusDir, "artifacts")
+	// ReproducibilityDir specifies directory where to store artifacts of builds for reproducibility verification
+	ReproducibilityDir = filepath.Join(StatusDir, "reproducibility")
 )
 
 func forestAddVertex(forest *dag.DAG, key string, value FirmwareModule, dependencies [][]string) ([][]string, error) {
@@ -62,10 +69,132 @@ func forestAddVertex(forest *dag.DAG, key string, value FirmwareModule, dependen
 	return dependencies, nil
 }
 
+// BuildOptions holds settings which apply to all modules in the build, usually set from command line
+type BuildOptions struct {
+	// Disable network access in build steps of all modules
+	Hermetic bool
+
+	// Open interactive shell in the container when a build step fails
+	ShellOnFailure bool
+
+	// Path where to export the container image when a build step fails, empty to disable
+	ExportFailedContainer string
+
+	// Path to private key used to sign provenance, empty to leave provenance unsigned
+	ProvenanceKey string
+}
+
+type buildOptionsKey struct{}
+
+// WithBuildOptions returns a copy of ctx which carries the build options
+func WithBuildOptions(ctx context.Context, opts BuildOptions) context.Context {
+	return context.WithValue(ctx, buildOptionsKey{}, opts)
+}
+
+// GetBuildOptions returns build options carried by ctx, or default options if there are none
+func GetBuildOptions(ctx context.Context) BuildOptions {
+	opts, _ := ctx.Value(buildOptionsKey{}).(BuildOptions)
+	return opts
+}
+
 // BuildResults contains target name and result of its build
 type BuildResults struct {
 	Name        string
 	BuildResult error
+	Start       time.Time
+	Duration    time.Duration
+	Details     BuildDetails
+}
+
+// Status returns human readable status of the build
+func (r BuildResults) Status() string {
+	switch {
+	case r.BuildResult == nil:
+		return "Success"
+	case errors.Is(r.BuildResult, ErrBuildUpToDate):
+		return "Up-to-date"
+	case errors.Is(r.BuildResult, ErrBuildInterrupted):
+		return "Interrupted"
+	default:
+		return "Fail"
+	}
+}
+
+// BuildDetails holds information about the build of a module collected by the executor
+type BuildDetails struct {
+	// Type of the module, for example 'coreboot' or 'edk2'
+	RecipeType string
+
+	// Why the module was (re)built, empty if it was up-to-date
+	ChangeReasons []string
+
+	// Files in output directory, relative to it
+	Artifacts []string
+
+	// Container image used for build, with digest if available
+	Image string
+
+	// Wall-clock time spent in each phase of the build
+	Timings []Timing
+
+	// Unified diff between input defconfig and the one generated by savedefconfig, see 'defconfig_check'
+	DefconfigDiff string
+
+	// Results of individual boards of multi-board coreboot build, see 'defconfigs'
+	Boards []BoardResult
+}
+
+// BoardResult contains result of a single board in multi-board coreboot build
+type BoardResult struct {
+	Name        string
+	BuildResult error
+	Duration    time.Duration
+}
+
+// Status returns human readable status of the board build
+func (r BoardResult) Status() string {
+	return BuildResults{BuildResult: r.BuildResult}.Status()
+}
+
+type buildDetailsKey struct{}
+
+// withBuildDetails returns a copy of ctx which carries details to be filled in by the executor
+func withBuildDetails(ctx context.Context, details *BuildDetails) context.Context {
+	return context.WithValue(ctx, buildDetailsKey{}, details)
+}
+
+// getBuildDetails returns details carried by ctx, or throwaway details if there are none
+func getBuildDetails(ctx context.Context) *BuildDetails {
+	details, ok := ctx.Value(buildDetailsKey{}).(*BuildDetails)
+	if !ok {
+		return &BuildDetails{}
+	}
+
+	return details
+}
+
+// runExecutor runs the executor for single module and records its result
+func runExecutor(
+	ctx context.Context,
+	target string,
+	config *Config,
+	executor func(context.Context, string, *Config) error,
+) BuildResults {
+	details := BuildDetails{RecipeType: config.ModuleType(target)}
+	start := time.Now()
+
+	err := executor(withBuildDetails(ctx, &details), target, config)
+	if err != nil && !errors.Is(err, ErrBuildUpToDate) && ctx.Err() != nil {
+		err = errors.Join(ErrBuildInterrupted, err)
+	}
+
+	return BuildResults{
+		Name:        target,
+		BuildResult: err,
+		Start:       start,
+		Duration:    time.Since(start),
+		Details:     details,
+	}
 }
 
 // Build recipes, possibly recursively
@@ -149,9 +278,10 @@ func Build(
 		for _, item := range queue {
 			slog.Info(fmt.Sprintf("Building: %s", item))
 
-			err = executor(ctx, item, config)
+			result := runExecutor(ctx, item, config, executor)
+			err = result.BuildResult
 
-			builds = append(builds, BuildResults{item, err})
+			builds = append(builds, result)
 			if err != nil && !errors.Is(err, ErrBuildUpToDate) {
 				break
 			}
@@ -170,8 +300,7 @@ func Build(
 		// else build only the target
 		slog.Info(fmt.Sprintf("Building '%s' NOT recursively", target))
 
-		err = executor(ctx, target, config)
-		builds = append(builds, BuildResults{target, err})
+		builds = append(builds, runExecutor(ctx, target, config, executor))
 	}
 
 	// Check results
@@ -217,22 +346,36 @@ func Execute(ctx context.Context, target string, config *Config) error {
 		}
 	}
 
+	details := getBuildDetails(ctx)
+
 	// Find requested target
 	modules := config.AllModules()
 	if _, ok := modules[target]; ok {
+		// stopTiming always holds the phase in progress, so that it is recorded also on early return
+		stopTiming := recordPhase(ctx, PhaseChangeDetection, PhaseChangeDetection)
+		defer func() { stopTiming() }()
+
+		// Outputs of dependencies are mounted into the container, so they are inputs of the build too
+		ctx = withDependencyOutputs(ctx, target, config)
+		dependencyOutputs := getDependencyOutputs(ctx)
+
 		// Check for any change in source files
 		detectedChanges := AllChanges{
 			TimeStamp: ChangeTimeStamp{
 				Change: Change{
 					ResultFile: filepath.Join(TimestampsDir, filesystem.Filenamify(target, "txt")),
 				},
-				Sources: modules[target].GetSources(),
+				Sources: append(
+					modules[target].GetSources(),
+					slices.Sorted(maps.Values(dependencyOutputs))...,
+				),
 			},
 			Configuration: ChangeConfig{
 				Change: Change{
 					ResultFile: filepath.Join(CompiledConfigsDir, filesystem.Filenamify(target, "json")),
 				},
-				Config: config,
+				Config:         config,
+				EnvPassthrough: modules[target].envPassthrough(),
 			},
 			GitHash: ChangeGitHash{
 				Change: Change{
@@ -249,13 +392,15 @@ func Execute(ctx context.Context, target string, config *Config) error {
 		_, errExists := os.Stat(modules[target].GetOutputDir())
 
 		empty, _ := IsDirEmpty(modules[target].GetOutputDir())
+		if errExists != nil || empty {
+			details.ChangeReasons = []string{"output directory is missing or empty"}
+		}
+
 		if errExists == nil && !empty {
 			if detectedChanges.DetectChanges(target) {
 				// If any of the sources changed, we need to rebuild
-				slog.Debug(
-					fmt.Sprintf("Deleting '%s'", modules[target].GetOutputDir()),
-				)
-				os.RemoveAll(modules[target].GetOutputDir())
+				// The old output is replaced only after the new build succeeds
+				details.ChangeReasons = detectedChanges.Reasons()
 			} else {
 				// Is already up-to-date
 				slog.Warn(fmt.Sprintf("Target '%s' is up-to-date, skipping build", target))
@@ -272,29 +417,38 @@ func Execute(ctx context.Context, target string, config *Config) error {
 			}
 		}
 
-		// Check if all outputs of required modules exist
-		for _, prerequisite := range modules[target].GetDepends() {
-			outputDir := modules[prerequisite].GetOutputDir()
-			paths := modules[prerequisite].GetContainerOutputDirs()
-			paths = append(paths, modules[prerequisite].GetContainerOutputFiles()...)
-
-			for _, path := range paths {
-				finalPath := filepath.Join(outputDir, filepath.Base(path))
-				slog.Info(finalPath)
-
-				if _, err := os.Stat(finalPath); os.IsNotExist(err) {
-					slog.Error(
-						"Missing output files and/or directories from one or more required module(s) defined in 'Depends'",
-						slog.String("suggestion", "build needed modules or use '--recursive' build"),
-						slog.Any("error", errors.Join(err, ErrDependencyOutputMissing)),
-					)
-
-					return ErrDependencyOutputMissing
-				}
+		// Artifacts are exported into staging directory, and swapped with output directory
+		//   only if the build succeeds, so that failed build does not destroy the last good artifacts
+		staging, err := prepareStagingDir(modules[target].GetOutputDir())
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(staging)
+
+		stagedModule := modules[target].withCommonOpts(func(opts *CommonOpts) {
+			opts.OutputDir = staging
+		})
+
+		// On interrupt, do not leave behind checkpoints which would claim that the output
+		//   directory is up-to-date
+		completed := false
+
+		defer func() {
+			if !completed && ctx.Err() != nil {
+				rollbackInterrupted(&detectedChanges)
 			}
+		}()
+
+		// Check if all outputs of required modules exist
+		if err := checkDependencyOutputs(modules, target); err != nil {
+			return err
 		}
 
+		stopTiming()
+
 		// Setup dagger client
+		stopTiming = recordPhase(ctx, PhaseDaggerConnect, PhaseDaggerConnect)
+
 		environment.LogGroupStart("connect to dagger engine")
 		//   this will make around 400 lines of irrelevant-to-the-user log collapsible
 		client, err := dagger.Connect(ctx, dagger.WithLogOutput(os.Stdout))
@@ -304,11 +458,74 @@ func Execute(ctx context.Context, target string, config *Config) error {
 		defer client.Close()
 
 		environment.LogGroupStop("connect to dagger engine")
+		stopTiming()
+
+		// Timing of the build is recorded phase by phase inside
+		stopTiming = func() {}
 
 		// Build the module
-		err = modules[target].buildFirmware(ctx, client)
+		err = buildWithRetries(ctx, stagedModule, client)
 		if err == nil {
+			stopTiming = recordPhase(ctx, PhaseMetadata, PhaseMetadata)
+
+			// Describe what was built, metadata are written into staging directory so that
+			//   they replace the output directory together with the artifacts
+			var sbom []byte
+
+			sbom, err = NewSBOM(ctx, client, target, config)
+			if err != nil {
+				return err
+			}
+
+			err = WriteSBOM(staging, sbom)
+			if err != nil {
+				return err
+			}
+
+			var manifest Manifest
+
+			manifest, err = NewManifest(ctx, client, target, staging, config)
+			if err != nil {
+				return err
+			}
+
+			err = WriteManifest(staging, manifest)
+			if err != nil {
+				return err
+			}
+
+			details.Image = manifest.Image
+			for _, file := range manifest.Files {
+				details.Artifacts = append(details.Artifacts, file.Path)
+			}
+
+			var provenance inTotoStatement
+
+			provenance, err = NewProvenance(ctx, client, target, staging, config)
+			if err != nil {
+				return err
+			}
+
+			err = WriteProvenance(staging, provenance, GetBuildOptions(ctx).ProvenanceKey)
+			if err != nil {
+				return err
+			}
+
+			stopTiming()
+			stopTiming = func() {}
+
+			stopSwap := recordPhase(ctx, PhaseArtifactExport, "swap output directory")
+			err = swapOutputDir(staging, modules[target].GetOutputDir(), modules[target].keepPreviousOutput())
+
+			stopSwap()
+
+			if err != nil {
+				return err
+			}
+
 			// On successful build, save checkpoint data for next change detection
+			//   only once artifacts and all metadata are in place, otherwise the next build would
+			//   be skipped and the missing metadata would never be written
 			detectedChanges.SaveCheckpoint(target, true)
 
 			if environment.DetectGithub() {
@@ -339,12 +556,80 @@ func Execute(ctx context.Context, target string, config *Config) error {
 			}
 		}
 
+		completed = err == nil
+
 		return err
 	}
 
 	return ErrTargetMissing
 }
 
+// rollbackInterrupted removes change detection checkpoints of interrupted module,
+// so that the module is rebuilt from scratch next time
+// Partial output is in staging directory, which is removed anyway
+func rollbackInterrupted(changes *AllChanges) {
+	slog.Warn("Build was interrupted, discarding partial output")
+
+	paths := []string{
+		changes.TimeStamp.ResultFile,
+		changes.Configuration.ResultFile,
+		changes.Configuration.envPassthroughFile(),
+		changes.GitHash.ResultFile,
+	}
+	for _, path := range paths {
+		if err := os.RemoveAll(path); err != nil {
+			slog.Error(
+				fmt.Sprintf("Failed to remove '%s'", path),
+				slog.String("suggestion", "Remove it manually before next build"),
+				slog.Any("error", err),
+			)
+		}
+	}
+}
+
+// Shell opens an interactive shell in the container of the target module, prepared for building
+// (with repository, input files, environment variables, etc.) but without building anything
+func Shell(ctx context.Context, target string, config *Config) error {
+	modules := config.AllModules()
+
+	module, ok := modules[target]
+	if !ok {
+		return ErrTargetMissing
+	}
+
+	ctx = withDependencyOutputs(ctx, target, config)
+
+	// Setup dagger client
+	environment.LogGroupStart("connect to dagger engine")
+
+	client, err := dagger.Connect(ctx, dagger.WithLogOutput(os.Stdout))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	environment.LogGroupStop("connect to dagger engine")
+
+	myContainer, buildSteps, err := module.prepareContainer(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	for _, step := range buildSteps {
+		slog.Info(
+			"Build step which would be executed",
+			slog.String("build_step", strings.Join(step, " ")),
+		)
+	}
+
+	slog.Info(
+		fmt.Sprintf("Opening interactive shell in the container of '%s'", target),
+		slog.String("suggestion", "to exit the shell run command 'exit' or press CTRL+D"),
+	)
+
+	return container.OpenTerminal(ctx, myContainer, module.networkDisabled(ctx))
+}
+
 // NormalizeArchitecture will translate various architecture strings into expected format
 func NormalizeArchitecture(arch string) string {
 	archMap := map[string]string{
@@ -7,6 +7,7 @@ package recipes
 import (
 	"context"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"dagger.io/dagger"
@@ -73,18 +74,18 @@ func TestExecuteSkipAndMissing(t *testing.T) {
 				Depends: []string{depends},
 				CommonOpts: CommonOpts{
 					OutputDir: outputDir,
-					ContainerOutputFiles: []string{
-						"build/coreboot.rom",
-						"defconfig",
+					ContainerOutputFiles: []ContainerOutputFile{
+						{From: "build/coreboot.rom"},
+						{From: "defconfig"},
 					},
 				},
 			},
 			depends: {
 				CommonOpts: CommonOpts{
 					OutputDir: outputDir2,
-					ContainerOutputFiles: []string{
-						"build/coreboot.rom",
-						"defconfig",
+					ContainerOutputFiles: []ContainerOutputFile{
+						{From: "build/coreboot.rom"},
+						{From: "defconfig"},
 					},
 				},
 			},
@@ -104,6 +105,12 @@ func TestExecuteSkipAndMissing(t *testing.T) {
 	assert.ErrorIs(t, err, ErrDependencyOutputMissing)
 }
 
+func TestShell(t *testing.T) {
+	// Target is checked before connecting to dagger
+	err := Shell(t.Context(), "dummy", &Config{})
+	assert.ErrorIs(t, err, ErrTargetMissing)
+}
+
 func executeDummy(_ context.Context, _ string, _ *Config) error {
 	return nil
 }
@@ -282,6 +289,8 @@ func TestBuild(t *testing.T) {
 		done := []string{}
 
 		for _, item := range builds {
+			assert.Equal(t, "coreboot", item.Details.RecipeType)
+
 			for _, i := range testConfigDependencyHell.Coreboot[item.Name].Depends {
 				assert.Contains(t, done, i)
 			}
@@ -289,4 +298,47 @@ func TestBuild(t *testing.T) {
 			done = append(done, item.Name)
 		}
 	})
+
+	t.Run("interrupted", func(t *testing.T) {
+		interruptCtx, cancel := context.WithCancel(ctx)
+
+		builds, err := Build(
+			interruptCtx,
+			"pizza",
+			recursive,
+			false, // do not prune
+			&testConfigDependencyHell,
+			func(ctx context.Context, _ string, _ *Config) error {
+				// Interrupt in the middle of the first module
+				cancel()
+
+				return ctx.Err()
+			},
+		)
+		assert.ErrorIs(t, err, ErrBuildInterrupted)
+
+		// Remaining modules are not built at all
+		assert.Len(t, builds, 1)
+		assert.Equal(t, "Interrupted", builds[0].Status())
+	})
+}
+
+func TestRollbackInterrupted(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	changes := AllChanges{
+		TimeStamp:     ChangeTimeStamp{Change: Change{ResultFile: filepath.Join(tmpDir, "timestamp.txt")}},
+		Configuration: ChangeConfig{Change: Change{ResultFile: filepath.Join(tmpDir, "config.json")}},
+		GitHash:       ChangeGitHash{Change: Change{ResultFile: filepath.Join(tmpDir, "git.txt")}},
+	}
+
+	for _, path := range []string{changes.TimeStamp.ResultFile, changes.Configuration.ResultFile, changes.GitHash.ResultFile} {
+		assert.NoError(t, os.WriteFile(path, []byte("checkpoint"), 0o644))
+	}
+
+	rollbackInterrupted(&changes)
+
+	entries, err := os.ReadDir(tmpDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
 }
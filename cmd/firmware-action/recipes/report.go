@@ -0,0 +1,399 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / report
+package recipes
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Report is machine-readable summary of the build
+// ANCHOR: Report
+type Report struct {
+	// Target given on command line
+	Target string `json:"target"`
+
+	// Version of firmware-action used for build
+	FirmwareActionVersion string `json:"firmware_action_version"`
+
+	// True if the build succeeded
+	Success bool `json:"success"`
+
+	// Chain of errors which failed the build, empty on success
+	Errors []string `json:"errors"`
+
+	// All modules in the order they were built
+	Modules []ReportModule `json:"modules"`
+
+	// Result of '--verify-reproducible', null if the verification was not requested
+	Reproducibility *ReportReproducibility `json:"reproducibility"`
+}
+
+// ReportModule describes build of a single module
+type ReportModule struct {
+	// ID of the module in configuration file
+	ModuleID string `json:"module_id"`
+
+	// Type of the module, for example 'coreboot' or 'edk2'
+	RecipeType string `json:"recipe_type"`
+
+	// One of 'Success', 'Up-to-date', 'Fail' or 'Interrupted'
+	Status string `json:"status"`
+
+	// Duration of the build in seconds
+	DurationSeconds float64 `json:"duration_seconds"`
+
+	// Chain of errors, from the outermost one, empty on success
+	Errors []string `json:"errors"`
+
+	// Why the module was (re)built, empty if it was up-to-date
+	ChangeReasons []string `json:"change_reasons"`
+
+	// Files in output directory, relative to it
+	Artifacts []string `json:"artifacts"`
+
+	// Container image used for build, with digest if available
+	Image string `json:"image"`
+
+	// Unified diff between input defconfig and the one generated by savedefconfig,
+	//   empty if they are equal or if 'defconfig_check' is disabled
+	DefconfigDiff string `json:"defconfig_diff"`
+
+	// Boards of multi-board coreboot build, empty for other modules
+	Boards []ReportBoard `json:"boards"`
+}
+
+// ReportBoard describes build of a single board in multi-board coreboot build
+type ReportBoard struct {
+	// Filename of the defconfig, also name of the subdirectory in output directory
+	Name string `json:"name"`
+
+	// One of 'Success', 'Fail' or 'Interrupted'
+	Status string `json:"status"`
+
+	// Duration of the build in seconds
+	DurationSeconds float64 `json:"duration_seconds"`
+
+	// Chain of errors, from the outermost one, empty on success
+	Errors []string `json:"errors"`
+}
+
+// ReportReproducibility describes result of reproducibility verification of the target
+type ReportReproducibility struct {
+	// One of 'Success', 'Fail' or 'Skipped' (verification does not run when the build failed)
+	Status string `json:"status"`
+
+	// Duration of the verification in seconds
+	DurationSeconds float64 `json:"duration_seconds"`
+
+	// Chain of errors, from the outermost one, empty on success
+	Errors []string `json:"errors"`
+}
+
+// ANCHOR_END: Report
+
+// errorChain returns messages of the error and all errors it wraps, depth-first
+func errorChain(err error) []string {
+	chain := []string{}
+
+	if err == nil {
+		return chain
+	}
+
+	chain = append(chain, err.Error())
+
+	switch wrapped := err.(type) { //nolint:errorlint // walking the chain manually
+	case interface{ Unwrap() error }:
+		chain = append(chain, errorChain(wrapped.Unwrap())...)
+	case interface{ Unwrap() []error }:
+		for _, item := range wrapped.Unwrap() {
+			chain = append(chain, errorChain(item)...)
+		}
+	}
+
+	return chain
+}
+
+// NewReport creates report from build results and the overall error of the build
+func NewReport(target string, results []BuildResults, err error) Report {
+	report := Report{
+		Target:                target,
+		FirmwareActionVersion: FirmwareActionVersion,
+		Success:               err == nil,
+		Errors:                errorChain(err),
+		Modules:               []ReportModule{},
+	}
+
+	for _, item := range results {
+		module := ReportModule{
+			ModuleID:        item.Name,
+			RecipeType:      item.Details.RecipeType,
+			Status:          item.Status(),
+			DurationSeconds: item.Duration.Seconds(),
+			Errors:          []string{},
+			ChangeReasons:   item.Details.ChangeReasons,
+			Artifacts:       item.Details.Artifacts,
+			Image:           item.Details.Image,
+			DefconfigDiff:   item.Details.DefconfigDiff,
+			Boards:          []ReportBoard{},
+		}
+
+		if status := item.Status(); status == "Fail" || status == "Interrupted" {
+			module.Errors = errorChain(item.BuildResult)
+		}
+
+		if module.ChangeReasons == nil {
+			module.ChangeReasons = []string{}
+		}
+
+		if module.Artifacts == nil {
+			module.Artifacts = []string{}
+		}
+
+		for _, board := range item.Details.Boards {
+			module.Boards = append(module.Boards, ReportBoard{
+				Name:            board.Name,
+				Status:          board.Status(),
+				DurationSeconds: board.Duration.Seconds(),
+				Errors:          errorChain(board.BuildResult),
+			})
+		}
+
+		report.Modules = append(report.Modules, module)
+	}
+
+	return report
+}
+
+// NewReportReproducibility creates result of reproducibility verification which took 'duration'
+// and ended with 'err'
+func NewReportReproducibility(duration time.Duration, err error) *ReportReproducibility {
+	status := "Success"
+	if err != nil {
+		status = "Fail"
+	}
+
+	return &ReportReproducibility{
+		Status:          status,
+		DurationSeconds: duration.Seconds(),
+		Errors:          errorChain(err),
+	}
+}
+
+// JUnit XML, the subset understood by Jenkins and GitLab
+//   https://github.com/testmoapp/junitxml
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// junitTime formats seconds the way JUnit expects
+func junitTime(seconds float64) string {
+	return fmt.Sprintf("%.3f", seconds)
+}
+
+// JUnit converts the report into JUnit XML, each module is a test case
+// Boards of multi-board coreboot build are additional test cases named '<module>/<board>'
+// Reproducibility verification, if requested, is the last test case named 'reproducibility'
+func (r Report) JUnit() ([]byte, error) {
+	suite := junitTestSuite{
+		Name:  r.Target,
+		Cases: []junitTestCase{},
+	}
+
+	total := 0.0
+
+	for _, module := range r.Modules {
+		testCase := junitTestCase{
+			Name:      module.ModuleID,
+			Classname: fmt.Sprintf("firmware-action.%s", module.RecipeType),
+			Time:      junitTime(module.DurationSeconds),
+		}
+
+		switch module.Status {
+		case "Fail", "Interrupted":
+			suite.Failures++
+
+			message := ""
+			if len(module.Errors) > 0 {
+				message = module.Errors[0]
+			}
+
+			testCase.Failure = &junitMessage{
+				Message: message,
+				Text:    strings.Join(module.Errors, "\n"),
+			}
+		case "Up-to-date":
+			suite.Skipped++
+			testCase.Skipped = &junitMessage{Message: "up-to-date"}
+		}
+
+		output := []string{}
+		if module.Image != "" {
+			output = append(output, fmt.Sprintf("image: %s", module.Image))
+		}
+
+		for _, reason := range module.ChangeReasons {
+			output = append(output, fmt.Sprintf("change: %s", reason))
+		}
+
+		for _, artifact := range module.Artifacts {
+			output = append(output, fmt.Sprintf("artifact: %s", artifact))
+		}
+
+		if module.DefconfigDiff != "" {
+			output = append(output, fmt.Sprintf("defconfig drift:\n%s", module.DefconfigDiff))
+		}
+
+		testCase.SystemOut = strings.Join(output, "\n")
+
+		suite.Tests++
+		total += module.DurationSeconds
+		suite.Cases = append(suite.Cases, testCase)
+
+		// Boards are part of the module, their time is already included in total
+		for _, board := range module.Boards {
+			boardCase := junitTestCase{
+				Name:      fmt.Sprintf("%s/%s", module.ModuleID, board.Name),
+				Classname: fmt.Sprintf("firmware-action.%s", module.RecipeType),
+				Time:      junitTime(board.DurationSeconds),
+			}
+
+			if board.Status != "Success" {
+				suite.Failures++
+
+				boardCase.Failure = &junitMessage{
+					Message: board.Errors[0],
+					Text:    strings.Join(board.Errors, "\n"),
+				}
+			}
+
+			suite.Tests++
+			suite.Cases = append(suite.Cases, boardCase)
+		}
+	}
+
+	if r.Reproducibility != nil {
+		testCase := junitTestCase{
+			Name:      "reproducibility",
+			Classname: "firmware-action.reproducibility",
+			Time:      junitTime(r.Reproducibility.DurationSeconds),
+		}
+
+		switch r.Reproducibility.Status {
+		case "Fail":
+			suite.Failures++
+
+			testCase.Failure = &junitMessage{
+				Message: r.Reproducibility.Errors[0],
+				Text:    strings.Join(r.Reproducibility.Errors, "\n"),
+			}
+		case "Skipped":
+			suite.Skipped++
+			testCase.Skipped = &junitMessage{Message: "build failed"}
+		}
+
+		suite.Tests++
+		total += r.Reproducibility.DurationSeconds
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	suite.Time = junitTime(total)
+
+	data, err := xml.MarshalIndent(junitTestSuites{
+		Name:     "firmware-action",
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Skipped:  suite.Skipped,
+		Time:     suite.Time,
+		Suites:   []junitTestSuite{suite},
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), data...), nil
+}
+
+// writeReportFile writes report into file, creating parent directories
+func writeReportFile(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err == nil {
+		err = os.WriteFile(path, append(data, '\n'), 0o644)
+	}
+
+	if err != nil {
+		slog.Error(
+			fmt.Sprintf("Failed to write report '%s'", path),
+			slog.Any("error", err),
+		)
+
+		return err
+	}
+
+	slog.Info(fmt.Sprintf("Report written to '%s'", path))
+
+	return nil
+}
+
+// WriteReports writes the report as JSON and/or JUnit XML, empty path disables the format
+func WriteReports(report Report, jsonPath string, junitPath string) error {
+	var errs []error
+
+	if jsonPath != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err == nil {
+			err = writeReportFile(jsonPath, data)
+		}
+
+		errs = append(errs, err)
+	}
+
+	if junitPath != "" {
+		data, err := report.JUnit()
+		if err == nil {
+			err = writeReportFile(junitPath, data)
+		}
+
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
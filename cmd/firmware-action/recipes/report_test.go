@@ -0,0 +1,215 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / report
+package recipes
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorChain(t *testing.T) {
+	assert.Equal(t, []string{}, errorChain(nil))
+
+	errInner := errors.New("inner")
+	err := fmt.Errorf("outer: %w", errInner)
+	assert.Equal(t, []string{"outer: inner", "inner"}, errorChain(err))
+
+	err = errors.Join(ErrBuildFailed, err)
+	assert.Equal(t, []string{"build failed\nouter: inner", "build failed", "outer: inner", "inner"}, errorChain(err))
+}
+
+func TestReport(t *testing.T) {
+	results := []BuildResults{
+		{
+			Name:        "linux",
+			BuildResult: ErrBuildUpToDate,
+			Duration:    500 * time.Millisecond,
+			Details:     BuildDetails{RecipeType: "linux"},
+		},
+		{
+			Name:     "edk2",
+			Duration: 2 * time.Second,
+			Details: BuildDetails{
+				RecipeType:    "edk2",
+				ChangeReasons: []string{"configuration changed"},
+				Artifacts:     []string{"UEFIPAYLOAD.fd"},
+				Image:         "ghcr.io/9elements/firmware-action/edk2-stable202408@sha256:0123abcd",
+			},
+		},
+		{
+			Name:        "coreboot",
+			BuildResult: fmt.Errorf("%w: exit code 2", ErrBuildFailed),
+			Duration:    time.Second,
+			Details: BuildDetails{
+				RecipeType:    "coreboot",
+				DefconfigDiff: "--- defconfig\n+++ savedefconfig\n@@ -1 +1 @@\n-CONFIG_TPM2=y\n+# CONFIG_TPM2 is not set\n",
+			},
+		},
+	}
+	buildErr := results[2].BuildResult
+
+	report := NewReport("coreboot", results, buildErr)
+	assert.False(t, report.Success)
+	assert.Len(t, report.Modules, 3)
+	assert.Equal(t, "Up-to-date", report.Modules[0].Status)
+	assert.Equal(t, "Success", report.Modules[1].Status)
+	assert.Equal(t, 2.0, report.Modules[1].DurationSeconds)
+	assert.Equal(t, []string{}, report.Modules[1].Errors)
+	assert.Equal(t, []string{"build failed: exit code 2", "build failed"}, report.Modules[2].Errors)
+	assert.Equal(t, []string{}, report.Modules[2].Artifacts)
+	assert.Equal(t, results[2].Details.DefconfigDiff, report.Modules[2].DefconfigDiff)
+
+	tmpDir := t.TempDir()
+	jsonPath := filepath.Join(tmpDir, "reports", "report.json")
+	junitPath := filepath.Join(tmpDir, "reports", "report.xml")
+	assert.NoError(t, WriteReports(report, jsonPath, junitPath))
+
+	// JSON
+	data, err := os.ReadFile(jsonPath)
+	assert.NoError(t, err)
+
+	var jsonReport Report
+
+	assert.NoError(t, json.Unmarshal(data, &jsonReport))
+	assert.Equal(t, report, jsonReport)
+
+	// JUnit
+	data, err = os.ReadFile(junitPath)
+	assert.NoError(t, err)
+
+	var junitReport junitTestSuites
+
+	assert.NoError(t, xml.Unmarshal(data, &junitReport))
+	assert.Equal(t, 3, junitReport.Tests)
+	assert.Equal(t, 1, junitReport.Failures)
+	assert.Equal(t, 1, junitReport.Skipped)
+	assert.Equal(t, "3.500", junitReport.Time)
+
+	cases := junitReport.Suites[0].Cases
+	assert.Equal(t, "firmware-action.coreboot", cases[2].Classname)
+	assert.Equal(t, "build failed: exit code 2", cases[2].Failure.Message)
+	assert.Equal(t, "up-to-date", cases[0].Skipped.Message)
+	assert.Nil(t, cases[1].Failure)
+	assert.Contains(t, cases[1].SystemOut, "artifact: UEFIPAYLOAD.fd")
+}
+
+func TestReportBoards(t *testing.T) {
+	errBoard := fmt.Errorf("%w: exit code 2", ErrBuildFailed)
+	results := []BuildResults{
+		{
+			Name:        "coreboot",
+			BuildResult: errors.Join(ErrBoardsFailed, errBoard),
+			Duration:    5 * time.Second,
+			Details: BuildDetails{
+				RecipeType: "coreboot",
+				Boards: []BoardResult{
+					{Name: "qemu_q35_defconfig", Duration: 2 * time.Second},
+					{Name: "qemu_i440fx_defconfig", BuildResult: errBoard, Duration: time.Second},
+				},
+			},
+		},
+	}
+
+	report := NewReport("coreboot", results, results[0].BuildResult)
+	assert.Len(t, report.Modules[0].Boards, 2)
+	assert.Equal(t, ReportBoard{
+		Name:            "qemu_q35_defconfig",
+		Status:          "Success",
+		DurationSeconds: 2.0,
+		Errors:          []string{},
+	}, report.Modules[0].Boards[0])
+	assert.Equal(t, "Fail", report.Modules[0].Boards[1].Status)
+	assert.Equal(t, []string{"build failed: exit code 2", "build failed"}, report.Modules[0].Boards[1].Errors)
+
+	data, err := report.JUnit()
+	assert.NoError(t, err)
+
+	var junitReport junitTestSuites
+
+	assert.NoError(t, xml.Unmarshal(data, &junitReport))
+	assert.Equal(t, 3, junitReport.Tests)
+	assert.Equal(t, 2, junitReport.Failures)
+	assert.Equal(t, "5.000", junitReport.Time)
+
+	cases := junitReport.Suites[0].Cases
+	assert.Equal(t, "coreboot/qemu_q35_defconfig", cases[1].Name)
+	assert.Nil(t, cases[1].Failure)
+	assert.Equal(t, "coreboot/qemu_i440fx_defconfig", cases[2].Name)
+	assert.Equal(t, "build failed: exit code 2", cases[2].Failure.Message)
+}
+
+func TestReportReproducibility(t *testing.T) {
+	results := []BuildResults{
+		{
+			Name:     "coreboot",
+			Duration: 2 * time.Second,
+			Details:  BuildDetails{RecipeType: "coreboot"},
+		},
+	}
+
+	testCases := []struct {
+		name            string
+		reproducibility *ReportReproducibility
+		wantTests       int
+		wantFailures    int
+		wantSkipped     int
+		wantTime        string
+	}{
+		{
+			name:      "not requested",
+			wantTests: 1,
+			wantTime:  "2.000",
+		},
+		{
+			name:            "reproducible",
+			reproducibility: NewReportReproducibility(4*time.Second, nil),
+			wantTests:       2,
+			wantTime:        "6.000",
+		},
+		{
+			name:            "not reproducible",
+			reproducibility: NewReportReproducibility(4*time.Second, fmt.Errorf("%w: coreboot.rom", ErrNotReproducible)),
+			wantTests:       2,
+			wantFailures:    1,
+			wantTime:        "6.000",
+		},
+		{
+			name:            "skipped",
+			reproducibility: &ReportReproducibility{Status: "Skipped", Errors: []string{}},
+			wantTests:       2,
+			wantSkipped:     1,
+			wantTime:        "2.000",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			report := NewReport("coreboot", results, nil)
+			report.Reproducibility = tc.reproducibility
+
+			data, err := report.JUnit()
+			assert.NoError(t, err)
+
+			var junitReport junitTestSuites
+
+			assert.NoError(t, xml.Unmarshal(data, &junitReport))
+			assert.Equal(t, tc.wantTests, junitReport.Tests)
+			assert.Equal(t, tc.wantFailures, junitReport.Failures)
+			assert.Equal(t, tc.wantSkipped, junitReport.Skipped)
+			assert.Equal(t, tc.wantTime, junitReport.Time)
+
+			if tc.reproducibility != nil {
+				cases := junitReport.Suites[0].Cases
+				assert.Equal(t, "reproducibility", cases[len(cases)-1].Name)
+			}
+		})
+	}
+}
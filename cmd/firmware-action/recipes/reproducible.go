@@ -0,0 +1,259 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / reproducible
+package recipes
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"dagger.io/dagger"
+	"github.com/9elements/firmware-action/cmd/firmware-action/environment"
+	"github.com/9elements/firmware-action/cmd/firmware-action/filesystem"
+)
+
+// reproducibilityBuilds is number of builds compared during reproducibility verification
+const reproducibilityBuilds = 2
+
+// artifactDifference describes a difference between artifacts of two builds
+type artifactDifference struct {
+	Path   string // Path relative to output directory
+	Offset int64  // First differing byte, -1 if the file is missing in one of the builds
+	Reason string
+}
+
+// VerifyReproducible builds the target twice in fresh containers and compares all artifacts byte for byte
+//
+// Both builds run with BUILD_TIMELESS=1 and SOURCE_DATE_EPOCH set to the date of the last commit
+// in 'repo_path'. Artifacts are stored in ReproducibilityDir, so they can be inspected afterwards.
+// Outputs of modules in 'depends' must already exist.
+func VerifyReproducible(ctx context.Context, target string, config *Config) error {
+	modules := config.AllModules()
+
+	module, ok := modules[target]
+	if !ok {
+		return ErrTargetMissing
+	}
+
+	ctx = withDependencyOutputs(ctx, target, config)
+
+	sourceDateEpoch, err := filesystem.GitCommitTimestamp(module.GetRepoPath())
+	if err != nil {
+		slog.Error(
+			fmt.Sprintf("Failed to get date of the last commit in '%s'", module.GetRepoPath()),
+			slog.String("suggestion", "SOURCE_DATE_EPOCH is derived from the last commit, 'repo_path' must be a git repository"),
+			slog.Any("error", err),
+		)
+
+		return err
+	}
+
+	// Setup dagger client
+	environment.LogGroupStart("connect to dagger engine")
+	//   this will make around 400 lines of irrelevant-to-the-user log collapsible
+	client, err := dagger.Connect(ctx, dagger.WithLogOutput(os.Stdout))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	environment.LogGroupStop("connect to dagger engine")
+
+	// Unique value to prevent dagger from serving the build steps from cache
+	nonce := strconv.FormatInt(time.Now().UnixNano(), 10)
+	targetDir := filepath.Join(ReproducibilityDir, strings.TrimSuffix(filesystem.Filenamify(target, ""), "."))
+	outputDirs := []string{}
+
+	for build := 1; build <= reproducibilityBuilds; build++ {
+		outputDir := filepath.Join(targetDir, fmt.Sprintf("build-%d", build))
+		if err := os.RemoveAll(outputDir); err != nil {
+			return err
+		}
+
+		buildModule := module.withCommonOpts(func(opts *CommonOpts) {
+			opts.OutputDir = outputDir
+			opts.Env = maps.Clone(opts.Env)
+
+			if opts.Env == nil {
+				opts.Env = map[string]string{}
+			}
+
+			opts.Env["BUILD_TIMELESS"] = "1"
+			opts.Env["SOURCE_DATE_EPOCH"] = strconv.FormatInt(sourceDateEpoch, 10)
+			opts.Env["FIRMWARE_ACTION_REPRODUCIBILITY_BUILD"] = fmt.Sprintf("%s-%d", nonce, build)
+		})
+
+		slog.Info(
+			fmt.Sprintf("Reproducibility check of '%s': build %d of %d", target, build, reproducibilityBuilds),
+			slog.String("output_dir", outputDir),
+			slog.Int64("SOURCE_DATE_EPOCH", sourceDateEpoch),
+		)
+
+		if err := buildModule.buildFirmware(ctx, client); err != nil {
+			return err
+		}
+
+		outputDirs = append(outputDirs, outputDir)
+	}
+
+	differences, err := compareOutputDirs(outputDirs[0], outputDirs[1])
+	if err != nil {
+		return err
+	}
+
+	if len(differences) > 0 {
+		for _, difference := range differences {
+			attrs := []any{slog.String("file", difference.Path), slog.String("reason", difference.Reason)}
+			if difference.Offset >= 0 {
+				attrs = append(attrs, slog.String("first_differing_offset", fmt.Sprintf("0x%x", difference.Offset)))
+			}
+
+			slog.Error("Artifact differs between builds", attrs...)
+		}
+
+		err = fmt.Errorf("%w: %d artifact(s) of '%s' differ", ErrNotReproducible, len(differences), target)
+		slog.Error(
+			"Build is not reproducible",
+			slog.String("suggestion", fmt.Sprintf("compare the artifacts in '%s' (for example with diffoscope)", targetDir)),
+			slog.Any("error", err),
+		)
+
+		return err
+	}
+
+	slog.Info(fmt.Sprintf("Build of '%s' is reproducible, artifacts of both builds are identical", target))
+
+	return nil
+}
+
+// listOutputFiles returns relative paths of all files in directory
+func listOutputFiles(dir string) ([]string, error) {
+	files := []string{}
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, relPath)
+
+		return nil
+	})
+
+	return files, err
+}
+
+// compareOutputDirs compares all files in two directories byte for byte
+func compareOutputDirs(dirA, dirB string) ([]artifactDifference, error) {
+	filesA, err := listOutputFiles(dirA)
+	if err != nil {
+		return nil, err
+	}
+
+	filesB, err := listOutputFiles(dirB)
+	if err != nil {
+		return nil, err
+	}
+
+	differences := []artifactDifference{}
+
+	for _, file := range filesA {
+		if !slices.Contains(filesB, file) {
+			differences = append(differences, artifactDifference{
+				Path:   file,
+				Offset: -1,
+				Reason: fmt.Sprintf("missing in '%s'", dirB),
+			})
+
+			continue
+		}
+
+		offset, err := firstDifference(filepath.Join(dirA, file), filepath.Join(dirB, file))
+		if err != nil {
+			return nil, err
+		}
+
+		if offset >= 0 {
+			differences = append(differences, artifactDifference{
+				Path:   file,
+				Offset: offset,
+				Reason: "content differs",
+			})
+		}
+	}
+
+	for _, file := range filesB {
+		if !slices.Contains(filesA, file) {
+			differences = append(differences, artifactDifference{
+				Path:   file,
+				Offset: -1,
+				Reason: fmt.Sprintf("missing in '%s'", dirA),
+			})
+		}
+	}
+
+	return differences, nil
+}
+
+// firstDifference returns offset of the first differing byte of two files, -1 if the files are identical
+// If one file is a prefix of the other, the offset is the size of the shorter file
+func firstDifference(pathA, pathB string) (int64, error) {
+	fileA, err := os.Open(pathA)
+	if err != nil {
+		return 0, err
+	}
+	defer fileA.Close()
+
+	fileB, err := os.Open(pathB)
+	if err != nil {
+		return 0, err
+	}
+	defer fileB.Close()
+
+	readerA := bufio.NewReader(fileA)
+	readerB := bufio.NewReader(fileB)
+
+	for offset := int64(0); ; offset++ {
+		byteA, errA := readerA.ReadByte()
+		byteB, errB := readerB.ReadByte()
+
+		endA := errors.Is(errA, io.EOF)
+		endB := errors.Is(errB, io.EOF)
+
+		if errA != nil && !endA {
+			return 0, errA
+		}
+
+		if errB != nil && !endB {
+			return 0, errB
+		}
+
+		switch {
+		case endA && endB:
+			return -1, nil
+		case endA || endB || byteA != byteB:
+			return offset, nil
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / reproducible
+package recipes
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFirstDifference(t *testing.T) {
+	testCases := []struct {
+		name       string
+		contentA   []byte
+		contentB   []byte
+		wantOffset int64
+	}{
+		{name: "identical", contentA: []byte("coreboot"), contentB: []byte("coreboot"), wantOffset: -1},
+		{name: "both empty", contentA: []byte{}, contentB: []byte{}, wantOffset: -1},
+		{name: "differ in the middle", contentA: []byte("coreboot"), contentB: []byte("coreBoot"), wantOffset: 4},
+		{name: "differ at start", contentA: []byte("a"), contentB: []byte("b"), wantOffset: 0},
+		{name: "one is prefix of other", contentA: []byte("core"), contentB: []byte("coreboot"), wantOffset: 4},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			pathA := filepath.Join(tmpDir, "a")
+			pathB := filepath.Join(tmpDir, "b")
+			assert.NoError(t, os.WriteFile(pathA, tc.contentA, 0o644))
+			assert.NoError(t, os.WriteFile(pathB, tc.contentB, 0o644))
+
+			offset, err := firstDifference(pathA, pathB)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantOffset, offset)
+		})
+	}
+}
+
+func TestCompareOutputDirs(t *testing.T) {
+	tmpDir := t.TempDir()
+	dirA := filepath.Join(tmpDir, "build-1")
+	dirB := filepath.Join(tmpDir, "build-2")
+
+	files := map[string][2]string{
+		"defconfig":      {"CONFIG_X=y", "CONFIG_X=y"},
+		"coreboot.rom":   {"0123456789", "0123406789"},
+		"only-in-a.txt":  {"a", ""},
+		"sub/only-in-b":  {"", "b"},
+		"sub/equal.file": {"same", "same"},
+	}
+	for path, content := range files {
+		for i, dir := range []string{dirA, dirB} {
+			if content[i] == "" {
+				continue
+			}
+
+			assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0o755))
+			assert.NoError(t, os.WriteFile(filepath.Join(dir, path), []byte(content[i]), 0o644))
+		}
+	}
+
+	differences, err := compareOutputDirs(dirA, dirB)
+	assert.NoError(t, err)
+	assert.Equal(t, []artifactDifference{
+		{Path: "coreboot.rom", Offset: 5, Reason: "content differs"},
+		{Path: "only-in-a.txt", Offset: -1, Reason: "missing in '" + dirB + "'"},
+		{Path: "sub/only-in-b", Offset: -1, Reason: "missing in '" + dirA + "'"},
+	}, differences)
+}
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / retry
+package recipes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"dagger.io/dagger"
+	"github.com/9elements/firmware-action/cmd/firmware-action/container"
+)
+
+// ErrBuildTimeout is raised when build of a module takes longer than its 'timeout'
+var ErrBuildTimeout = errors.New("build timed out")
+
+// defaultRetryBackoff is wait before the first retry when 'retry_backoff' is not set
+const defaultRetryBackoff = 30 * time.Second
+
+// buildWithRetries builds the module into staging directory (its output directory), each attempt
+// is limited by the module's timeout
+// Attempts which failed on transient error are repeated with exponential backoff
+func buildWithRetries(ctx context.Context, module FirmwareModule, client *dagger.Client) error {
+	timeout, retries, backoff := module.retryPolicy()
+
+	return withRetries(ctx, timeout, retries, backoff, func(ctx context.Context) error {
+		// Results of the failed attempt must not leak into the next one
+		details := getBuildDetails(ctx)
+		details.DefconfigDiff = ""
+		details.Boards = nil
+
+		if err := clearStagingDir(module.GetOutputDir()); err != nil {
+			return err
+		}
+
+		return module.buildFirmware(ctx, client)
+	})
+}
+
+// withRetries calls attempt until it succeeds, fails on non-transient error or runs out of retries
+func withRetries(ctx context.Context, timeout time.Duration, retries int, backoff time.Duration, attempt func(context.Context) error) error {
+	for try := 0; ; try++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, timeout)
+		}
+
+		err := attempt(attemptCtx)
+		// Deadline of this attempt expired, not the one of the whole build
+		timedOut := errors.Is(attemptCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil
+
+		cancel()
+
+		if err == nil {
+			return nil
+		}
+
+		if timedOut {
+			err = errors.Join(ErrBuildTimeout, err)
+			slog.Error(
+				fmt.Sprintf("Build did not finish within %s", timeout),
+				slog.String("suggestion", "Increase 'timeout' of the module, or check if the build is not stuck"),
+				slog.Any("error", err),
+			)
+
+			return err
+		}
+
+		if ctx.Err() != nil || try >= retries || !container.IsTransientError(err) {
+			return err
+		}
+
+		wait := backoff << try
+		slog.Warn(
+			fmt.Sprintf("Build failed on transient error, retrying in %s (retry %d of %d)", wait, try+1, retries),
+			slog.Any("error", err),
+		)
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / retry
+package recipes
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithRetries(t *testing.T) {
+	errTransient := errors.New("timed out waiting for session params")
+	errBuild := errors.New("make: *** [Makefile:42] Error 2")
+
+	testCases := []struct {
+		name         string
+		timeout      time.Duration
+		retries      int
+		failures     []error
+		wantErr      error
+		wantAttempts int
+	}{
+		{
+			name:         "success",
+			retries:      2,
+			wantAttempts: 1,
+		},
+		{
+			name:         "transient failure is retried",
+			retries:      2,
+			failures:     []error{errTransient, errTransient},
+			wantAttempts: 3,
+		},
+		{
+			name:         "retries run out",
+			retries:      1,
+			failures:     []error{errTransient, errTransient},
+			wantErr:      errTransient,
+			wantAttempts: 2,
+		},
+		{
+			name:         "build failure is not retried",
+			retries:      2,
+			failures:     []error{errBuild},
+			wantErr:      errBuild,
+			wantAttempts: 1,
+		},
+		{
+			name:         "timeout is not retried",
+			timeout:      time.Millisecond,
+			retries:      2,
+			wantErr:      ErrBuildTimeout,
+			wantAttempts: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			attempts := 0
+
+			err := withRetries(context.Background(), tc.timeout, tc.retries, time.Millisecond, func(ctx context.Context) error {
+				attempts++
+
+				if tc.timeout > 0 {
+					// Pretend the build is stuck
+					<-ctx.Done()
+
+					return ctx.Err()
+				}
+
+				if attempts <= len(tc.failures) {
+					return tc.failures[attempts-1]
+				}
+
+				return nil
+			})
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.wantAttempts, attempts)
+		})
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	timeout, retries, backoff := CommonOpts{Timeout: "2h", Retries: 3, RetryBackoff: "1m"}.retryPolicy()
+	assert.Equal(t, 2*time.Hour, timeout)
+	assert.Equal(t, 3, retries)
+	assert.Equal(t, time.Minute, backoff)
+
+	timeout, retries, backoff = CommonOpts{}.retryPolicy()
+	assert.Equal(t, time.Duration(0), timeout)
+	assert.Equal(t, 0, retries)
+	assert.Equal(t, defaultRetryBackoff, backoff)
+}
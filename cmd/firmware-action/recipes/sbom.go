@@ -0,0 +1,347 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / sbom
+package recipes
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"dagger.io/dagger"
+	"github.com/9elements/firmware-action/cmd/firmware-action/container"
+	"github.com/9elements/firmware-action/cmd/firmware-action/filesystem"
+)
+
+// SBOMFileName is name of the SBOM file written into output directory of each module
+const SBOMFileName = "sbom.spdx.json"
+
+// sbomBlob is a binary blob built into the firmware, listed as a component in SBOM
+type sbomBlob struct {
+	Path    string // Path to the blob on host (file or directory)
+	Comment string // What the blob is used for
+}
+
+// SPDX 2.3 document, only the subset of fields firmware-action fills in
+//   https://spdx.github.io/spdx-spec/v2.3/
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID                string         `json:"SPDXID"`
+	Name                  string         `json:"name"`
+	VersionInfo           string         `json:"versionInfo,omitempty"`
+	PackageFileName       string         `json:"packageFileName,omitempty"`
+	DownloadLocation      string         `json:"downloadLocation"`
+	FilesAnalyzed         bool           `json:"filesAnalyzed"`
+	Checksums             []spdxChecksum `json:"checksums,omitempty"`
+	PrimaryPackagePurpose string         `json:"primaryPackagePurpose,omitempty"`
+	Comment               string         `json:"comment,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// spdxNoAssertion is used when the value is unknown
+const spdxNoAssertion = "NOASSERTION"
+
+// spdxInvalidIDPattern matches characters which are not allowed in SPDX identifier
+var spdxInvalidIDPattern = regexp.MustCompile(`[^a-zA-Z0-9.-]+`)
+
+// spdxIDs hands out SPDX identifiers, the same kind and name always get the same identifier
+// Different names which turn into the same identifier (for example 'a_b' and 'a-b') get numeric suffix
+type spdxIDs struct {
+	// Map of kind and name -> identifier
+	assigned map[[2]string]string
+
+	// Identifiers already handed out
+	used map[string]bool
+}
+
+// newSpdxIDs creates empty set of SPDX identifiers
+func newSpdxIDs() *spdxIDs {
+	return &spdxIDs{
+		assigned: map[[2]string]string{},
+		used:     map[string]bool{},
+	}
+}
+
+// id turns arbitrary string into valid and unique SPDX identifier
+func (ids *spdxIDs) id(kind string, name string) string {
+	key := [2]string{kind, name}
+	if id, ok := ids.assigned[key]; ok {
+		return id
+	}
+
+	base := fmt.Sprintf("SPDXRef-%s-%s", kind, spdxInvalidIDPattern.ReplaceAllString(name, "-"))
+	id := base
+
+	for suffix := 2; ids.used[id]; suffix++ {
+		id = fmt.Sprintf("%s-%d", base, suffix)
+	}
+
+	ids.assigned[key] = id
+	ids.used[id] = true
+
+	return id
+}
+
+// dependencyChain returns the target and all modules it (transitively) depends on, sorted by name
+func dependencyChain(target string, modules map[string]FirmwareModule) []string {
+	chain := []string{}
+	queue := []string{target}
+
+	for len(queue) > 0 {
+		item := queue[0]
+		queue = queue[1:]
+
+		if slices.Contains(chain, item) {
+			continue
+		}
+
+		chain = append(chain, item)
+
+		if module, ok := modules[item]; ok {
+			queue = append(queue, module.GetDepends()...)
+		}
+	}
+
+	slices.Sort(chain)
+
+	return chain
+}
+
+// NewSBOM creates SPDX SBOM of the target, listing the whole dependency chain with versions,
+// blobs and container images
+func NewSBOM(ctx context.Context, client *dagger.Client, target string, config *Config) ([]byte, error) {
+	modules := config.AllModules()
+	created := time.Now().UTC()
+
+	namespaceHash := sha256.Sum256([]byte(target + created.String()))
+	document := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              target,
+		DocumentNamespace: fmt.Sprintf("https://github.com/9elements/firmware-action/spdx/%s-%s", target, hex.EncodeToString(namespaceHash[:8])),
+		CreationInfo: spdxCreationInfo{
+			Created:  created.Format(time.RFC3339),
+			Creators: []string{fmt.Sprintf("Tool: firmware-action-%s", FirmwareActionVersion)},
+		},
+		Packages:      []spdxPackage{},
+		Relationships: []spdxRelationship{},
+	}
+
+	ids := newSpdxIDs()
+	images := map[string]string{} // Map of image reference -> SPDX ID
+	blobs := map[string]int{}     // Map of blob file -> index in packages
+
+	for _, moduleID := range dependencyChain(target, modules) {
+		module := modules[moduleID]
+		moduleSPDXID := ids.id("Module", moduleID)
+
+		// Module itself
+		sourceVersion, err := filesystem.GitDescribe(module.GetRepoPath())
+		if err != nil {
+			slog.Warn(
+				fmt.Sprintf("Failed to get git version of '%s', it will be missing in the SBOM", module.GetRepoPath()),
+				slog.Any("error", err),
+			)
+
+			sourceVersion = ""
+		}
+
+		document.Packages = append(document.Packages, spdxPackage{
+			SPDXID:                moduleSPDXID,
+			Name:                  moduleID,
+			VersionInfo:           sourceVersion,
+			DownloadLocation:      spdxNoAssertion,
+			PrimaryPackagePurpose: "FIRMWARE",
+			Comment:               fmt.Sprintf("firmware-action module of type '%s' built from '%s'", config.ModuleType(moduleID), module.GetRepoPath()),
+		})
+
+		if moduleID == target {
+			document.Relationships = append(document.Relationships, spdxRelationship{
+				SPDXElementID:      document.SPDXID,
+				RelationshipType:   "DESCRIBES",
+				RelatedSPDXElement: moduleSPDXID,
+			})
+		}
+
+		for _, dependency := range module.GetDepends() {
+			document.Relationships = append(document.Relationships, spdxRelationship{
+				SPDXElementID:      moduleSPDXID,
+				RelationshipType:   "DEPENDS_ON",
+				RelatedSPDXElement: ids.id("Module", dependency),
+			})
+		}
+
+		// Container image used to build the module
+		image := container.ImageReference(ctx, client, module.GetSdkURL())
+		if _, ok := images[image]; !ok {
+			images[image] = ids.id("Image", fmt.Sprintf("%d", len(images)))
+			document.Packages = append(document.Packages, imagePackage(images[image], image))
+		}
+
+		document.Relationships = append(document.Relationships, spdxRelationship{
+			SPDXElementID:      images[image],
+			RelationshipType:   "BUILD_TOOL_OF",
+			RelatedSPDXElement: moduleSPDXID,
+		})
+
+		// Blobs, the same file can be used multiple times (for example the same payload added into
+		//   multiple CBFS regions), but it is listed only once
+		for _, blob := range module.sbomBlobs() {
+			packages, err := blobPackages(blob)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, blobPackage := range packages {
+				index, ok := blobs[blobPackage.PackageFileName]
+				if !ok {
+					blobPackage.SPDXID = ids.id("Blob", blobPackage.PackageFileName)
+					index = len(document.Packages)
+					blobs[blobPackage.PackageFileName] = index
+					document.Packages = append(document.Packages, blobPackage)
+				} else if !slices.Contains(strings.Split(document.Packages[index].Comment, "; "), blobPackage.Comment) {
+					document.Packages[index].Comment += "; " + blobPackage.Comment
+				}
+
+				relationship := spdxRelationship{
+					SPDXElementID:      moduleSPDXID,
+					RelationshipType:   "CONTAINS",
+					RelatedSPDXElement: document.Packages[index].SPDXID,
+				}
+				if !slices.Contains(document.Relationships, relationship) {
+					document.Relationships = append(document.Relationships, relationship)
+				}
+			}
+		}
+	}
+
+	return json.MarshalIndent(document, "", "  ")
+}
+
+// imagePackage returns SPDX package describing container image
+func imagePackage(id string, image string) spdxPackage {
+	imagePackage := spdxPackage{
+		SPDXID:                id,
+		Name:                  image,
+		DownloadLocation:      spdxNoAssertion,
+		PrimaryPackagePurpose: "CONTAINER",
+		Comment:               "container image used to build the firmware",
+	}
+
+	// Reference with digest looks like 'ghcr.io/9elements/firmware-action/coreboot_4.19@sha256:...'
+	if name, digest, found := strings.Cut(image, "@sha256:"); found {
+		imagePackage.Name = name
+		imagePackage.VersionInfo = "sha256:" + digest
+		imagePackage.DownloadLocation = image
+		imagePackage.Checksums = []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: digest}}
+	}
+
+	return imagePackage
+}
+
+// blobPackages returns SPDX packages describing the blob, directories are listed file by file
+// SPDX identifiers are left empty, they are assigned once per file in NewSBOM
+func blobPackages(blob sbomBlob) ([]spdxPackage, error) {
+	info, err := os.Stat(blob.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			slog.Warn(fmt.Sprintf("Blob '%s' does not exist, it will be missing in the SBOM", blob.Path))
+
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	files := []string{filepath.Clean(blob.Path)}
+
+	if info.IsDir() {
+		relPaths, err := listOutputFiles(blob.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		files = []string{}
+		for _, relPath := range relPaths {
+			files = append(files, filepath.Join(blob.Path, relPath))
+		}
+	}
+
+	packages := []spdxPackage{}
+
+	for _, file := range files {
+		hashes, err := hashFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		packages = append(packages, spdxPackage{
+			Name:                  filepath.Base(file),
+			PackageFileName:       filepath.ToSlash(file),
+			DownloadLocation:      spdxNoAssertion,
+			PrimaryPackagePurpose: "FIRMWARE",
+			Comment:               blob.Comment,
+			Checksums: []spdxChecksum{
+				{Algorithm: "SHA256", ChecksumValue: hashes.SHA256},
+				{Algorithm: "SHA512", ChecksumValue: hashes.SHA512},
+			},
+		})
+	}
+
+	return packages, nil
+}
+
+// WriteSBOM writes SBOM into output directory of the module
+func WriteSBOM(outputDir string, sbom []byte) error {
+	sbomPath := filepath.Join(outputDir, SBOMFileName)
+
+	err := os.WriteFile(sbomPath, append(sbom, '\n'), 0o644)
+	if err != nil {
+		slog.Error(
+			fmt.Sprintf("Failed to write SBOM '%s'", sbomPath),
+			slog.Any("error", err),
+		)
+
+		return err
+	}
+
+	slog.Info(fmt.Sprintf("SBOM written to '%s'", sbomPath))
+
+	return nil
+}
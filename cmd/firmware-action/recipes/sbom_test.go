@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / sbom
+package recipes
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDependencyChain(t *testing.T) {
+	config := Config{
+		Coreboot: map[string]CorebootOpts{
+			"coreboot": {Depends: []string{"linux", "edk2"}},
+		},
+		Linux: map[string]LinuxOpts{
+			"linux": {Depends: []string{"u-root"}},
+		},
+		URoot: map[string]URootOpts{
+			"u-root": {},
+		},
+		Edk2: map[string]Edk2Opts{
+			"edk2":   {Depends: []string{"u-root"}},
+			"unused": {},
+		},
+	}
+
+	assert.Equal(t, []string{"coreboot", "edk2", "linux", "u-root"}, dependencyChain("coreboot", config.AllModules()))
+	assert.Equal(t, []string{"linux", "u-root"}, dependencyChain("linux", config.AllModules()))
+}
+
+func TestImagePackage(t *testing.T) {
+	image := imagePackage("SPDXRef-Image-0", "ghcr.io/9elements/firmware-action/coreboot_4.19@sha256:0123abcd")
+	assert.Equal(t, "ghcr.io/9elements/firmware-action/coreboot_4.19", image.Name)
+	assert.Equal(t, "sha256:0123abcd", image.VersionInfo)
+	assert.Equal(t, []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: "0123abcd"}}, image.Checksums)
+
+	// Image without digest (for example built from Dockerfile)
+	image = imagePackage("SPDXRef-Image-1", "file://docker/Dockerfile")
+	assert.Equal(t, "file://docker/Dockerfile", image.Name)
+	assert.Empty(t, image.Checksums)
+}
+
+func TestSpdxIDs(t *testing.T) {
+	ids := newSpdxIDs()
+
+	assert.Equal(t, "SPDXRef-Module-a-b", ids.id("Module", "a-b"))
+	// Different name turning into the same identifier
+	assert.Equal(t, "SPDXRef-Module-a-b-2", ids.id("Module", "a_b"))
+	// The same name gets the same identifier
+	assert.Equal(t, "SPDXRef-Module-a-b", ids.id("Module", "a-b"))
+	assert.Equal(t, "SPDXRef-Blob-a-b", ids.id("Blob", "a-b"))
+}
+
+func TestNewSBOM(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Chdir(tmpDir)
+
+	assert.NoError(t, os.MkdirAll("blobs/fsp", 0o755))
+	assert.NoError(t, os.WriteFile("blobs/fsp/fsp.fd", []byte("test"), 0o644))
+	assert.NoError(t, os.WriteFile("blobs/me.bin", []byte{}, 0o644))
+	assert.NoError(t, os.WriteFile("blobs/payload.elf", []byte{}, 0o644))
+
+	// Dockerfile based image, so that there is no need to connect to dagger
+	common := CommonOpts{
+		SdkURL:   "file://docker/Dockerfile",
+		RepoPath: ".",
+	}
+
+	config := Config{
+		Coreboot: map[string]CorebootOpts{
+			"coreboot": {
+				CommonOpts: common,
+				Blobs: map[string]string{
+					"CONFIG_FSP_FD_PATH": "blobs/fsp",
+					"CONFIG_MISSING":     "blobs/missing.bin",
+				},
+				// The same payload in multiple regions
+				CbfstoolEntries: []CbfstoolEntry{
+					{Operation: "add-payload", Path: "blobs/payload.elf", Name: "fallback/payload", Region: "COREBOOT"},
+					{Operation: "add-payload", Path: "blobs/payload.elf", Name: "fallback/payload", Region: "FW_MAIN_A"},
+				},
+			},
+		},
+		FirmwareStitching: map[string]FirmwareStitchingOpts{
+			"stitching": {
+				CommonOpts:   common,
+				Depends:      []string{"coreboot"},
+				BaseFilePath: "blobs/me.bin",
+				// Base file is also used by another module
+				CbfstoolEntries: []CbfstoolEntry{
+					{Operation: "add", Path: "blobs/payload.elf", Name: "img/payload", Type: "raw"},
+				},
+			},
+		},
+	}
+
+	data, err := NewSBOM(t.Context(), nil, "stitching", &config)
+	assert.NoError(t, err)
+
+	var document spdxDocument
+
+	assert.NoError(t, json.Unmarshal(data, &document))
+	assert.Equal(t, "SPDX-2.3", document.SPDXVersion)
+
+	packages := map[string]spdxPackage{}
+	for _, item := range document.Packages {
+		packages[item.SPDXID] = item
+	}
+
+	// Both modules, single shared image, three existing blobs, each listed once
+	assert.Len(t, packages, 6)
+	assert.Contains(t, packages, "SPDXRef-Module-stitching")
+	assert.Contains(t, packages, "SPDXRef-Module-coreboot")
+	assert.Equal(t, "file://docker/Dockerfile", packages["SPDXRef-Image-0"].Name)
+
+	fsp := packages["SPDXRef-Blob-blobs-fsp-fsp.fd"]
+	assert.Equal(t, "fsp.fd", fsp.Name)
+	assert.Equal(t, "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", fsp.Checksums[0].ChecksumValue)
+
+	payload := packages["SPDXRef-Blob-blobs-payload.elf"]
+	assert.Equal(t, "added into CBFS with cbfstool", payload.Comment)
+
+	// One relationship for each module using the blob
+	contains := []string{}
+	for _, relationship := range document.Relationships {
+		if relationship.RelatedSPDXElement == payload.SPDXID {
+			assert.Equal(t, "CONTAINS", relationship.RelationshipType)
+			contains = append(contains, relationship.SPDXElementID)
+		}
+	}
+	assert.ElementsMatch(t, []string{"SPDXRef-Module-coreboot", "SPDXRef-Module-stitching"}, contains)
+
+	assert.Contains(t, document.Relationships, spdxRelationship{
+		SPDXElementID:      "SPDXRef-DOCUMENT",
+		RelationshipType:   "DESCRIBES",
+		RelatedSPDXElement: "SPDXRef-Module-stitching",
+	})
+	assert.Contains(t, document.Relationships, spdxRelationship{
+		SPDXElementID:      "SPDXRef-Module-stitching",
+		RelationshipType:   "DEPENDS_ON",
+		RelatedSPDXElement: "SPDXRef-Module-coreboot",
+	})
+}
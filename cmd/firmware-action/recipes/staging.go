@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / staging
+package recipes
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// PreviousOutputSuffix is appended to output directory to get directory with output of the previous build,
+// see 'keep_previous_output'
+const PreviousOutputSuffix = ".prev"
+
+// stagingDir returns directory next to output directory, into which the artifacts are exported during build
+// It is on the same filesystem as output directory, so that it can be renamed
+func stagingDir(outputDir string) string {
+	outputDir = filepath.Clean(outputDir)
+
+	return filepath.Join(filepath.Dir(outputDir), fmt.Sprintf(".%s.staging", filepath.Base(outputDir)))
+}
+
+// prepareStagingDir creates empty staging directory, leftovers of previous builds are removed
+func prepareStagingDir(outputDir string) (string, error) {
+	staging := stagingDir(outputDir)
+
+	return staging, clearStagingDir(staging)
+}
+
+// clearStagingDir removes everything from staging directory, so that it holds artifacts
+// of a single build attempt only
+func clearStagingDir(staging string) error {
+	err := os.RemoveAll(staging)
+	if err == nil {
+		err = os.MkdirAll(staging, os.ModePerm)
+	}
+
+	if err != nil {
+		slog.Error(
+			fmt.Sprintf("Failed to prepare staging directory '%s'", staging),
+			slog.String("suggestion", "Check permissions of the parent directory of 'output_dir'"),
+			slog.Any("error", err),
+		)
+	}
+
+	return err
+}
+
+// swapOutputDir replaces output directory with the staging directory
+// The old output is either moved aside as '<output_dir>.prev', or removed
+func swapOutputDir(staging string, outputDir string, keepPrevious bool) error {
+	outputDir = filepath.Clean(outputDir)
+
+	previous := outputDir + PreviousOutputSuffix
+	if !keepPrevious {
+		previous = filepath.Join(filepath.Dir(outputDir), fmt.Sprintf(".%s.old", filepath.Base(outputDir)))
+	}
+
+	if err := os.RemoveAll(previous); err != nil {
+		return err
+	}
+
+	_, err := os.Stat(outputDir)
+	hasPrevious := err == nil
+
+	if hasPrevious {
+		if err := os.Rename(outputDir, previous); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(staging, outputDir); err != nil {
+		if hasPrevious {
+			// Put the old output back, it is still better than nothing
+			err = errors.Join(err, os.Rename(previous, outputDir))
+		}
+
+		slog.Error(
+			fmt.Sprintf("Failed to move new artifacts into '%s'", outputDir),
+			slog.String("suggestion", fmt.Sprintf("The artifacts might be left in '%s'", staging)),
+			slog.Any("error", err),
+		)
+
+		return err
+	}
+
+	if hasPrevious && !keepPrevious {
+		return os.RemoveAll(previous)
+	}
+
+	return nil
+}
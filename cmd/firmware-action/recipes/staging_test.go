@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / staging
+package recipes
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStagingDir(t *testing.T) {
+	assert.Equal(t, ".output-coreboot.staging", stagingDir("output-coreboot/"))
+	assert.Equal(t, filepath.Join("build", ".coreboot.staging"), stagingDir("build/coreboot"))
+}
+
+func TestSwapOutputDir(t *testing.T) {
+	testCases := []struct {
+		name         string
+		oldOutput    bool
+		keepPrevious bool
+	}{
+		{name: "first build", oldOutput: false},
+		{name: "rebuild", oldOutput: true},
+		{name: "rebuild keep previous", oldOutput: true, keepPrevious: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			outputDir := filepath.Join(tmpDir, "output")
+
+			if tc.oldOutput {
+				assert.NoError(t, os.MkdirAll(outputDir, 0o755))
+				assert.NoError(t, os.WriteFile(filepath.Join(outputDir, "coreboot.rom"), []byte("old"), 0o644))
+			}
+
+			staging, err := prepareStagingDir(outputDir)
+			assert.NoError(t, err)
+			assert.NoError(t, os.WriteFile(filepath.Join(staging, "coreboot.rom"), []byte("new"), 0o644))
+
+			assert.NoError(t, swapOutputDir(staging, outputDir, tc.keepPrevious))
+
+			content, err := os.ReadFile(filepath.Join(outputDir, "coreboot.rom"))
+			assert.NoError(t, err)
+			assert.Equal(t, "new", string(content))
+
+			assert.NoDirExists(t, staging)
+
+			if tc.keepPrevious {
+				content, err = os.ReadFile(filepath.Join(outputDir+PreviousOutputSuffix, "coreboot.rom"))
+				assert.NoError(t, err)
+				assert.Equal(t, "old", string(content))
+			} else {
+				// Only the output directory is left
+				entries, err := os.ReadDir(tmpDir)
+				assert.NoError(t, err)
+				assert.Len(t, entries, 1)
+			}
+		})
+	}
+}
+
+func TestClearStagingDir(t *testing.T) {
+	staging, err := prepareStagingDir(filepath.Join(t.TempDir(), "output"))
+	assert.NoError(t, err)
+
+	// Artifacts of failed attempt
+	assert.NoError(t, os.WriteFile(filepath.Join(staging, "coreboot.rom"), []byte("partial"), 0o644))
+
+	assert.NoError(t, clearStagingDir(staging))
+
+	entries, err := os.ReadDir(staging)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
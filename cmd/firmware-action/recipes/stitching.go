@@ -11,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 
 	"dagger.io/dagger"
@@ -78,7 +79,8 @@ type FirmwareStitchingOpts struct {
 	IfdtoolEntries []IfdtoolEntry `json:"ifdtool_entries"`
 
 	// List of instructions for cbfstool
-	// TODO ???
+	// Applied after all ifdtool entries, so that CBFS changes are not overwritten by injected regions
+	CbfstoolEntries []CbfstoolEntry `json:"cbfstool_entries" validate:"dive"`
 }
 
 // ANCHOR_END: FirmwareStitchingOpts
@@ -93,6 +95,13 @@ func (opts FirmwareStitchingOpts) GetArtifacts() *[]container.Artifacts {
 	return opts.CommonOpts.GetArtifacts()
 }
 
+// withCommonOpts returns a copy of the module with modified common options
+func (opts FirmwareStitchingOpts) withCommonOpts(modify func(opts *CommonOpts)) FirmwareModule {
+	modify(&opts.CommonOpts)
+
+	return opts
+}
+
 // ExtractSizeFromString uses regex to find size of ROM in MB
 func ExtractSizeFromString(text string) ([]uint64, error) {
 	// Component 1 and 2 represent flash chips on motherboard
@@ -167,8 +176,34 @@ func ifdtoolCmd(platform string, arguments []string) []string {
 	return cmd
 }
 
-// buildFirmware builds coreboot with all blobs and stuff
-func (opts FirmwareStitchingOpts) buildFirmware(ctx context.Context, client *dagger.Client) error {
+// sbomBlobs returns list of binary blobs built into the firmware, the base file included
+func (opts FirmwareStitchingOpts) sbomBlobs() []sbomBlob {
+	blobs := []sbomBlob{{
+		Path:    opts.BaseFilePath,
+		Comment: "base file for firmware stitching",
+	}}
+
+	for _, entry := range opts.IfdtoolEntries {
+		blobs = append(blobs, sbomBlob{
+			Path:    entry.Path,
+			Comment: fmt.Sprintf("injected into region '%s' with ifdtool", entry.TargetRegion),
+		})
+	}
+
+	return append(blobs, cbfstoolSbomBlobs(opts.CbfstoolEntries)...)
+}
+
+// prepareContainer spins up a container with the base file and all files to inject, there are no
+// static build steps because they depend on the size of the base file
+func (opts FirmwareStitchingOpts) prepareContainer(ctx context.Context, client *dagger.Client) (*dagger.Container, [][]string, error) {
+	myContainer, _, err := opts.setupContainer(ctx, client)
+
+	return myContainer, nil, err
+}
+
+// setupContainer spins up a container with the base file and all files to inject, returns also
+// the ifdtool entries updated with paths inside the container
+func (opts FirmwareStitchingOpts) setupContainer(ctx context.Context, client *dagger.Client) (*dagger.Container, []IfdtoolEntry, error) {
 	// Check that all files have unique filenames (they are copied into the same dir)
 	copiedFiles := map[string]string{}
 
@@ -181,28 +216,41 @@ func (opts FirmwareStitchingOpts) buildFirmware(ctx context.Context, client *dag
 				slog.Any("error", os.ErrExist),
 			)
 
-			return os.ErrExist
+			return nil, nil, os.ErrExist
 		}
 
 		copiedFiles[filename] = entry.Path
 	}
 
+	secrets, err := opts.GetSecrets()
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Spin up container
 	containerOpts := container.SetupOpts{
 		ContainerURL:      opts.SdkURL,
 		MountContainerDir: ContainerWorkDir,
 		MountHostDir:      opts.RepoPath,
 		WorkdirContainer:  ContainerWorkDir,
+		EnvVars:           opts.GetEnvVars(),
+		Secrets:           secrets,
 	}
 
-	myContainer, err := container.Setup(ctx, client, &containerOpts)
+	myContainer, err := opts.setupModuleContainer(ctx, client, &containerOpts)
 	if err != nil {
 		slog.Error(
 			"Failed to start a container",
 			slog.Any("error", err),
 		)
 
-		return err
+		return nil, nil, err
+	}
+
+	// Apply patches before anything else touches the repository
+	myContainer, err = opts.applyPatches(ctx, client, myContainer)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Copy all the files into container
@@ -214,20 +262,18 @@ func (opts FirmwareStitchingOpts) buildFirmware(ctx context.Context, client *dag
 			slog.Any("error", err),
 		)
 
-		return err
+		return nil, nil, err
 	}
 
-	newBaseFilePath := filepath.Join(ContainerWorkDir, filepath.Base(opts.BaseFilePath))
 	myContainer = myContainer.WithFile(
-		newBaseFilePath,
+		filepath.Join(ContainerWorkDir, filepath.Base(opts.BaseFilePath)),
 		client.Host().File(filepath.Join(pwd, opts.BaseFilePath)),
 	)
-	oldBaseFilePath := opts.BaseFilePath
 
-	opts.BaseFilePath = newBaseFilePath
-	for entry := range opts.IfdtoolEntries {
-		containerPath := filepath.Join(ContainerWorkDir, filepath.Base(opts.IfdtoolEntries[entry].Path))
-		hostPath := filepath.Join(pwd, opts.IfdtoolEntries[entry].Path)
+	entries := slices.Clone(opts.IfdtoolEntries)
+	for entry := range entries {
+		containerPath := filepath.Join(ContainerWorkDir, filepath.Base(entries[entry].Path))
+		hostPath := filepath.Join(pwd, entries[entry].Path)
 		hostFile := client.Host().File(hostPath)
 
 		// Check if the file exists on host filesystem
@@ -237,29 +283,60 @@ func (opts FirmwareStitchingOpts) buildFirmware(ctx context.Context, client *dag
 				containerPath,
 				hostFile,
 			)
-			opts.IfdtoolEntries[entry].Path = containerPath
-		} else if opts.IfdtoolEntries[entry].IgnoreIfMissing {
+			entries[entry].Path = containerPath
+		} else if entries[entry].IgnoreIfMissing {
 			// We can ignore this missing file
-			opts.IfdtoolEntries[entry].Skip = true
+			entries[entry].Skip = true
 			slog.Warn(
-				fmt.Sprintf("Can't copy file '%s' - does not exists, ignoring because 'ignore_if_missing' is set", opts.IfdtoolEntries[entry].Path),
+				fmt.Sprintf("Can't copy file '%s' - does not exists, ignoring because 'ignore_if_missing' is set", entries[entry].Path),
 			)
 		} else {
 			// We cannot ignore this missing file
 			slog.Error(
-				fmt.Sprintf("Can't copy file '%s' - does not exists", opts.IfdtoolEntries[entry].Path),
+				fmt.Sprintf("Can't copy file '%s' - does not exists", entries[entry].Path),
 				slog.String("suggestion", "Double check provided path to file"),
 				slog.Any("error", err),
 			)
 
-			return err
+			return nil, nil, err
 		}
 	}
 
+	return myContainer, entries, nil
+}
+
+// buildFirmware builds coreboot with all blobs and stuff
+func (opts FirmwareStitchingOpts) buildFirmware(ctx context.Context, client *dagger.Client) error {
+	myContainer, entries, err := opts.setupContainer(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	pwd, err := os.Getwd()
+	if err != nil {
+		slog.Error(
+			"Could not get working directory",
+			slog.String("suggestion", logging.ThisShouldNotHappenMessage),
+			slog.Any("error", err),
+		)
+
+		return err
+	}
+
+	oldBaseFilePath := opts.BaseFilePath
+	opts.BaseFilePath = filepath.Join(ContainerWorkDir, filepath.Base(opts.BaseFilePath))
+	opts.IfdtoolEntries = entries
+
 	// Get the size of image (total size)
 	cmd := ifdtoolCmd(opts.Platform, []string{"--dump", opts.BaseFilePath})
 
-	ifdtoolStdout, err := myContainer.WithExec(cmd).Stdout(ctx)
+	ifdtoolContainer, err := opts.runBuildSteps(ctx, myContainer, [][]string{cmd})
+
+	var ifdtoolStdout string
+	if err == nil {
+		ifdtoolStdout, err = ifdtoolContainer.Stdout(ctx)
+	}
+
 	if err != nil {
 		slog.Error(
 			"Failed to dump Intel Firmware Descriptor (IFD)",
@@ -339,6 +416,8 @@ func (opts FirmwareStitchingOpts) buildFirmware(ctx context.Context, client *dag
 	if err != nil {
 		return err
 	}
+	// Temporary file on host, the stitched image is exported from the container
+	defer os.Remove(imageFilename)
 
 	_, err = firmwareImageFile.Write(firmwareImage)
 	if err != nil {
@@ -353,16 +432,9 @@ func (opts FirmwareStitchingOpts) buildFirmware(ctx context.Context, client *dag
 	)
 
 	// Populate regions with ifdtool
-	for entry := range opts.IfdtoolEntries {
-		slog.Info(
-			fmt.Sprintf(
-				"Injecting '%s' into '%s' region in '%s'",
-				opts.IfdtoolEntries[entry].Path,
-				opts.IfdtoolEntries[entry].TargetRegion,
-				imageFilename,
-			),
-		)
+	buildSteps := [][]string{}
 
+	for entry := range opts.IfdtoolEntries {
 		// Check if file exists, and if missing file can be ignored
 		if opts.IfdtoolEntries[entry].Skip {
 			slog.Warn(
@@ -372,38 +444,51 @@ func (opts FirmwareStitchingOpts) buildFirmware(ctx context.Context, client *dag
 			continue
 		}
 
-		// Inject binaries
-		cmd := ifdtoolCmd(
-			opts.Platform,
-			[]string{
-				"--inject",
-				fmt.Sprintf("%s:%s",
-					opts.IfdtoolEntries[entry].TargetRegion,
-					opts.IfdtoolEntries[entry].Path),
+		slog.Info(
+			fmt.Sprintf(
+				"Injecting '%s' into '%s' region in '%s'",
+				opts.IfdtoolEntries[entry].Path,
+				opts.IfdtoolEntries[entry].TargetRegion,
 				imageFilename,
-			},
+			),
 		)
 
-		myContainer, err = myContainer.WithExec(cmd).Sync(ctx)
-		if err != nil {
-			slog.Error("Failed to inject region")
-			return err
-		}
+		buildSteps = append(
+			buildSteps,
+			// inject binaries
+			ifdtoolCmd(
+				opts.Platform,
+				[]string{
+					"--inject",
+					fmt.Sprintf("%s:%s",
+						opts.IfdtoolEntries[entry].TargetRegion,
+						opts.IfdtoolEntries[entry].Path),
+					imageFilename,
+				},
+			),
+			// ifdtool makes a new file '<filename>.new', so let's rename back to original name
+			[]string{"mv", "--force", fmt.Sprintf("%s.new", imageFilename), imageFilename},
+		)
+	}
+
+	// Modify CBFS with cbfstool
+	myContainer, cbfstoolSteps, err := withCbfstoolEntries(client, myContainer, pwd, cbfstoolPath, imageFilename, opts.CbfstoolEntries, opts.Depends)
+	if err != nil {
+		return err
+	}
 
-		// ifdtool makes a new file '<filename>.new', so let's rename back to original name
-		imageFilenameNew := fmt.Sprintf("%s.new", imageFilename)
-		cmd = []string{"mv", "--force", imageFilenameNew, imageFilename}
+	buildSteps = append(buildSteps, cbfstoolSteps...)
 
-		myContainer, err = myContainer.WithExec(cmd).Sync(ctx)
-		if err != nil {
-			slog.Error(
-				fmt.Sprintf("Failed to rename '%s' to '%s'", imageFilenameNew, imageFilename),
-			)
+	myContainer, err = opts.runBuildSteps(ctx, myContainer, buildSteps)
+	if err != nil {
+		slog.Error(
+			"Failed to inject regions or modify CBFS",
+			slog.Any("error", err),
+		)
 
-			return err
-		}
+		return err
 	}
 
 	// Extract artifacts
-	return container.GetArtifacts(ctx, myContainer, opts.CommonOpts.GetArtifacts())
+	return exportArtifacts(ctx, myContainer, opts.CommonOpts.GetArtifacts())
 }
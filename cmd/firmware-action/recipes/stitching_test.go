@@ -194,8 +194,8 @@ func TestStitching(t *testing.T) {
 	common := CommonOpts{
 		SdkURL:    "ghcr.io/9elements/firmware-action/coreboot_4.19:main",
 		OutputDir: "output",
-		ContainerOutputFiles: []string{
-			fmt.Sprintf("new_%s", baseFileName),
+		ContainerOutputFiles: []ContainerOutputFile{
+			{From: fmt.Sprintf("new_%s", baseFileName)},
 		},
 	}
 
@@ -382,7 +382,7 @@ func TestStitching(t *testing.T) {
 			// Check artifacts
 			finalImageFile := filepath.Join(
 				outputPath,
-				tc.stitchingOpts.ContainerOutputFiles[0],
+				tc.stitchingOpts.ContainerOutputFiles[0].From,
 			)
 			if tc.wantErr == nil {
 				assert.ErrorIs(t, filesystem.CheckFileExists(finalImageFile), os.ErrExist)
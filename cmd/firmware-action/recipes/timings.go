@@ -0,0 +1,217 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / timings
+package recipes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"dagger.io/dagger"
+	"github.com/9elements/firmware-action/cmd/firmware-action/container"
+)
+
+// Phases of a module build, in the order in which they happen
+const (
+	PhaseChangeDetection = "change detection"
+	PhaseDaggerConnect   = "dagger connect"
+	PhaseContainerSetup  = "container setup"
+	PhaseBuildStep       = "build step"
+	PhaseArtifactExport  = "artifact export"
+	PhaseMetadata        = "metadata"
+)
+
+// Phases lists all phases of a module build, in the order in which they happen
+var Phases = []string{
+	PhaseChangeDetection,
+	PhaseDaggerConnect,
+	PhaseContainerSetup,
+	PhaseBuildStep,
+	PhaseArtifactExport,
+	PhaseMetadata,
+}
+
+// TimingsFile is file in StatusDir where timings of the last build are stored
+var TimingsFile = filepath.Join(StatusDir, "timings.json")
+
+// Timing is wall-clock time spent in a single phase of a module build
+type Timing struct {
+	Phase    string
+	Name     string
+	Start    time.Time
+	Duration time.Duration
+}
+
+// recordPhase starts measuring a phase of the build, the returned function stops it
+// Build steps are recorded one by one, name describes the particular step
+func recordPhase(ctx context.Context, phase string, name string) func() {
+	details := getBuildDetails(ctx)
+	start := time.Now()
+
+	return func() {
+		details.Timings = append(details.Timings, Timing{
+			Phase:    phase,
+			Name:     name,
+			Start:    start,
+			Duration: time.Since(start),
+		})
+	}
+}
+
+// PhaseDuration returns total time spent in the phase
+func (r BuildResults) PhaseDuration(phase string) time.Duration {
+	total := time.Duration(0)
+
+	for _, timing := range r.Details.Timings {
+		if timing.Phase == phase {
+			total += timing.Duration
+		}
+	}
+
+	return total
+}
+
+// setupContainer sets up the container and records how long it took
+// This includes pulling the image, copying the repository and input files into the container
+func setupContainer(ctx context.Context, client *dagger.Client, opts *container.SetupOpts) (*dagger.Container, error) {
+	defer recordPhase(ctx, PhaseContainerSetup, PhaseContainerSetup)()
+
+	return container.Setup(ctx, client, opts)
+}
+
+// exportArtifacts extracts artifacts from the container and records how long it took
+func exportArtifacts(ctx context.Context, myContainer *dagger.Container, artifacts *[]container.Artifacts) error {
+	defer recordPhase(ctx, PhaseArtifactExport, PhaseArtifactExport)()
+
+	return container.GetArtifacts(ctx, myContainer, artifacts)
+}
+
+// timingsModule is timings of single module as stored in TimingsFile
+type timingsModule struct {
+	ModuleID        string             `json:"module_id"`
+	DurationSeconds float64            `json:"duration_seconds"`
+	Phases          map[string]float64 `json:"phases"`
+	Steps           []timingsStep      `json:"steps"`
+}
+
+type timingsStep struct {
+	Phase           string    `json:"phase"`
+	Name            string    `json:"name"`
+	Start           time.Time `json:"start"`
+	DurationSeconds float64   `json:"duration_seconds"`
+}
+
+// WriteTimings writes timings of all modules into JSON file
+func WriteTimings(path string, results []BuildResults) error {
+	modules := []timingsModule{}
+
+	for _, item := range results {
+		module := timingsModule{
+			ModuleID:        item.Name,
+			DurationSeconds: item.Duration.Seconds(),
+			Phases:          map[string]float64{},
+			Steps:           []timingsStep{},
+		}
+
+		for _, phase := range Phases {
+			module.Phases[phase] = item.PhaseDuration(phase).Seconds()
+		}
+
+		for _, timing := range item.Details.Timings {
+			module.Steps = append(module.Steps, timingsStep{
+				Phase:           timing.Phase,
+				Name:            timing.Name,
+				Start:           timing.Start,
+				DurationSeconds: timing.Duration.Seconds(),
+			})
+		}
+
+		modules = append(modules, module)
+	}
+
+	data, err := json.MarshalIndent(modules, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeReportFile(path, data)
+}
+
+// Chrome trace event format, can be opened in Perfetto (https://ui.perfetto.dev) or chrome://tracing
+//   https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU
+
+type traceEvent struct {
+	Name      string         `json:"name"`
+	Category  string         `json:"cat,omitempty"`
+	Phase     string         `json:"ph"`
+	Timestamp int64          `json:"ts"`
+	Duration  int64          `json:"dur,omitempty"`
+	PID       int            `json:"pid"`
+	TID       int            `json:"tid"`
+	Args      map[string]any `json:"args,omitempty"`
+}
+
+type traceFile struct {
+	TraceEvents     []traceEvent `json:"traceEvents"`
+	DisplayTimeUnit string       `json:"displayTimeUnit"`
+}
+
+// WriteChromeTrace writes timings of all modules as Chrome trace-event file, each module is shown as a thread
+func WriteChromeTrace(path string, results []BuildResults) error {
+	trace := traceFile{
+		TraceEvents:     []traceEvent{},
+		DisplayTimeUnit: "ms",
+	}
+
+	for index, item := range results {
+		tid := index + 1
+
+		trace.TraceEvents = append(trace.TraceEvents, traceEvent{
+			Name:  "thread_name",
+			Phase: "M",
+			PID:   1,
+			TID:   tid,
+			Args:  map[string]any{"name": item.Name},
+		})
+
+		// Whole module, all phases are nested in it
+		trace.TraceEvents = append(trace.TraceEvents, traceEvent{
+			Name:      item.Name,
+			Category:  "module",
+			Phase:     "X",
+			Timestamp: item.Start.UnixMicro(),
+			Duration:  max(item.Duration.Microseconds(), 1),
+			PID:       1,
+			TID:       tid,
+			Args:      map[string]any{"status": item.Status()},
+		})
+
+		for _, timing := range item.Details.Timings {
+			trace.TraceEvents = append(trace.TraceEvents, traceEvent{
+				Name:      timing.Name,
+				Category:  timing.Phase,
+				Phase:     "X",
+				Timestamp: timing.Start.UnixMicro(),
+				Duration:  max(timing.Duration.Microseconds(), 1),
+				PID:       1,
+				TID:       tid,
+			})
+		}
+	}
+
+	data, err := json.Marshal(trace)
+	if err != nil {
+		return err
+	}
+
+	err = writeReportFile(path, data)
+	if err == nil {
+		slog.Info(fmt.Sprintf("Open '%s' in https://ui.perfetto.dev to inspect the timings", path))
+	}
+
+	return err
+}
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: MIT
+
+// Package recipes / timings
+package recipes
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordPhase(t *testing.T) {
+	details := BuildDetails{}
+	ctx := withBuildDetails(t.Context(), &details)
+
+	stop := recordPhase(ctx, PhaseBuildStep, "make")
+	time.Sleep(time.Millisecond)
+	stop()
+
+	recordPhase(ctx, PhaseBuildStep, "make install")()
+	recordPhase(ctx, PhaseArtifactExport, PhaseArtifactExport)()
+
+	assert.Len(t, details.Timings, 3)
+	assert.Equal(t, "make", details.Timings[0].Name)
+	assert.GreaterOrEqual(t, details.Timings[0].Duration, time.Millisecond)
+
+	result := BuildResults{Details: details}
+	assert.Equal(t, details.Timings[0].Duration+details.Timings[1].Duration, result.PhaseDuration(PhaseBuildStep))
+	assert.Equal(t, time.Duration(0), result.PhaseDuration(PhaseDaggerConnect))
+
+	// Without details in context nothing is recorded, and nothing breaks
+	recordPhase(t.Context(), PhaseBuildStep, "make")()
+}
+
+func TestWriteTimings(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	results := []BuildResults{
+		{
+			Name:     "coreboot",
+			Start:    start,
+			Duration: 3 * time.Second,
+			Details: BuildDetails{
+				Timings: []Timing{
+					{Phase: PhaseContainerSetup, Name: PhaseContainerSetup, Start: start, Duration: time.Second},
+					{Phase: PhaseBuildStep, Name: "make -j", Start: start.Add(time.Second), Duration: 2 * time.Second},
+				},
+			},
+		},
+	}
+
+	tmpDir := t.TempDir()
+
+	// JSON timings
+	timingsPath := filepath.Join(tmpDir, "timings.json")
+	assert.NoError(t, WriteTimings(timingsPath, results))
+
+	data, err := os.ReadFile(timingsPath)
+	assert.NoError(t, err)
+
+	var timings []timingsModule
+
+	assert.NoError(t, json.Unmarshal(data, &timings))
+	assert.Len(t, timings, 1)
+	assert.Equal(t, 1.0, timings[0].Phases[PhaseContainerSetup])
+	assert.Equal(t, 2.0, timings[0].Phases[PhaseBuildStep])
+	assert.Equal(t, 0.0, timings[0].Phases[PhaseDaggerConnect])
+	assert.Equal(t, "make -j", timings[0].Steps[1].Name)
+
+	// Chrome trace
+	tracePath := filepath.Join(tmpDir, "trace.json")
+	assert.NoError(t, WriteChromeTrace(tracePath, results))
+
+	data, err = os.ReadFile(tracePath)
+	assert.NoError(t, err)
+
+	var trace traceFile
+
+	assert.NoError(t, json.Unmarshal(data, &trace))
+	// Thread name, module and two phases
+	assert.Len(t, trace.TraceEvents, 4)
+	assert.Equal(t, "M", trace.TraceEvents[0].Phase)
+	assert.Equal(t, "coreboot", trace.TraceEvents[1].Name)
+	assert.Equal(t, int64(3000000), trace.TraceEvents[1].Duration)
+	assert.Equal(t, start.Add(time.Second).UnixMicro(), trace.TraceEvents[3].Timestamp)
+	assert.Equal(t, PhaseBuildStep, trace.TraceEvents[3].Category)
+}
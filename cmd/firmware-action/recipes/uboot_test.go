@@ -29,8 +29,8 @@ func TestUBoot(t *testing.T) {
 	UBootOpts := UBootOpts{
 		CommonOpts: CommonOpts{
 			OutputDir: "output",
-			ContainerOutputFiles: []string{
-				"u-boot",
+			ContainerOutputFiles: []ContainerOutputFile{
+				{From: "u-boot"},
 			},
 		},
 		DefconfigPath: "uboot_defconfig",
@@ -48,8 +48,20 @@ func (opts UniversalOpts) GetArtifacts() *[]container.Artifacts {
 	return opts.CommonOpts.GetArtifacts()
 }
 
-// buildFirmware builds (or rather executes) universal command module
-func (opts UniversalOpts) buildFirmware(ctx context.Context, client *dagger.Client) error {
+// withCommonOpts returns a copy of the module with modified common options
+func (opts UniversalOpts) withCommonOpts(modify func(opts *CommonOpts)) FirmwareModule {
+	modify(&opts.CommonOpts)
+
+	return opts
+}
+
+// prepareContainer spins up a container ready to build universal command module, returns it together with the build steps
+func (opts UniversalOpts) prepareContainer(ctx context.Context, client *dagger.Client) (*dagger.Container, [][]string, error) {
+	secrets, err := opts.GetSecrets()
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Spin up container
 	containerOpts := container.SetupOpts{
 		ContainerURL:      opts.SdkURL,
@@ -59,16 +71,24 @@ func (opts UniversalOpts) buildFirmware(ctx context.Context, client *dagger.Clie
 		ContainerInputDir: opts.ContainerInputDir,
 		InputDirs:         opts.InputDirs,
 		InputFiles:        opts.InputFiles,
+		EnvVars:           opts.GetEnvVars(),
+		Secrets:           secrets,
 	}
 
-	myContainer, err := container.Setup(ctx, client, &containerOpts)
+	myContainer, err := opts.setupModuleContainer(ctx, client, &containerOpts)
 	if err != nil {
 		slog.Error(
 			"Failed to start a container",
 			slog.Any("error", err),
 		)
 
-		return err
+		return nil, nil, err
+	}
+
+	// Apply patches before anything else touches the repository
+	myContainer, err = opts.applyPatches(ctx, client, myContainer)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Assemble commands to build
@@ -80,12 +100,18 @@ func (opts UniversalOpts) buildFirmware(ctx context.Context, client *dagger.Clie
 		)
 	}
 
-	// Execute build commands
-	for step := range buildSteps {
-		myContainer = myContainer.WithExec(buildSteps[step])
+	return myContainer, buildSteps, nil
+}
+
+// buildFirmware builds (or rather executes) universal command module
+func (opts UniversalOpts) buildFirmware(ctx context.Context, client *dagger.Client) error {
+	myContainer, buildSteps, err := opts.prepareContainer(ctx, client)
+	if err != nil {
+		return err
 	}
 
-	myContainer, err = myContainer.Sync(ctx)
+	// Execute build commands
+	myContainer, err = opts.runBuildSteps(ctx, myContainer, buildSteps)
 	if err != nil {
 		slog.Error(
 			"Failed to build universal",
@@ -96,5 +122,5 @@ func (opts UniversalOpts) buildFirmware(ctx context.Context, client *dagger.Clie
 	}
 
 	// Extract artifacts
-	return container.GetArtifacts(ctx, myContainer, opts.GetArtifacts())
+	return exportArtifacts(ctx, myContainer, opts.GetArtifacts())
 }
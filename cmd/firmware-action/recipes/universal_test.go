@@ -26,8 +26,8 @@ func TestUniversal(t *testing.T) {
 	UniversalOpts := UniversalOpts{
 		CommonOpts: CommonOpts{
 			OutputDir: "output",
-			ContainerOutputFiles: []string{
-				"hello.txt",
+			ContainerOutputFiles: []ContainerOutputFile{
+				{From: "hello.txt"},
 			},
 		},
 	}
@@ -48,8 +48,20 @@ func (opts URootOpts) GetArtifacts() *[]container.Artifacts {
 	return opts.CommonOpts.GetArtifacts()
 }
 
-// buildFirmware builds u-root
-func (opts URootOpts) buildFirmware(ctx context.Context, client *dagger.Client) error {
+// withCommonOpts returns a copy of the module with modified common options
+func (opts URootOpts) withCommonOpts(modify func(opts *CommonOpts)) FirmwareModule {
+	modify(&opts.CommonOpts)
+
+	return opts
+}
+
+// prepareContainer spins up a container ready to build u-root, returns it together with the build steps
+func (opts URootOpts) prepareContainer(ctx context.Context, client *dagger.Client) (*dagger.Container, [][]string, error) {
+	secrets, err := opts.GetSecrets()
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Spin up container
 	containerOpts := container.SetupOpts{
 		ContainerURL:      opts.SdkURL,
@@ -59,16 +71,24 @@ func (opts URootOpts) buildFirmware(ctx context.Context, client *dagger.Client)
 		ContainerInputDir: opts.ContainerInputDir,
 		InputDirs:         opts.InputDirs,
 		InputFiles:        opts.InputFiles,
+		EnvVars:           opts.GetEnvVars(),
+		Secrets:           secrets,
 	}
 
-	myContainer, err := container.Setup(ctx, client, &containerOpts)
+	myContainer, err := opts.setupModuleContainer(ctx, client, &containerOpts)
 	if err != nil {
 		slog.Error(
 			"Failed to start a container",
 			slog.Any("error", err),
 		)
 
-		return err
+		return nil, nil, err
+	}
+
+	// Apply patches before anything else touches the repository
+	myContainer, err = opts.applyPatches(ctx, client, myContainer)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Assemble commands to build
@@ -77,21 +97,27 @@ func (opts URootOpts) buildFirmware(ctx context.Context, client *dagger.Client)
 		{"bash", "-c", opts.BuildCommand},
 	}
 
+	return myContainer, buildSteps, nil
+}
+
+// buildFirmware builds u-root
+func (opts URootOpts) buildFirmware(ctx context.Context, client *dagger.Client) error {
+	myContainer, buildSteps, err := opts.prepareContainer(ctx, client)
+	if err != nil {
+		return err
+	}
+
 	// Execute build commands
-	for step := range buildSteps {
-		myContainer, err = myContainer.
-			WithExec(buildSteps[step]).
-			Sync(ctx)
-		if err != nil {
-			slog.Error(
-				"Failed to build u-root",
-				slog.Any("error", err),
-			)
-
-			return fmt.Errorf("u-root build failed: %w", err)
-		}
+	myContainer, err = opts.runBuildSteps(ctx, myContainer, buildSteps)
+	if err != nil {
+		slog.Error(
+			"Failed to build u-root",
+			slog.Any("error", err),
+		)
+
+		return fmt.Errorf("u-root build failed: %w", err)
 	}
 
 	// Extract artifacts
-	return container.GetArtifacts(ctx, myContainer, opts.GetArtifacts())
+	return exportArtifacts(ctx, myContainer, opts.GetArtifacts())
 }
@@ -26,8 +26,8 @@ func TestURoot(t *testing.T) {
 	URootOpts := URootOpts{
 		CommonOpts: CommonOpts{
 			OutputDir: "output",
-			ContainerOutputFiles: []string{
-				"initramfs.cpio",
+			ContainerOutputFiles: []ContainerOutputFile{
+				{From: "initramfs.cpio"},
 			},
 		},
 	}